@@ -0,0 +1,233 @@
+package api
+
+// Read-only JSON API over processed data of configured realms. It is
+// served from the processor state and result store only:
+//
+//	GET /api/realms                                  realms with last snapshot time
+//	GET /api/realms/{realm}/snapshot                 summary of the last snapshot
+//	GET /api/realms/{realm}/items/{item}/auctions    active auctions of item
+//	GET /api/realms/{realm}/items/{item}/history     daily statistics of item
+//	GET /api/realms/{realm}/closed[?item=]           closed auctions, newest first
+//	GET /api/realms/{realm}/sellers/{owner}[?realm=] seller profile and items
+//
+// Lists are paged by ?offset=&limit= (up to OFFSET_MAX and LIMIT_MAX)
+// and wrapped into Page.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+	parser "github.com/wowauc/gowowuction/parser"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const (
+	LIMIT_DEFAULT = 100
+	LIMIT_MAX     = 1000
+	OFFSET_MAX    = 1000000
+)
+
+var (
+	ErrBadParam     = errors.New("bad parameter")
+	ErrUnknownRealm = errors.New("unknown realm")
+	ErrNotFound     = errors.New("not found")
+)
+
+type Page struct {
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Total  int         `json:"total"`
+	Items  interface{} `json:"items"`
+}
+
+// processor state loaded for the modification time of its file
+type cachedState struct {
+	mtime time.Time
+	state *parser.AuctionProcessorState
+}
+
+type Server struct {
+	cf     *config.Config
+	mux    *http.ServeMux
+	mu     sync.Mutex
+	states map[string]*cachedState // by realm
+	log    *logging.Logger
+}
+
+// nil lg logs through the root logger
+func New(cf *config.Config, lg *logging.Logger) *Server {
+	s := &Server{cf: cf, mux: http.NewServeMux(), states: make(map[string]*cachedState), log: lg}
+	s.handle("GET /api/realms", s.getRealms)
+	s.handle("GET /api/realms/{realm}/snapshot", s.getSnapshot)
+	s.handle("GET /api/realms/{realm}/items/{item}/auctions", s.getItemAuctions)
+	s.handle("GET /api/realms/{realm}/items/{item}/history", s.getItemHistory)
+	s.handle("GET /api/realms/{realm}/closed", s.getClosed)
+	s.handle("GET /api/realms/{realm}/sellers/{owner}", s.getSeller)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handlers return value to be sent as json
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+func (s *Server) handle(pattern string, fn handlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		lg := s.log.With("realm", r.PathValue("realm"))
+		lg.Debugf("api %s %s", r.Method, r.URL)
+		v, err := fn(r)
+		if err != nil {
+			writeError(w, err, lg)
+			return
+		}
+		writeJSON(w, http.StatusOK, v, lg)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}, lg *logging.Logger) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		lg.Warnf("api response not written: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error, lg *logging.Logger) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrBadParam):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrUnknownRealm), errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	default:
+		lg.Warnf("api request failed: %s", err)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()}, lg)
+}
+
+// realm of request path, it must be enabled in config
+func (s *Server) realm(r *http.Request) (string, error) {
+	realm := r.PathValue("realm")
+	for _, name := range s.cf.RealmsList {
+		if name == realm {
+			return realm, nil
+		}
+	}
+	return "", fmt.Errorf("%w %#v", ErrUnknownRealm, realm)
+}
+
+func intParam(r *http.Request, name string, dflt int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return dflt, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w %s=%#v", ErrBadParam, name, s)
+	}
+	return n, nil
+}
+
+func int64Param(s, name string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%w %s=%#v", ErrBadParam, name, s)
+	}
+	return n, nil
+}
+
+// ?from= and ?to= in util.ParseTS format, zero when not given
+func rangeParams(r *http.Request) (from, to time.Time, err error) {
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if s := r.URL.Query().Get(p.name); s != "" {
+			if *p.t, err = util.ParseTS(s); err != nil {
+				return from, to, fmt.Errorf("%w %s=%#v", ErrBadParam, p.name, s)
+			}
+		}
+	}
+	return from, to, nil
+}
+
+// offset and limit of requested page
+func pageParams(r *http.Request) (offset, limit int, err error) {
+	if offset, err = intParam(r, "offset", 0); err != nil {
+		return 0, 0, err
+	}
+	if offset > OFFSET_MAX {
+		return 0, 0, fmt.Errorf("%w offset=%d, must be up to %d", ErrBadParam, offset, OFFSET_MAX)
+	}
+	if limit, err = intParam(r, "limit", LIMIT_DEFAULT); err != nil {
+		return 0, 0, err
+	}
+	if limit == 0 || limit > LIMIT_MAX {
+		return 0, 0, fmt.Errorf("%w limit=%d, must be 1..%d", ErrBadParam, limit, LIMIT_MAX)
+	}
+	return offset, limit, nil
+}
+
+// requested page of items
+func makePage[T any](items []T, offset, limit int) *Page {
+	if items == nil {
+		items = []T{} // not null in json
+	}
+	lo, hi := offset, offset+limit
+	if lo > len(items) {
+		lo = len(items)
+	}
+	if hi > len(items) {
+		hi = len(items)
+	}
+	return &Page{Offset: offset, Limit: limit, Total: len(items), Items: items[lo:hi]}
+}
+
+// realm, item and page of item requests
+func (s *Server) itemParams(r *http.Request) (realm string, item int64, offset, limit int, err error) {
+	if realm, err = s.realm(r); err != nil {
+		return
+	}
+	if item, err = int64Param(r.PathValue("item"), "item"); err != nil {
+		return
+	}
+	offset, limit, err = pageParams(r)
+	return
+}
+
+func (s *Server) store(realm string) (parser.Store, error) {
+	return parser.OpenStore(s.cf, realm, s.cf.Realm(realm).ResultDirectory)
+}
+
+// processor state of realm, reloaded when its file is changed
+func (s *Server) state(realm string) (*parser.AuctionProcessorState, error) {
+	prc := new(parser.AuctionProcessor)
+	prc.Init(s.cf, realm)
+	fi, err := os.Stat(prc.StateFName)
+	if os.IsNotExist(err) {
+		return &prc.State, nil // not parsed yet
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.states[realm]; ok && c.mtime.Equal(fi.ModTime()) {
+		return c.state, nil
+	}
+	if err := prc.LoadState(); err != nil {
+		return nil, err
+	}
+	s.states[realm] = &cachedState{fi.ModTime(), &prc.State}
+	return &prc.State, nil
+}
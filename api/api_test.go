@@ -0,0 +1,418 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	parser "github.com/wowauc/gowowuction/parser"
+)
+
+const (
+	testRealm  = "eu:fordragon"
+	otherRealm = "eu:silvermoon" // enabled, but not parsed yet
+)
+
+var (
+	testLast    = time.Date(2016, 2, 11, 14, 0, 0, 0, time.UTC)
+	testSummary = parser.SnapshotSummary{Time: testLast, Entries: 5, Active: 5, Closed: 2}
+)
+
+// config of two realms with result directory in temp dir
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cf := config.Default()
+	cf.Realms = config.RealmList{{Name: testRealm}, {Name: otherRealm}}
+	cf.RealmsList = []string{testRealm, otherRealm}
+	cf.ResultDirectory = filepath.Join(t.TempDir(), "result") + string(os.PathSeparator)
+	if err := os.MkdirAll(cf.ResultDirectory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return cf
+}
+
+func active(auc, item int64, owner string, buyout int64, quantity int32) parser.WorkEntry {
+	var e parser.WorkEntry
+	e.Entry.Auc = auc
+	e.Entry.Item = item
+	e.Entry.Owner = owner
+	e.Entry.OwnerRealm = "Fordragon"
+	e.Entry.Buyout = buyout
+	e.Entry.Quantity = quantity
+	e.State.Created = testLast.Add(-time.Hour)
+	return e
+}
+
+func closed(auc, item int64, owner, owner_realm string, quantity int32, ts time.Time, result string, profit int64) parser.ClosedAuction {
+	var c parser.ClosedAuction
+	c.Entry.Auc = auc
+	c.Entry.Item = item
+	c.Entry.Owner = owner
+	c.Entry.OwnerRealm = owner_realm
+	c.Entry.Quantity = quantity
+	c.Meta = parser.AuctionMeta{Auc: auc, Opened: ts.Add(-12 * time.Hour), Closed: ts, Result: result, Profit: profit}
+	return c
+}
+
+func saveState(t *testing.T, cf *config.Config, entries ...parser.WorkEntry) string {
+	t.Helper()
+	prc := new(parser.AuctionProcessor)
+	prc.Init(cf, testRealm)
+	prc.State.LastTime = testLast
+	for _, e := range entries {
+		prc.State.WorkSet[e.Entry.Auc] = e
+	}
+	if err := prc.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	return prc.StateFName
+}
+
+// state and results of testRealm
+func setupData(t *testing.T, cf *config.Config) {
+	t.Helper()
+	saveState(t, cf,
+		active(1, 100, "Bar", 500, 1),
+		active(2, 100, "Bar", 300, 1),
+		active(3, 100, "Bar", 0, 1), // bid only
+		active(4, 100, "Bar", 1000, 5),
+		active(5, 200, "Foo", 80, 1))
+
+	day := func(d, h int) time.Time { return time.Date(2016, 2, d, h, 0, 0, 0, time.UTC) }
+	st := parser.NewJSONLStore(cf, testRealm, cf.ResultDirectory)
+	if err := st.Begin(testLast); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []parser.ClosedAuction{
+		closed(11, 100, "Foo", "Fordragon", 1, day(10, 10), "sold", 400),
+		closed(12, 100, "Foo", "Fordragon", 2, day(10, 11), "bought", 1000),
+		closed(13, 100, "Bar", "Fordragon", 1, day(11, 10), "expired", 0),
+		closed(14, 200, "Foo", "Fordragon", 1, day(11, 12), "sold", 50),
+		closed(15, 200, "Foo", "Silvermoon", 1, day(11, 13), "sold", 70),
+	} {
+		if err := st.AddClosed(&c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.AddSummary(&testSummary); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testPage struct {
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Total  int             `json:"total"`
+	Items  json.RawMessage `json:"items"`
+}
+
+// request url, check status and decode response to v (if not nil)
+func get(t *testing.T, s *Server, url string, status int, v interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != status {
+		t.Fatalf("%s: status %d, want %d: %s", url, w.Code, status, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("%s: content type %#v", url, ct)
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: %s", url, err)
+	}
+}
+
+// request page of url, check paging and decode its items to v
+func getPage(t *testing.T, s *Server, url string, offset, limit, total int, v interface{}) {
+	t.Helper()
+	var p testPage
+	get(t, s, url, http.StatusOK, &p)
+	if p.Offset != offset || p.Limit != limit || p.Total != total {
+		t.Errorf("%s: page %d/%d of %d, want %d/%d of %d", url, p.Offset, p.Limit, p.Total, offset, limit, total)
+	}
+	if err := json.Unmarshal(p.Items, v); err != nil {
+		t.Fatalf("%s: items: %s", url, err)
+	}
+}
+
+func TestRealms(t *testing.T) {
+	cf := testConfig(t)
+	setupData(t, cf)
+	s := New(cf, nil)
+
+	var realms []RealmInfo
+	getPage(t, s, "/api/realms", 0, LIMIT_DEFAULT, 2, &realms)
+	if len(realms) != 2 {
+		t.Fatalf("realms %+v", realms)
+	}
+	if r := realms[0]; r.Name != testRealm || r.Active != 5 || r.LastTime == nil || !r.LastTime.Equal(testLast) {
+		t.Errorf("parsed realm %+v", r)
+	}
+	if r := realms[1]; r.Name != otherRealm || r.Active != 0 || r.LastTime != nil {
+		t.Errorf("not parsed realm %+v", r)
+	}
+	if !reflect.DeepEqual(realms[0].Locales, cf.LocalesList) {
+		t.Errorf("locales %v", realms[0].Locales)
+	}
+}
+
+func TestPaging(t *testing.T) {
+	cf := testConfig(t)
+	s := New(cf, nil)
+	cases := []struct {
+		url    string
+		status int
+		offset int
+		limit  int
+		names  []string
+	}{
+		{"/api/realms?limit=1", http.StatusOK, 0, 1, []string{testRealm}},
+		{"/api/realms?offset=1&limit=1", http.StatusOK, 1, 1, []string{otherRealm}},
+		{"/api/realms?offset=1", http.StatusOK, 1, LIMIT_DEFAULT, []string{otherRealm}},
+		{"/api/realms?offset=2", http.StatusOK, 2, LIMIT_DEFAULT, []string{}},
+		{"/api/realms?offset=100&limit=5", http.StatusOK, 100, 5, []string{}},
+		{"/api/realms?limit=1000", http.StatusOK, 0, LIMIT_MAX, []string{testRealm, otherRealm}},
+		{"/api/realms?limit=1001", http.StatusBadRequest, 0, 0, nil},
+		{"/api/realms?limit=0", http.StatusBadRequest, 0, 0, nil},
+		{"/api/realms?offset=-1", http.StatusBadRequest, 0, 0, nil},
+		{"/api/realms?offset=x", http.StatusBadRequest, 0, 0, nil},
+		{"/api/realms?offset=1000000", http.StatusOK, OFFSET_MAX, LIMIT_DEFAULT, []string{}},
+		{"/api/realms?offset=1000001", http.StatusBadRequest, 0, 0, nil},
+		{"/api/realms?offset=9223372036854775807", http.StatusBadRequest, 0, 0, nil},
+	}
+	for _, c := range cases {
+		if c.status != http.StatusOK {
+			var e map[string]string
+			get(t, s, c.url, c.status, &e)
+			if e["error"] == "" {
+				t.Errorf("%s: no error message", c.url)
+			}
+			continue
+		}
+		var realms []RealmInfo
+		getPage(t, s, c.url, c.offset, c.limit, 2, &realms)
+		names := []string{}
+		for _, r := range realms {
+			names = append(names, r.Name)
+		}
+		if !reflect.DeepEqual(names, c.names) {
+			t.Errorf("%s: realms %v, want %v", c.url, names, c.names)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cf := testConfig(t)
+	setupData(t, cf)
+	s := New(cf, nil)
+
+	var summary parser.SnapshotSummary
+	get(t, s, "/api/realms/"+testRealm+"/snapshot", http.StatusOK, &summary)
+	if !summary.Time.Equal(testSummary.Time) || summary.Entries != testSummary.Entries || summary.Closed != testSummary.Closed {
+		t.Errorf("summary %+v", summary)
+	}
+	get(t, s, "/api/realms/"+otherRealm+"/snapshot", http.StatusNotFound, nil)
+}
+
+func TestItemAuctions(t *testing.T) {
+	cf := testConfig(t)
+	setupData(t, cf)
+	s := New(cf, nil)
+	cases := []struct {
+		url   string
+		total int
+		aucs  []int64
+	}{
+		// by unit price of buyout, bid only ones last
+		{"/items/100/auctions", 4, []int64{4, 2, 1, 3}},
+		{"/items/100/auctions?offset=1&limit=2", 4, []int64{2, 1}},
+		{"/items/100/auctions?offset=3", 4, []int64{3}},
+		{"/items/100/auctions?offset=4", 4, []int64{}},
+		{"/items/200/auctions", 1, []int64{5}},
+		{"/items/300/auctions", 0, []int64{}},
+	}
+	for _, c := range cases {
+		url := "/api/realms/" + testRealm + c.url
+		var p testPage
+		get(t, s, url, http.StatusOK, &p)
+		var entries []parser.WorkEntry
+		if err := json.Unmarshal(p.Items, &entries); err != nil {
+			t.Fatal(err)
+		}
+		aucs := []int64{}
+		for _, e := range entries {
+			aucs = append(aucs, e.Entry.Auc)
+		}
+		if p.Total != c.total || !reflect.DeepEqual(aucs, c.aucs) {
+			t.Errorf("%s: %v of %d, want %v of %d", c.url, aucs, p.Total, c.aucs, c.total)
+		}
+	}
+	get(t, s, "/api/realms/"+testRealm+"/items/abc/auctions", http.StatusBadRequest, nil)
+	get(t, s, "/api/realms/"+testRealm+"/items/0/auctions", http.StatusBadRequest, nil)
+}
+
+func TestItemHistory(t *testing.T) {
+	cf := testConfig(t)
+	setupData(t, cf)
+	s := New(cf, nil)
+
+	var stats []parser.ItemStat
+	getPage(t, s, "/api/realms/"+testRealm+"/items/100/history", 0, LIMIT_DEFAULT, 2, &stats)
+	want := []parser.ItemStat{
+		{Item: 100, Date: "2016-02-10", Closed: 2, Sold: 2, Quantity: 3, Profit: 1400, Min: 400, Avg: 466, Max: 500},
+		{Item: 100, Date: "2016-02-11", Closed: 1},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("history %+v, want %+v", stats, want)
+	}
+	getPage(t, s, "/api/realms/"+testRealm+"/items/100/history?from=20160211", 0, LIMIT_DEFAULT, 1, &stats)
+	if !reflect.DeepEqual(stats, want[1:]) {
+		t.Errorf("history from 2016-02-11 %+v", stats)
+	}
+	get(t, s, "/api/realms/"+testRealm+"/items/100/history?from=yesterday", http.StatusBadRequest, nil)
+}
+
+func TestClosed(t *testing.T) {
+	cf := testConfig(t)
+	setupData(t, cf)
+	s := New(cf, nil)
+	cases := []struct {
+		query string
+		total int
+		aucs  []int64
+	}{
+		{"", 5, []int64{15, 14, 13, 12, 11}},
+		{"?limit=2", 5, []int64{15, 14}},
+		{"?offset=2&limit=2", 5, []int64{13, 12}},
+		{"?offset=4&limit=2", 5, []int64{11}},
+		{"?offset=5", 5, []int64{}},
+		{"?offset=10&limit=3", 5, []int64{}},
+		{"?item=100", 3, []int64{13, 12, 11}},
+		{"?item=100&offset=1&limit=1", 3, []int64{12}},
+		{"?item=300", 0, []int64{}},
+	}
+	for _, c := range cases {
+		var p testPage
+		get(t, s, "/api/realms/"+testRealm+"/closed"+c.query, http.StatusOK, &p)
+		var list []parser.ClosedAuction
+		if err := json.Unmarshal(p.Items, &list); err != nil {
+			t.Fatal(err)
+		}
+		aucs := []int64{}
+		for _, c := range list {
+			aucs = append(aucs, c.Entry.Auc)
+		}
+		if p.Total != c.total || !reflect.DeepEqual(aucs, c.aucs) {
+			t.Errorf("%#v: %v of %d, want %v of %d", c.query, aucs, p.Total, c.aucs, c.total)
+		}
+	}
+	get(t, s, "/api/realms/"+testRealm+"/closed?item=x", http.StatusBadRequest, nil)
+	get(t, s, "/api/realms/"+testRealm+"/closed?offset=9223372036854775807", http.StatusBadRequest, nil)
+
+	// realm without results has nothing closed yet
+	var p testPage
+	get(t, s, "/api/realms/"+otherRealm+"/closed", http.StatusOK, &p)
+	if p.Total != 0 || string(p.Items) != "[]" {
+		t.Errorf("closed of not parsed realm: %d %s", p.Total, p.Items)
+	}
+}
+
+func TestSeller(t *testing.T) {
+	cf := testConfig(t)
+	setupData(t, cf)
+	s := New(cf, nil)
+
+	var p SellerProfile
+	get(t, s, "/api/realms/"+testRealm+"/sellers/Foo", http.StatusOK, &p)
+	if p.Owner != "Foo" || p.Active != 1 || p.Closed != 4 || p.Sold != 4 || p.Profit != 1520 || p.SellRate != 100 {
+		t.Errorf("profile %+v", p)
+	}
+	if !reflect.DeepEqual(p.OwnerRealms, []string{"Fordragon", "Silvermoon"}) {
+		t.Errorf("owner realms %v", p.OwnerRealms)
+	}
+	if p.Items == nil || p.Items.Total != 2 {
+		t.Fatalf("items %+v", p.Items)
+	}
+	items, _ := json.Marshal(p.Items.Items)
+	var list []SellerItem
+	if err := json.Unmarshal(items, &list); err != nil {
+		t.Fatal(err)
+	}
+	want := []SellerItem{
+		{Item: 100, Closed: 2, Sold: 2, Profit: 1400},
+		{Item: 200, Active: 1, Closed: 2, Sold: 2, Profit: 120},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("items %+v, want %+v", list, want)
+	}
+
+	get(t, s, "/api/realms/"+testRealm+"/sellers/Foo?realm=Silvermoon", http.StatusOK, &p)
+	if p.Active != 0 || p.Closed != 1 || p.Profit != 70 || !reflect.DeepEqual(p.OwnerRealms, []string{"Silvermoon"}) {
+		t.Errorf("profile in Silvermoon %+v", p)
+	}
+	get(t, s, "/api/realms/"+testRealm+"/sellers/Nobody", http.StatusNotFound, nil)
+}
+
+func TestUnknownRealm(t *testing.T) {
+	cf := testConfig(t)
+	s := New(cf, nil)
+	for _, path := range []string{"snapshot", "items/100/auctions", "items/100/history", "closed", "sellers/Foo"} {
+		url := "/api/realms/eu:nosuch/" + path
+		var e map[string]string
+		get(t, s, url, http.StatusNotFound, &e)
+		if e["error"] == "" {
+			t.Errorf("%s: no error message", url)
+		}
+	}
+}
+
+// state is cached by modification time of its file
+func TestStateReload(t *testing.T) {
+	cf := testConfig(t)
+	fname := saveState(t, cf, active(1, 100, "Bar", 500, 1))
+	s := New(cf, nil)
+	count := func() int {
+		t.Helper()
+		var realms []RealmInfo
+		getPage(t, s, "/api/realms?limit=1", 0, 1, 2, &realms)
+		return realms[0].Active
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("%d active, want 1", n)
+	}
+	fi, err := os.Stat(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := fi.ModTime()
+
+	// rewritten with the same time: the cached one is served
+	saveState(t, cf, active(1, 100, "Bar", 500, 1), active(2, 100, "Bar", 300, 1))
+	if err := os.Chtimes(fname, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 1 {
+		t.Errorf("%d active, want cached 1", n)
+	}
+
+	if err := os.Chtimes(fname, mtime, mtime.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 2 {
+		t.Errorf("%d active, want reloaded 2", n)
+	}
+	var entries []parser.WorkEntry
+	getPage(t, s, "/api/realms/"+testRealm+"/items/100/auctions", 0, LIMIT_DEFAULT, 2, &entries)
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	parser "github.com/wowauc/gowowuction/parser"
+)
+
+type RealmInfo struct {
+	Name     string     `json:"name"`
+	Locales  []string   `json:"locales"`
+	LastTime *time.Time `json:"lastTime,omitempty"` // of the last processed snapshot
+	Active   int        `json:"active"`
+}
+
+func (s *Server) getRealms(r *http.Request) (interface{}, error) {
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	var realms []RealmInfo
+	for _, realm := range s.cf.RealmsList {
+		st, err := s.state(realm)
+		if err != nil {
+			return nil, err
+		}
+		info := RealmInfo{Name: realm, Locales: s.cf.Realm(realm).Locales, Active: len(st.WorkSet)}
+		if !st.LastTime.IsZero() {
+			info.LastTime = &st.LastTime
+		}
+		realms = append(realms, info)
+	}
+	return makePage(realms, offset, limit), nil
+}
+
+func (s *Server) getSnapshot(r *http.Request) (interface{}, error) {
+	realm, err := s.realm(r)
+	if err != nil {
+		return nil, err
+	}
+	store, err := s.store(realm)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	summary, err := store.LastSummary()
+	if err == nil && summary == nil {
+		err = fmt.Errorf("%w: no snapshots of %s processed", ErrNotFound, realm)
+	}
+	return summary, err
+}
+
+// active auctions of item, cheapest buyout first, bid only ones last
+func (s *Server) getItemAuctions(r *http.Request) (interface{}, error) {
+	realm, item, offset, limit, err := s.itemParams(r)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.state(realm)
+	if err != nil {
+		return nil, err
+	}
+	var entries []parser.WorkEntry
+	for _, e := range st.WorkSet {
+		if e.Entry.Item == item {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := &entries[i].Entry, &entries[j].Entry
+		pa, pb := parser.UnitPrice(a.Buyout, a.Quantity), parser.UnitPrice(b.Buyout, b.Quantity)
+		if (pa == 0) != (pb == 0) {
+			return pb == 0
+		}
+		if pa != pb {
+			return pa < pb
+		}
+		return a.Auc < b.Auc
+	})
+	return makePage(entries, offset, limit), nil
+}
+
+// daily prices of item for days of [from, to], oldest first
+func (s *Server) getItemHistory(r *http.Request) (interface{}, error) {
+	realm, item, offset, limit, err := s.itemParams(r)
+	if err != nil {
+		return nil, err
+	}
+	from, to, err := rangeParams(r)
+	if err != nil {
+		return nil, err
+	}
+	store, err := s.store(realm)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	stats, err := store.ItemStats(item, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return makePage(stats, offset, limit), nil
+}
+
+// closed auctions, newest first, of item when given
+func (s *Server) getClosed(r *http.Request) (interface{}, error) {
+	realm, err := s.realm(r)
+	if err != nil {
+		return nil, err
+	}
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	var item int64
+	if r.URL.Query().Get("item") != "" {
+		if item, err = int64Param(r.URL.Query().Get("item"), "item"); err != nil {
+			return nil, err
+		}
+	}
+	store, err := s.store(realm)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	// only the newest offset+limit ones are kept
+	keep := offset + limit
+	var ring []parser.ClosedAuction
+	total := 0
+	err = store.EachClosed(&parser.Query{Item: item}, func(c *parser.ClosedAuction) error {
+		if len(ring) < keep {
+			ring = append(ring, *c)
+		} else {
+			ring[total%keep] = *c
+		}
+		total++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	newest := make([]parser.ClosedAuction, 0, len(ring))
+	for i := total - 1; i >= total-len(ring); i-- {
+		newest = append(newest, ring[i%keep])
+	}
+	page := makePage(newest, offset, limit)
+	page.Total = total
+	return page, nil
+}
+
+type SellerItem struct {
+	Item   int64 `json:"item"`
+	Active int   `json:"active"`
+	Closed int   `json:"closed"`
+	Sold   int   `json:"sold"`
+	Profit int64 `json:"profit"`
+}
+
+type SellerProfile struct {
+	Owner       string    `json:"owner"`
+	OwnerRealms []string  `json:"ownerRealms"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Active      int       `json:"active"`
+	Closed      int       `json:"closed"`
+	Sold        int       `json:"sold"`
+	Profit      int64     `json:"profit"`
+	SellRate    int       `json:"sellRate"` // percents
+	Items       *Page     `json:"items"`    // SellerItem, most profitable first
+}
+
+// profile of seller by name and, if given, by ?realm= of the seller
+func (s *Server) getSeller(r *http.Request) (interface{}, error) {
+	realm, err := s.realm(r)
+	if err != nil {
+		return nil, err
+	}
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+	owner, owner_realm := r.PathValue("owner"), r.URL.Query().Get("realm")
+	p := &SellerProfile{Owner: owner}
+	items := make(map[int64]*SellerItem)
+	realms := make(map[string]bool)
+	seen := func(auc *parser.Auction, first, last time.Time) *SellerItem {
+		realms[auc.OwnerRealm] = true
+		if p.FirstSeen.IsZero() || first.Before(p.FirstSeen) {
+			p.FirstSeen = first
+		}
+		if last.After(p.LastSeen) {
+			p.LastSeen = last
+		}
+		it, ok := items[auc.Item]
+		if !ok {
+			it = &SellerItem{Item: auc.Item}
+			items[auc.Item] = it
+		}
+		return it
+	}
+	mine := func(auc *parser.Auction) bool {
+		return auc.Owner == owner && (owner_realm == "" || auc.OwnerRealm == owner_realm)
+	}
+	store, err := s.store(realm)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	st, err := s.state(realm)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range st.WorkSet {
+		if mine(&e.Entry) {
+			seen(&e.Entry, e.State.Created, st.LastTime).Active++
+			p.Active++
+		}
+	}
+	q := &parser.Query{Owner: owner, OwnerRealm: owner_realm}
+	err = store.EachClosed(q, func(c *parser.ClosedAuction) error {
+		it := seen(&c.Entry, c.Meta.Opened, c.Meta.Closed)
+		it.Closed++
+		p.Closed++
+		if c.Meta.Result != "expired" {
+			it.Sold++
+			it.Profit += c.Meta.Profit
+			p.Sold++
+			p.Profit += c.Meta.Profit
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: no auctions of %s in %s", ErrNotFound, owner, realm)
+	}
+
+	for name := range realms {
+		p.OwnerRealms = append(p.OwnerRealms, name)
+	}
+	sort.Strings(p.OwnerRealms)
+	if p.Closed > 0 {
+		p.SellRate = p.Sold * 100 / p.Closed
+	}
+	list := make([]SellerItem, 0, len(items))
+	for _, it := range items {
+		list = append(list, *it)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Profit != list[j].Profit {
+			return list[i].Profit > list[j].Profit
+		}
+		if list[i].Active != list[j].Active {
+			return list[i].Active > list[j].Active
+		}
+		return list[i].Item < list[j].Item
+	})
+	p.Items = makePage(list, offset, limit)
+	return p, nil
+}
@@ -1,38 +1,61 @@
 package main
 
 import (
-	//	"bufio"
+	"bytes"
 	"crypto/md5"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
-	//	"os"
-	"os/exec"
-	"sync"
-	"syscall"
-	"unsafe"
 
-	"github.com/kr/pty"
+	"github.com/wowauc/gowowuction/config"
 	"github.com/wowauc/gowowuction/util"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
 const (
-	USER                 = "user"
-	PASS                 = "password"
 	LISTEN_HOST_AND_PORT = "127.0.0.1:22022"
+	PROMPT               = "auc> "
 )
 
-func passwordCallback(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-	log.Printf("login: '%s' with password '%s'", c.User(), string(pass))
-	if c.User() == USER && string(pass) == PASS {
-		return nil, nil
+// users of console with their authorized keys (authorized_keys lines),
+// loaded from {app}.users.json
+type Users struct {
+	Listen string              `json:"listen"`
+	Users  map[string][]string `json:"users"`
+	keys   map[string][][]byte // user -> marshalled keys
+}
+
+func loadUsers(fname string) (*Users, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	u := new(Users)
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
 	}
-	return nil, fmt.Errorf("password rejected for user %s", c.User())
+	if u.Listen == "" {
+		u.Listen = LISTEN_HOST_AND_PORT
+	}
+	u.keys = make(map[string][][]byte)
+	for user, lines := range u.Users {
+		for _, line := range lines {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad key of %s: %w", fname, user, err)
+			}
+			u.keys[user] = append(u.keys[user], key.Marshal())
+		}
+	}
+	return u, nil
 }
 
 func fingerprintKey(k ssh.PublicKey) string {
@@ -44,11 +67,14 @@ func fingerprintKey(k ssh.PublicKey) string {
 	return strings.Join(strbytes, ":")
 }
 
-func pubkeyCallback(c ssh.ConnMetadata, pubkey ssh.PublicKey) (*ssh.Permissions, error) {
+func (u *Users) pubkeyCallback(c ssh.ConnMetadata, pubkey ssh.PublicKey) (*ssh.Permissions, error) {
 	log.Printf("login: '%s'. pubkey fingerprint: %s", c.User(), fingerprintKey(pubkey))
-	if c.User() == USER {
-		return nil, nil
+	for _, key := range u.keys[c.User()] {
+		if bytes.Equal(key, pubkey.Marshal()) {
+			return nil, nil
+		}
 	}
+	audit.Printf("%s@%s: login rejected, key %s", c.User(), c.RemoteAddr(), fingerprintKey(pubkey))
 	return nil, fmt.Errorf("pubkey rejected for user %s", c.User())
 }
 
@@ -58,85 +84,149 @@ func parseDims(b []byte) (w, h uint32) {
 	return
 }
 
-type Winsize struct {
-	Height uint16
-	Width  uint16
-	x      uint16
-	y      uint16
+// zero sizes come from clients without real terminal
+func setSize(terminal *term.Terminal, w, h uint32) {
+	if w > 0 && h > 0 {
+		terminal.SetSize(int(w), int(h))
+	}
 }
 
-func SetWinsize(fd uintptr, w, h uint32) {
-	ws := &Winsize{Width: uint16(w), Height: uint16(h)}
-	syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(syscall.TIOCSWINSZ), uintptr(unsafe.Pointer(ws)))
+// ssh string: uint32 length and bytes
+func parseString(b []byte) string {
+	if len(b) < 4 {
+		return ""
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return ""
+	}
+	return string(b[4 : 4+n])
 }
 
-func handleChannel(c ssh.NewChannel) {
+func exitStatus(code uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, code)
+	return b
+}
+
+// interactive session runs commands line by line, "exec" request
+// runs the only command (ssh host status)
+func handleChannel(c ssh.NewChannel, cs *Console) {
 	if t := c.ChannelType(); t != "session" {
 		log.Println("rejected unknown channel type:", t)
 		c.Reject(ssh.UnknownChannelType, "unknown channel type")
+		return
 	}
 	connection, requests, err := c.Accept()
 	if err != nil {
 		log.Println("channel not accepted:", err)
 		return
 	}
-	bash := exec.Command("/bin/bash")
-	close := func() {
-		connection.Close()
-		_, err := bash.Process.Wait()
-		if err != nil {
-			log.Println("bash not exited:", err)
+	terminal := term.NewTerminal(connection, PROMPT)
+	for req := range requests {
+		switch req.Type {
+		case "shell":
+			req.Reply(true, nil)
+			go func() {
+				defer connection.Close()
+				cs.interact(terminal)
+				log.Printf("session of %s closed", cs.user)
+			}()
+		case "exec":
+			req.Reply(true, nil)
+			go func(line string) {
+				defer connection.Close()
+				code := uint32(0)
+				if err := cs.run(connection, line); err != nil {
+					fmt.Fprintf(connection.Stderr(), "error: %s\n", err)
+					code = 1
+				}
+				connection.SendRequest("exit-status", false, exitStatus(code))
+			}(parseString(req.Payload))
+		case "pty-req":
+			if len(req.Payload) >= 4 {
+				termLen := binary.BigEndian.Uint32(req.Payload)
+				if uint32(len(req.Payload)) >= termLen+12 {
+					w, h := parseDims(req.Payload[termLen+4:])
+					setSize(terminal, w, h)
+				}
+			}
+			req.Reply(true, nil)
+		case "window-change":
+			w, h := parseDims(req.Payload)
+			setSize(terminal, w, h)
+		default:
+			log.Println("got request:", req.Type, "want reply:", req.WantReply)
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
 		}
-		log.Println("session closed")
-	}
-	bashf, err := pty.Start(bash)
-	if err != nil {
-		log.Println("pty not started:", err)
-		close()
-		return
 	}
-	var once sync.Once
-	go func() {
-		io.Copy(connection, bashf)
-		once.Do(close)
-	}()
-	go func() {
-		io.Copy(bashf, connection)
-		once.Do(close)
-	}()
-	go func() {
-		for req := range requests {
-			log.Println("got request:", req.Type, "want reply:", req.WantReply)
-			switch req.Type {
-			case "shell":
-				if len(req.Payload) == 0 {
-					req.Reply(true, nil)
-				}
-			case "pty-req":
-				termLen := req.Payload[3]
-				w, h := parseDims(req.Payload[termLen+4:])
-				SetWinsize(bashf.Fd(), w, h)
-				req.Reply(true, nil)
-			case "window-change":
-				w, h := parseDims(req.Payload)
-				SetWinsize(bashf.Fd(), w, h)
+}
+
+func (cs *Console) interact(terminal *term.Terminal) {
+	fmt.Fprintf(terminal, "gowowuction console, %s. type \"help\" for commands\n", cs.user)
+	for {
+		line, err := terminal.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				cs.log.Warnf("session of %s: %s", cs.user, err)
 			}
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
 		}
-	}()
+		if line == "quit" || line == "exit" {
+			return
+		}
+		if err := cs.run(terminal, line); err != nil {
+			fmt.Fprintf(terminal, "error: %s\n", err)
+		}
+	}
 }
 
-func handleChannels(chans <-chan ssh.NewChannel) {
+func handleChannels(chans <-chan ssh.NewChannel, cs *Console) {
 	for c := range chans {
-		go handleChannel(c)
+		go handleChannel(c, cs)
 	}
 }
 
+// audit trail of logins and commands
+var audit *log.Logger
+
 func serve() {
-	config := &ssh.ServerConfig{
-		PasswordCallback:  passwordCallback,
-		PublicKeyCallback: pubkeyCallback,
+	cf, err := config.AppConfig()
+	if err != nil {
+		log.Panicln("config load error:", err)
 	}
-	priv_fname := util.AppBaseFileName() + ".privkey"
+	for _, dir := range []string{cf.LogDirectory, cf.DownloadDirectory} {
+		if err := util.CheckDir(dir); err != nil {
+			log.Panicln(err)
+		}
+	}
+	audit_fname := filepath.Join(cf.LogDirectory, "console-audit.log")
+	auditf, err := os.OpenFile(audit_fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Panicln("audit log open error:", err)
+	}
+	defer auditf.Close()
+	audit = log.New(auditf, "", log.LstdFlags)
+
+	basename, err := util.AppBaseFileName()
+	if err != nil {
+		log.Panicln("app name error:", err)
+	}
+	users, err := loadUsers(basename + ".users.json")
+	if err != nil {
+		log.Panicln("users load error:", err)
+	}
+	log.Printf("%d user(s) loaded", len(users.Users))
+	sshConfig := &ssh.ServerConfig{
+		PublicKeyCallback: users.pubkeyCallback,
+	}
+	priv_fname := basename + ".privkey"
 	log.Print("loading private key from " + priv_fname + " ...")
 	priv_bytes, err := ioutil.ReadFile(priv_fname)
 	if err != nil {
@@ -149,10 +239,13 @@ func serve() {
 	}
 	log.Print("key fingerprint:", fingerprintKey(private.PublicKey()))
 	log.Print("adding private key to host...")
-	config.AddHostKey(private)
+	sshConfig.AddHostKey(private)
 
-	log.Println("creating listener for", LISTEN_HOST_AND_PORT, " ...")
-	listener, err := net.Listen("tcp", LISTEN_HOST_AND_PORT)
+	log.Println("creating listener for", users.Listen, " ...")
+	listener, err := net.Listen("tcp", users.Listen)
+	if err != nil {
+		log.Panicln("listen error:", err)
+	}
 	log.Println("entering in the main service loop ...")
 	for {
 		conn, err := listener.Accept()
@@ -162,16 +255,16 @@ func serve() {
 		}
 		log.Println("new connection accepted from", conn.RemoteAddr())
 		log.Println("upgrading connection to ssh...")
-		sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+		sshConn, chans, reqs, err := ssh.NewServerConn(conn, sshConfig)
 		if err != nil {
 			log.Println("handshake failed:", err)
 			continue
 		}
 		log.Printf("New SSH connection from %s (%s)", sshConn.RemoteAddr(), sshConn.ClientVersion())
+		audit.Printf("%s@%s: login", sshConn.User(), sshConn.RemoteAddr())
 		go ssh.DiscardRequests(reqs)
-		go handleChannels(chans)
+		go handleChannels(chans, newConsole(cf, sshConn.User(), sshConn.RemoteAddr().String()))
 	}
-
 }
 
 func main() {
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wowauc/gowowuction/backup"
+	"github.com/wowauc/gowowuction/config"
+	"github.com/wowauc/gowowuction/fetcher"
+	"github.com/wowauc/gowowuction/logging"
+	"github.com/wowauc/gowowuction/parser"
+	"github.com/wowauc/gowowuction/util"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command, try \"help\"")
+	ErrNeedRealm      = errors.New("realm required")
+)
+
+const (
+	TAIL_LINES = 20
+	ITEM_TOP   = 30
+)
+
+var started = time.Now()
+
+// console of one ssh connection
+type Console struct {
+	cf     *config.Config
+	user   string
+	remote string
+	log    *logging.Logger // records with user of session
+}
+
+func newConsole(cf *config.Config, user, remote string) *Console {
+	return &Console{cf, user, remote, logging.With("user", user)}
+}
+
+type command struct {
+	usage string
+	long  bool // takes lock of data, see util.TryLock
+	fn    func(cs *Console, w io.Writer, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":   {"help", false, (*Console).help},
+		"status": {"status", false, (*Console).status},
+		"fetch":  {"fetch", true, (*Console).fetch},
+		"parse":  {"parse [realm]", true, (*Console).parse},
+		"backup": {"backup", true, (*Console).backup},
+		"state":  {"state [realm]", false, (*Console).state},
+		"tail":   {"tail [lines]", false, (*Console).tail},
+		"item":   {"item <id> [realm]", false, (*Console).item},
+	}
+}
+
+// run command line and write the audit record of it
+func (cs *Console) run(w io.Writer, line string) (err error) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	audit.Printf("%s@%s: %s", cs.user, cs.remote, line)
+	defer func() {
+		if err != nil {
+			audit.Printf("%s@%s: %s failed: %s", cs.user, cs.remote, args[0], err)
+		}
+	}()
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return ErrUnknownCommand
+	}
+	if cmd.long {
+		// fetch, parse and backup must not run concurrently, also with
+		// daemon and command line of other processes
+		lock, err := util.TryLock(cs.cf.GetLockFName())
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+	return cmd.fn(cs, w, args[1:])
+}
+
+func (cs *Console) realm(args []string, i int) (string, error) {
+	if len(args) > i {
+		return args[i], nil
+	}
+	if len(cs.cf.RealmsList) == 1 {
+		return cs.cf.RealmsList[0], nil
+	}
+	return "", ErrNeedRealm
+}
+
+func (cs *Console) help(w io.Writer, args []string) error {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(w, "  quit")
+	return nil
+}
+
+func (cs *Console) status(w io.Writer, args []string) error {
+	fmt.Fprintf(w, "up since %s (%s)\n", started.Format(time.RFC3339), time.Since(started).Truncate(time.Second))
+	for _, realm := range cs.cf.RealmsList {
+		fnames, err := parser.ListSnapshots(cs.cf, realm, make(map[string]error))
+		if err != nil {
+			return err
+		}
+		prc := new(parser.AuctionProcessor)
+		prc.Init(cs.cf, realm)
+		if err := prc.LoadState(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%-24s %4d loose snapshot(s), parsed up to %s, %d open auction(s)\n",
+			realm, len(fnames), util.TSStr(prc.State.LastTime), len(prc.State.WorkList))
+	}
+	return nil
+}
+
+func (cs *Console) fetch(w io.Writer, args []string) error {
+	stored, failed := fetcher.FetchAll(cs.cf, cs.log)
+	fmt.Fprintf(w, "%d new snapshot(s) stored\n", stored)
+	if failed > 0 {
+		return fmt.Errorf("%d realm/locale pair(s) not fetched", failed)
+	}
+	return nil
+}
+
+func (cs *Console) parse(w io.Writer, args []string) error {
+	realms := cs.cf.RealmsList
+	if len(args) > 0 {
+		realms = args
+	}
+	for _, realm := range realms {
+		err := parser.ParseDir(cs.cf, realm, false, cs.log)
+		var bad *parser.BadFilesError
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "%s parsed\n", realm)
+		case errors.As(err, &bad):
+			fmt.Fprintf(w, "%s parsed with %d bad file(s)\n", realm, len(bad.Files))
+		default:
+			return fmt.Errorf("%s not parsed: %w", realm, err)
+		}
+	}
+	return nil
+}
+
+func (cs *Console) backup(w io.Writer, args []string) error {
+	if err := backup.BackupAll(cs.cf, cs.log); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "backup done")
+	return nil
+}
+
+func (cs *Console) state(w io.Writer, args []string) error {
+	realm, err := cs.realm(args, 0)
+	if err != nil {
+		return err
+	}
+	prc := new(parser.AuctionProcessor)
+	prc.Init(cs.cf, realm)
+	if err := prc.LoadState(); err != nil {
+		return err
+	}
+	counts := make(map[parser.TimeLeft]int)
+	for _, e := range prc.State.WorkList {
+		counts[e.Entry.TimeLeft]++
+	}
+	fmt.Fprintf(w, "realm:      %s\n", realm)
+	fmt.Fprintf(w, "state file: %s\n", prc.StateFName)
+	fmt.Fprintf(w, "last time:  %s\n", util.TSStr(prc.State.LastTime))
+	fmt.Fprintf(w, "open:       %d\n", len(prc.State.WorkList))
+	for _, tl := range append(parser.TimeLeftValues, parser.UNKNOWN) {
+		fmt.Fprintf(w, "  %-10s %d\n", tl, counts[tl])
+	}
+	return nil
+}
+
+func (cs *Console) tail(w io.Writer, args []string) error {
+	n := TAIL_LINES
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+			return fmt.Errorf("bad number of lines %#v", args[0])
+		}
+	}
+	f, err := os.Open(cs.cf.GetLogFName(true))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// open auctions of item from realm state, cheapest per unit first
+func (cs *Console) item(w io.Writer, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: item <id> [realm]")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad item id %#v", args[0])
+	}
+	realm, err := cs.realm(args, 1)
+	if err != nil {
+		return err
+	}
+	prc := new(parser.AuctionProcessor)
+	prc.Init(cs.cf, realm)
+	if err := prc.LoadState(); err != nil {
+		return err
+	}
+	var found []parser.WorkEntry
+	for _, e := range prc.State.WorkList {
+		if e.Entry.Item == id {
+			found = append(found, e)
+		}
+	}
+	unit := func(a *parser.Auction) int64 {
+		if a.Quantity <= 0 {
+			return a.Buyout
+		}
+		return a.Buyout / int64(a.Quantity)
+	}
+	sort.Slice(found, func(i, j int) bool { return unit(&found[i].Entry) < unit(&found[j].Entry) })
+	fmt.Fprintf(w, "item %d on %s: %d open auction(s) as of %s\n",
+		id, realm, len(found), util.TSStr(prc.State.LastTime))
+	fmt.Fprintf(w, "%12s %-20s %5s %12s %12s %12s %-9s\n",
+		"auc", "owner", "qty", "bid", "buyout", "per unit", "left")
+	for i, e := range found {
+		if i >= ITEM_TOP {
+			fmt.Fprintf(w, "... %d more\n", len(found)-ITEM_TOP)
+			break
+		}
+		a := &e.Entry
+		fmt.Fprintf(w, "%12d %-20s %5d %12d %12d %12d %-9s\n",
+			a.Auc, a.Owner+"-"+a.OwnerRealm, a.Quantity, a.Bid, a.Buyout, unit(a), a.TimeLeft)
+	}
+	return nil
+}
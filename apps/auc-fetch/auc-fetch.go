@@ -5,6 +5,7 @@ import (
 
 	config "github.com/wowauc/gowowuction/config"
 	fetcher "github.com/wowauc/gowowuction/fetcher"
+	logging "github.com/wowauc/gowowuction/logging"
 	util "github.com/wowauc/gowowuction/util"
 )
 
@@ -13,20 +14,36 @@ func DoFetch(cf *config.Config) {
 	s := new(fetcher.Session)
 	s.Config = cf
 	for _, realm := range cf.RealmsList {
-		for _, locale := range cf.LocalesList {
-			file_url, file_ts := s.Fetch_FileURL(realm, locale)
+		for _, locale := range cf.Realm(realm).Locales {
+			file_url, file_ts, err := s.Fetch_FileURL(realm, locale)
+			if err != nil || file_url == "" {
+				logging.Warnf("NO FILE URL FOR realm=%#v locale=%#v", realm, locale)
+				continue
+			}
 			log.Printf("FILE URL: %s", file_url)
 			log.Printf("FILE PIT: %s / %s", file_ts, util.TSStr(file_ts.UTC()))
 			fname := util.Make_FName(realm, file_ts, true)
 			json_fname := cf.DownloadDirectory + fname
-			if !util.CheckFile(json_fname) {
+			exists, err := util.CheckFile(json_fname)
+			if err != nil {
+				logging.Warnf("%s", err)
+				continue
+			}
+			if !exists {
 				log.Printf("downloading from %s ...", file_url)
-				data := s.Get(file_url)
+				data, err := s.Get(file_url)
+				if err != nil {
+					logging.Warnf("DATA NOT RETRIEVED FOR realm=%#v locale=%#v", realm, locale)
+					continue
+				}
 				log.Printf("... got %d octets", len(data))
 				zdata := util.Zip(data)
 				log.Printf("... zipped to %d octets (%d%%)",
 					len(zdata), len(zdata)*100/len(data))
-				util.Store(json_fname, zdata)
+				if err := util.Store(json_fname, zdata); err != nil {
+					logging.Warnf("not stored to %s: %s", json_fname, err)
+					continue
+				}
 				log.Printf("stored to %s .", json_fname)
 			} else {
 				log.Println("... already downloaded")
@@ -45,9 +62,18 @@ func main() {
 
 	cf.Dump()
 
-	util.CheckDir(cf.DownloadDirectory)
-	util.CheckDir(cf.ResultDirectory)
+	if err := util.CheckDir(cf.DownloadDirectory); err != nil {
+		log.Fatalln(err)
+	}
+	if err := util.CheckDir(cf.ResultDirectory); err != nil {
+		log.Fatalln(err)
+	}
 
+	lock, err := util.TryLock(cf.GetLockFName())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer lock.Unlock()
 	DoFetch(cf)
 	log.Println("done")
 }
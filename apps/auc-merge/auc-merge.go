@@ -2,30 +2,45 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 
+	"github.com/pkg/sftp"
+	"github.com/wowauc/gowowuction/config"
+	"github.com/wowauc/gowowuction/dedup"
+	"github.com/wowauc/gowowuction/logging"
+	"github.com/wowauc/gowowuction/parser"
 	"github.com/wowauc/gowowuction/util"
 	"golang.org/x/crypto/ssh" //see https://gist.github.com/jedy/3357393
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
-/*
+// collector host from .hostlist, lines look like
+//
+//	host[:port] [pathname]
+//
+// empty lines and lines starting with '#' are skipped
 type Source struct {
-	hostname string `json:"host"`
-	username string `json:"user"`
-	keyfile  string `json:"priv"`
-	pathname string `json:"path"`
+	hostname string
+	pathname string
 }
-*/
 
 var clientConfig *ssh.ClientConfig
-var hostlist []string
+var hostlist []Source
 var username string = "leech"
 var pathname string = "/home/leech/leech/data/json"
 
 func initialize() {
-	privkey_fname := util.AppBaseFileName() + ".privkey"
+	basename, err := util.AppBaseFileName()
+	if err != nil {
+		log.Panicf("app name error: %s", err)
+	}
+	privkey_fname := basename + ".privkey"
 	privkey_bytes, err := ioutil.ReadFile(privkey_fname)
 	if err != nil {
 		log.Panicf("privkey load error: %s", err)
@@ -34,13 +49,18 @@ func initialize() {
 	if err != nil {
 		log.Panicf("privkey parse error: %s", err)
 	}
+	hostkeys, err := knownhosts.New(basename + ".known_hosts")
+	if err != nil {
+		log.Panicf("known hosts load error: %s", err)
+	}
 	clientConfig = &ssh.ClientConfig{
 		User: username,
 		Auth: []ssh.AuthMethod{
 			ssh.PublicKeys(signer),
 		},
+		HostKeyCallback: hostkeys,
 	}
-	hostlist_fname := util.AppBaseFileName() + ".hostlist"
+	hostlist_fname := basename + ".hostlist"
 	f, err := os.Open(hostlist_fname)
 	if err != nil {
 		log.Panicf("hostlist open error: %s", err)
@@ -50,24 +70,176 @@ func initialize() {
 	scanner := bufio.NewScanner(f)
 	scanner.Split(bufio.ScanLines)
 	for scanner.Scan() {
-		hostlist = append(hostlist, scanner.Text())
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		src := Source{fields[0], pathname}
+		if len(fields) > 1 {
+			src.pathname = fields[1]
+		}
+		if !strings.Contains(src.hostname, ":") {
+			src.hostname += ":22"
+		}
+		hostlist = append(hostlist, src)
+	}
+}
+
+type mergeStats struct {
+	fetched, existing, duplicates, bad int
+}
+
+// Download snapshots from remote dir missing in dstdir. Every one is
+// checked by name and content, duplicates of known snapshots are
+// dropped by hash indexes of dstdir. Snapshots recorded in the index
+// are not fetched again, even if they are no longer in dstdir.
+func merge(sc *sftp.Client, dir, dstdir string) (stats mergeStats, err error) {
+	entries, err := sc.ReadDir(dir)
+	if err != nil {
+		return stats, fmt.Errorf("remote %s not listed: %w", dir, err)
+	}
+	indexes := make(map[string]*dedup.Index)
+	defer func() {
+		for realm, ix := range indexes {
+			if err := ix.Save(); err != nil {
+				logging.Warnf("hash index of %s not saved: %s", realm, err)
+			}
+		}
+	}()
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		realm, ts, good := util.Parse_FName(fi.Name())
+		if !good {
+			continue
+		}
+		target := dstdir + util.Make_FName(realm, ts, true)
+		if exists, err := util.CheckFile(target); err != nil {
+			return stats, err
+		} else if exists {
+			stats.existing++
+			continue
+		}
+		ix, ok := indexes[realm]
+		if !ok {
+			if ix, err = openIndex(dstdir, realm); err != nil {
+				return stats, err
+			}
+			indexes[realm] = ix
+		}
+		if _, known := ix.Lookup(ts); known {
+			// local copy may be moved away by backup
+			if _, dup := ix.Duplicate(ts); dup {
+				stats.duplicates++
+			} else {
+				stats.existing++
+			}
+			continue
+		}
+		data, err := fetchSnapshot(sc, path.Join(dir, fi.Name()))
+		if err == nil {
+			_, err = parser.ParseSnapshot(data)
+		}
+		if err != nil {
+			// may be still written by collector, so it is retried next time
+			logging.Warnf("%s:%s skipped: %s", dir, fi.Name(), err)
+			stats.bad++
+			continue
+		}
+		orig, dup, err := ix.Check(ts, data)
+		if err != nil {
+			logging.Warnf("%s:%s skipped: %s", dir, fi.Name(), err)
+			stats.bad++
+			continue
+		}
+		if dup {
+			log.Printf("%s is a duplicate of %s, skipped", fi.Name(), util.TSStr(orig))
+			stats.duplicates++
+			continue
+		}
+		if err := util.Store(target+".tmp", util.Zip(data)); err != nil {
+			return stats, err
+		}
+		if err := os.Rename(target+".tmp", target); err != nil {
+			return stats, err
+		}
+		log.Printf("%s -> %s", fi.Name(), target)
+		stats.fetched++
+	}
+	return stats, nil
+}
+
+// hash index of realm covering all its local snapshots
+func openIndex(dir, realm string) (*dedup.Index, error) {
+	ix, err := dedup.Open(dir, realm)
+	if err != nil {
+		return nil, err
 	}
+	var fnames []string
+	for _, mask := range util.Make_FMasks(realm) {
+		found, err := filepath.Glob(dir + mask)
+		if err != nil {
+			return nil, err
+		}
+		fnames = append(fnames, found...)
+	}
+	return ix, ix.AddFiles(fnames)
 }
 
-func sync(host string) {
-	client, err := ssh.Dial("tcp", host, clientConfig)
+// raw json of remote snapshot, gunzipped if needed
+func fetchSnapshot(sc *sftp.Client, fname string) ([]byte, error) {
+	f, err := sc.Open(fname)
 	if err != nil {
-		log.Panicf("ssh.Dial(%v) failed: %s", host, err)
+		return nil, err
 	}
-	session, err := client.NewSession()
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
 	if err != nil {
-		log.Panicf("NewSession() failed: %s", err)
+		return nil, err
 	}
-	defer session.Close()
+	if strings.HasSuffix(fname, ".gz") {
+		return util.Unzip(data)
+	}
+	return data, nil
+}
+
+func sync(src Source, dstdir string) error {
+	client, err := ssh.Dial("tcp", src.hostname, clientConfig)
+	if err != nil {
+		return fmt.Errorf("ssh.Dial(%v) failed: %w", src.hostname, err)
+	}
+	defer client.Close()
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("sftp session failed: %w", err)
+	}
+	defer sc.Close()
+	stats, err := merge(sc, src.pathname, dstdir)
+	log.Printf("%s: %d fetched, %d existing, %d duplicate(s), %d bad",
+		src.hostname, stats.fetched, stats.existing, stats.duplicates, stats.bad)
+	return err
 }
 
 func main() {
 	log.Print("auc-merge")
 	initialize()
-
+	cf, err := config.AppConfig()
+	if err != nil {
+		log.Fatalln("config load error: ", err)
+	}
+	if err := util.CheckDir(cf.DownloadDirectory); err != nil {
+		log.Fatalln(err)
+	}
+	failed := 0
+	for _, src := range hostlist {
+		log.Printf("sync with %s:%s ...", src.hostname, src.pathname)
+		if err := sync(src, cf.DownloadDirectory); err != nil {
+			logging.Warnf("%s", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/wowauc/gowowuction/dedup"
+	"github.com/wowauc/gowowuction/util"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testRealm    = "eu:fordragon"
+	testPassword = "secret"
+	snapshotA    = `{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[{"auc":1,"item":10,"bid":100},{"auc":2,"item":20,"bid":200}]}`
+	snapshotB    = `{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[{"auc":3,"item":30,"bid":300}]}`
+	snapshotC    = `{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[{"auc":4,"item":40,"bid":400}]}`
+	// snapshotA with other auction order
+	snapshotA2 = `{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[{"auc":2,"item":20,"bid":200},{"auc":1,"item":10,"bid":100}]}`
+)
+
+// ssh server on localhost serving sftp subsystem over the local file
+// system, stopped by test cleanup
+func startServer(t *testing.T) (addr string, hostkey ssh.PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == username && string(pass) == testPassword {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	cfg.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, cfg)
+		}
+	}()
+	return l.Addr().String(), signer.PublicKey()
+}
+
+func serveConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				// payload of subsystem request is a string: length and name
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+		server, err := sftp.NewServer(ch)
+		if err != nil {
+			ch.Close()
+			continue
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+func dialServer(t *testing.T, addr string, hostkey ssh.PublicKey) *sftp.Client {
+	t.Helper()
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.Password(testPassword)},
+		HostKeyCallback: ssh.FixedHostKey(hostkey),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	sc, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sc.Close() })
+	return sc
+}
+
+func store(t *testing.T, fname string, data []byte) {
+	t.Helper()
+	if err := util.Store(fname, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	h := func(hour int) time.Time { return time.Date(2016, 2, 10, hour, 0, 0, 0, time.UTC) }
+	remote := t.TempDir()
+	dstdir := t.TempDir() + string(os.PathSeparator)
+
+	// remote collector files
+	store(t, filepath.Join(remote, util.Make_FName(testRealm, h(12), true)), util.Zip([]byte(snapshotB)))
+	store(t, filepath.Join(remote, "eu-fordragon-20160210_130000.json"), []byte(snapshotC)) // legacy, not zipped
+	store(t, filepath.Join(remote, util.Make_FName(testRealm, h(14), true)), util.Zip([]byte(snapshotA)))
+	store(t, filepath.Join(remote, util.Make_FName(testRealm, h(15), true)), util.Zip([]byte(snapshotA2)))
+	store(t, filepath.Join(remote, util.Make_FName(testRealm, h(16), true)), util.Zip([]byte(snapshotA[:40])))
+	store(t, filepath.Join(remote, util.Make_FName(testRealm, h(17), true)), util.Zip([]byte(snapshotC)))
+	store(t, filepath.Join(remote, "README"), []byte("not a snapshot"))
+	if err := os.Mkdir(filepath.Join(remote, util.Make_FName(testRealm, h(18), true)), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// 14:00 is here already, 17:00 is known as a copy of 11:00
+	store(t, dstdir+util.Make_FName(testRealm, h(14), true), util.Zip([]byte(snapshotA)))
+	ix, err := dedup.Open(dstdir, testRealm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.Add(h(11), "0123")
+	ix.Add(h(17), "0123")
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, hostkey := startServer(t)
+	stats, err := merge(dialServer(t, addr, hostkey), remote, dstdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mergeStats{fetched: 2, existing: 1, duplicates: 2, bad: 1}
+	if stats != want {
+		t.Errorf("stats %+v, want %+v", stats, want)
+	}
+
+	fnames, err := filepath.Glob(dstdir + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range fnames {
+		fnames[i] = filepath.Base(fnames[i])
+	}
+	sort.Strings(fnames)
+	wantnames := []string{
+		filepath.Base(dedup.IndexName(dstdir, testRealm)),
+		filepath.Base(dedup.IndexName(dstdir, testRealm)) + ".bak",
+		util.Make_FName(testRealm, h(12), true),
+		util.Make_FName(testRealm, h(13), true),
+		util.Make_FName(testRealm, h(14), true),
+	}
+	sort.Strings(wantnames)
+	if len(fnames) != len(wantnames) {
+		t.Fatalf("files %v, want %v", fnames, wantnames)
+	}
+	for i := range wantnames {
+		if fnames[i] != wantnames[i] {
+			t.Fatalf("files %v, want %v", fnames, wantnames)
+		}
+	}
+	for hour, blob := range map[int]string{12: snapshotB, 13: snapshotC} {
+		data, err := util.Load(dstdir + util.Make_FName(testRealm, h(hour), true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != blob {
+			t.Errorf("%02d:00 stored as %s", hour, data)
+		}
+	}
+
+	// fetched ones are hashed, the second run has nothing to fetch
+	stats, err = merge(dialServer(t, addr, hostkey), remote, dstdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = mergeStats{fetched: 0, existing: 3, duplicates: 2, bad: 1}
+	if stats != want {
+		t.Errorf("second run stats %+v, want %+v", stats, want)
+	}
+
+	// 12:00 is moved away by backup, but it is still known
+	if err := os.Remove(dstdir + util.Make_FName(testRealm, h(12), true)); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = merge(dialServer(t, addr, hostkey), remote, dstdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != want {
+		t.Errorf("run after backup stats %+v, want %+v", stats, want)
+	}
+	if exists, err := util.CheckFile(dstdir + util.Make_FName(testRealm, h(12), true)); err != nil || exists {
+		t.Errorf("12:00 fetched again after backup: %v", err)
+	}
+}
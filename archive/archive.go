@@ -0,0 +1,161 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	zstd "github.com/klauspost/compress/zstd"
+	xz "github.com/ulikunitz/xz"
+)
+
+// extensions of archives made by backup
+var Exts = []string{".zip", ".tar.gz", ".tar.xz", ".tar.zst"}
+
+// zstd dictionaries are stored near .tar.zst archives with this extension
+const DICT_EXT = ".zdict"
+
+var (
+	ErrUnknownFormat = errors.New("unknown archive format")
+	// may be returned by WalkFunc to stop walking without error
+	ErrStopWalk = errors.New("stop walk")
+)
+
+// called for every regular entry of archive. r is valid only inside call
+type WalkFunc func(name string, ts time.Time, r io.Reader) error
+
+func IsArchive(fname string) bool {
+	for _, ext := range Exts {
+		if strings.HasSuffix(fname, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// walk entries in stored order
+func Walk(fname string, fn WalkFunc) error {
+	var err error
+	switch {
+	case strings.HasSuffix(fname, ".zip"):
+		err = walkZip(fname, fn)
+	case strings.HasSuffix(fname, ".tar.gz"), strings.HasSuffix(fname, ".tar.xz"),
+		strings.HasSuffix(fname, ".tar.zst"):
+		err = walkTar(fname, fn)
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnknownFormat, fname)
+	}
+	if err == ErrStopWalk {
+		err = nil
+	}
+	return err
+}
+
+func walkZip(fname string, fn WalkFunc) error {
+	zr, err := zip.OpenReader(fname)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", fname, f.Name, err)
+		}
+		err = fn(f.Name, f.Modified, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(fname string, fn WalkFunc) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader
+	switch {
+	case strings.HasSuffix(fname, ".gz"):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fname, err)
+		}
+		defer zr.Close()
+		r = zr
+	case strings.HasSuffix(fname, ".zst"):
+		dicts, err := LoadDicts(filepath.Dir(fname))
+		if err != nil {
+			return err
+		}
+		zr, err := zstd.NewReader(f, zstd.WithDecoderDicts(dicts...))
+		if err != nil {
+			return fmt.Errorf("%s: %w", fname, err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		zr, err := xz.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fname, err)
+		}
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", fname, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, hdr.ModTime, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// all zstd dictionaries from dir, frames choose them by id
+func LoadDicts(dir string) ([][]byte, error) {
+	fnames, err := filepath.Glob(filepath.Join(dir, "*"+DICT_EXT))
+	if err != nil {
+		return nil, err
+	}
+	var dicts [][]byte
+	for _, fname := range fnames {
+		dict, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		dicts = append(dicts, dict)
+	}
+	return dicts, nil
+}
+
+// names of all entries
+func List(fname string) ([]string, error) {
+	var names []string
+	err := Walk(fname, func(name string, ts time.Time, r io.Reader) error {
+		names = append(names, name)
+		return nil
+	})
+	return names, err
+}
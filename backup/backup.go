@@ -5,14 +5,19 @@ import (
 	"archive/zip"
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
+	archive "github.com/wowauc/gowowuction/archive"
+	dedup "github.com/wowauc/gowowuction/dedup"
+	logging "github.com/wowauc/gowowuction/logging"
 	parser "github.com/wowauc/gowowuction/parser"
 
 	//gzip "github.com/klauspost/compress/gzip"
@@ -22,18 +27,22 @@ import (
 	//	xz "github.com/danielrh/go-xz"
 	xz "github.com/ulikunitz/xz"
 
+	zstd "github.com/klauspost/compress/zstd"
+
 	util "github.com/wowauc/gowowuction/util"
 )
 
+var (
+	ErrBadBackupExt = errors.New("unsupported backup extension")
+	ErrBackupFailed = errors.New("backup failed")
+)
+
 func validate_blob(data []byte) error {
-	if _, err := parser.ParseSnapshot(data); err != nil {
-		log.Printf("[!] %s", err)
-		return err
-	}
-	return nil
+	_, err := parser.ParseSnapshot(data)
+	return err
 }
 
-func tar_it(tarwriter *tar.Writer, data []byte, name string, ts time.Time) error {
+func tar_it(tarwriter *tar.Writer, data []byte, name string, ts time.Time, lg *logging.Logger) error {
 	hdr := new(tar.Header)
 	hdr.Name = name
 	hdr.Size = int64(len(data))
@@ -43,7 +52,7 @@ func tar_it(tarwriter *tar.Writer, data []byte, name string, ts time.Time) error
 	if err := tarwriter.WriteHeader(hdr); err != nil {
 		return err
 	}
-	log.Printf("tar %d bytes for file %s", hdr.Size, hdr.Name)
+	lg.Debugf("tar %d bytes for file %s", hdr.Size, hdr.Name)
 
 	if _, err := tarwriter.Write(data); err != nil {
 		return err
@@ -51,113 +60,266 @@ func tar_it(tarwriter *tar.Writer, data []byte, name string, ts time.Time) error
 	return nil
 }
 
-func MakeTarball(tarname string, fnames []string) (skiplist []string, err error) {
-	skiplist = []string{}
-	tmpname := tarname + ".tmp"
-	log.Printf("tarring %d entrires to %s ...", len(fnames), tarname)
-	tarfile, err := os.Create(tmpname)
-	if err != nil {
-		return
-	}
-	empty := true
+// stores one entry to archive being built
+type putFunc func(data []byte, name string, ts time.Time) error
 
-	defer func() {
-		tarfile.Close()
-		if empty {
-			if err := os.Remove(tmpname); err != nil {
-				log.Printf("[!] deferred routine error for empty file: %s", err)
-			} else {
-				log.Printf("empty archive removed")
-			}
-		} else if err := util.Rotate(tarname); err != nil {
-			log.Printf("[!] deferred routine error: %s", err)
-		}
-	}()
-	var tarwriter *tar.Writer
-	if strings.HasSuffix(tarname, ".gz") {
-		zipper := gzip.NewWriter(tarfile)
-		defer zipper.Close()
-		tarwriter = tar.NewWriter(zipper)
-		/* remove or add trailing '/' after asterisk ---> */
-		// for pure go'ish xz
-		// xz "github.com/ulikunitz/xz.git"
-	} else if strings.HasSuffix(tarname, ".xz") {
-		zipper, err := xz.NewWriter(tarfile)
-		if err != nil {
-			return skiplist, err
-		}
-		defer zipper.Close()
-		tarwriter = tar.NewWriter(zipper)
-		/**/
-		/* remove or add trailing '/' after asterisk ---> *
-			// xz "github.com/danielrh/go-xz"
-			} else if strings.HasSuffix(tarname, ".xz") {
-					zipper := xz.NewCompressionWriter(tarfile)
-					defer zipper.Close()
-					tarwriter = tar.NewWriter(zipper)
-		/**/
-	} else {
-		tarwriter = tar.NewWriter(tarfile)
+// snapshot file waiting to be stored
+type pending struct {
+	fname string
+	name  string
+	ts    time.Time
+	key   string // see entryKey
+}
+
+// identity of snapshot entry by realm and time, so entries named by
+// legacy and current schemes match. Other names are kept as is.
+func entryKey(name string) string {
+	realm, ts, good := util.Parse_FName(name)
+	if !good {
+		return name
 	}
-	defer tarwriter.Close()
-	var md5sum bytes.Buffer
-	var sha1sum bytes.Buffer
+	return realm + " " + util.TSStr(ts)
+}
 
+// Fill archive with entries of existing archive `oldname` (if any)
+// merged with files from fnames in name order, then append manifests
+// covering all of them. Checksums of old entries are taken from old
+// manifests when possible. Files already stored in old archive are
+// not stored twice. added is the number of newly stored files.
+func fillArchive(put putFunc, oldname string, fnames []string, lg *logging.Logger) (skiplist []string, added int, err error) {
+	skiplist = []string{}
+	var files []pending
 	for _, fname := range fnames {
 		realm, ts, good := util.Parse_FName(fname)
 		if !good {
-			log.Printf("warning: skip ill-named file '%s'", fname)
+			lg.Warnf("skip ill-named file '%s'", fname)
 			skiplist = append(skiplist, fname)
 			continue // skip
 		}
-		data, err := util.Load(fname)
+		name := util.Make_FName(realm, ts, false)
+		files = append(files, pending{fname, name, ts, entryKey(name)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+
+	var md5sum bytes.Buffer
+	var sha1sum bytes.Buffer
+	old_md5 := make(map[string]string)
+	old_sha1 := make(map[string]string)
+	stored := make(map[string]bool) // by entryKey
+	var old_names []string
+
+	store_file := func(f pending) {
+		if stored[f.key] {
+			lg.Infof("%s already archived", f.fname)
+			return
+		}
+		data, err := util.Load(f.fname)
 		if err != nil {
-			log.Printf("[!]: skip unloadable file %s: error %s", fname, err)
-			skiplist = append(skiplist, fname)
-			continue // skip
+			lg.Warnf("skip unloadable file %s: error %s", f.fname, err)
+			skiplist = append(skiplist, f.fname)
+			return // skip
 		}
 		if err := validate_blob(data); err != nil {
-			log.Printf("[!]: skip bad blob from file %s: %s", fname, err)
-			skiplist = append(skiplist, fname)
-			continue // skip
+			lg.Warnf("skip bad blob from file %s: %s", f.fname, err)
+			skiplist = append(skiplist, f.fname)
+			return // skip
 		}
-		name := util.Make_FName(realm, ts, false)
-		fmt.Fprintln(&md5sum, util.MakeMD5(data), name)
-		fmt.Fprintln(&sha1sum, util.MakeSHA1(data), name)
-		if err = tar_it(tarwriter, data, name, ts); err != nil {
-			log.Printf("[!]: cannot tar %s: %s", fname, err)
-			skiplist = append(skiplist, fname)
-			continue // skip
+		if err = put(data, f.name, f.ts); err != nil {
+			lg.Warnf("cannot archive %s: %s", f.fname, err)
+			skiplist = append(skiplist, f.fname)
+			return // skip
 		}
-		empty = false // at least one file stored
+		fmt.Fprintln(&md5sum, util.MakeMD5(data), f.name)
+		fmt.Fprintln(&sha1sum, util.MakeSHA1(data), f.name)
+		stored[f.key] = true
+		added++
 	}
-	if !empty {
-		ts := time.Now()
-		if err = tar_it(tarwriter, md5sum.Bytes(), "md5sum.txt", ts); err != nil {
-			log.Printf("[!]: cannot tar md5sum.txt: %s", err)
-			return skiplist, err
+
+	if exists, err := util.CheckFile(oldname); err != nil {
+		return skiplist, 0, err
+	} else if exists {
+		lg.Infof("merge with existing %s ...", oldname)
+		// names of existing entries are needed before merge
+		names, err := archive.List(oldname)
+		if err != nil {
+			return skiplist, 0, fmt.Errorf("existing archive %s not readable: %w", oldname, err)
 		}
-		if err = tar_it(tarwriter, sha1sum.Bytes(), "sha1sum.txt", ts); err != nil {
-			log.Printf("[!]: cannot tar sha1sum.txt: %s", err)
-			return skiplist, err
+		for _, name := range names {
+			if name != MD5SUM && name != SHA1SUM {
+				stored[entryKey(name)] = true
+			}
+		}
+		err = archive.Walk(oldname, func(name string, ts time.Time, r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			switch name {
+			case MD5SUM:
+				old_md5 = parseManifest(data)
+				return nil
+			case SHA1SUM:
+				old_sha1 = parseManifest(data)
+				return nil
+			}
+			for len(files) > 0 && files[0].name < name {
+				store_file(files[0])
+				files = files[1:]
+			}
+			old_names = append(old_names, name)
+			lg.Infof("keep %s", name)
+			return put(data, name, ts)
+		})
+		if err != nil {
+			return skiplist, 0, fmt.Errorf("existing archive %s not merged: %w", oldname, err)
 		}
 	}
-	if err = tarwriter.Flush(); err != nil {
-		log.Printf("[!]: cannot flush tarball: %s", err)
-		return skiplist, err
+	for _, f := range files {
+		store_file(f)
+	}
+	if added == 0 {
+		return skiplist, 0, nil
+	}
+	// old entries go first in manifests, their sums are recomputed
+	// only when old manifests have no lines for them
+	var old_md5sum, old_sha1sum bytes.Buffer
+	if len(old_names) > 0 {
+		sums, err := entrySums(oldname, old_names, old_md5, old_sha1, lg)
+		if err != nil {
+			return skiplist, 0, err
+		}
+		for _, name := range old_names {
+			fmt.Fprintln(&old_md5sum, sums[name][0], name)
+			fmt.Fprintln(&old_sha1sum, sums[name][1], name)
+		}
+	}
+	ts := time.Now()
+	if err = put(append(old_md5sum.Bytes(), md5sum.Bytes()...), MD5SUM, ts); err != nil {
+		lg.Warnf("cannot archive %s: %s", MD5SUM, err)
+		return skiplist, added, err
+	}
+	if err = put(append(old_sha1sum.Bytes(), sha1sum.Bytes()...), SHA1SUM, ts); err != nil {
+		lg.Warnf("cannot archive %s: %s", SHA1SUM, err)
+		return skiplist, added, err
 	}
+	return skiplist, added, nil
+}
+
+// md5 and sha1 of entries, from manifests or recomputed if not listed
+func entrySums(fname string, names []string, md5sum, sha1sum map[string]string, lg *logging.Logger) (map[string][2]string, error) {
+	sums := make(map[string][2]string)
+	missing := make(map[string]bool)
+	for _, name := range names {
+		m, ok1 := md5sum[name]
+		h, ok2 := sha1sum[name]
+		if ok1 && ok2 {
+			sums[name] = [2]string{m, h}
+		} else {
+			missing[name] = true
+		}
+	}
+	if len(missing) == 0 {
+		return sums, nil
+	}
+	lg.Warnf("%d entries of %s are not in manifests, recompute", len(missing), fname)
+	err := archive.Walk(fname, func(name string, ts time.Time, r io.Reader) error {
+		if !missing[name] {
+			return nil
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		sums[name] = [2]string{util.MakeMD5(data), util.MakeSHA1(data)}
+		return nil
+	})
+	return sums, err
+}
+
+func report_skipped(name string, skiplist []string, lg *logging.Logger) {
 	if len(skiplist) == 0 {
-		log.Printf("%s tarred without errors", tarname)
+		lg.Infof("%s archived without errors", name)
 	} else {
-		log.Printf("%s tarred with %d issue(s)", tarname, len(skiplist))
+		lg.Infof("%s archived with %d issue(s)", name, len(skiplist))
 		for _, fname := range skiplist {
-			log.Printf("...  not tarred: %s", fname)
+			lg.Infof("...  not archived: %s", fname)
+		}
+	}
+}
+
+// finish archive building: rotate tmp file to name when something was
+// added, otherwise drop it and keep the old archive untouched
+func finish_archive(name string, added int, err error, lg *logging.Logger) {
+	tmpname := name + ".tmp"
+	if added == 0 || err != nil {
+		if err := os.Remove(tmpname); err != nil {
+			lg.Warnf("deferred routine error for unused file: %s", err)
+		} else {
+			lg.Infof("unused archive removed")
+		}
+	} else if err := util.Rotate(name); err != nil {
+		lg.Warnf("deferred routine error: %s", err)
+	}
+}
+
+// Build tarball from fnames merged with existing tarball of the same name.
+// zopts (may be nil) and dict are used for .tar.zst only, nil lg logs
+// through the root logger.
+func MakeTarball(tarname string, fnames []string, zopts *ZstdOptions, dict []byte, lg *logging.Logger) (skiplist []string, err error) {
+	tmpname := tarname + ".tmp"
+	lg.Infof("tarring %d entrires to %s ...", len(fnames), tarname)
+	tarfile, err := os.Create(tmpname)
+	if err != nil {
+		return []string{}, err
+	}
+	added := 0
+	defer func() {
+		tarfile.Close()
+		finish_archive(tarname, added, err, lg)
+	}()
+
+	var zipper io.WriteCloser
+	switch {
+	case strings.HasSuffix(tarname, ".gz"):
+		zipper = gzip.NewWriter(tarfile)
+	case strings.HasSuffix(tarname, ".xz"):
+		if zipper, err = xz.NewWriter(tarfile); err != nil {
+			return []string{}, err
+		}
+	case strings.HasSuffix(tarname, ".zst"):
+		zo := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(zopts.level()))}
+		if dict != nil {
+			zo = append(zo, zstd.WithEncoderDict(dict))
+		}
+		if zipper, err = zstd.NewWriter(tarfile, zo...); err != nil {
+			return []string{}, err
+		}
+	default:
+		zipper = tarfile
+	}
+	tarwriter := tar.NewWriter(zipper)
+
+	skiplist, added, err = fillArchive(func(data []byte, name string, ts time.Time) error {
+		return tar_it(tarwriter, data, name, ts, lg)
+	}, tarname, fnames, lg)
+	if err != nil {
+		return skiplist, err
+	}
+	if err = tarwriter.Close(); err != nil {
+		lg.Warnf("cannot flush tarball: %s", err)
+		return skiplist, err
+	}
+	if zipper != tarfile {
+		if err = zipper.Close(); err != nil {
+			lg.Warnf("cannot flush tarball: %s", err)
+			return skiplist, err
 		}
 	}
-	return skiplist, err
+	report_skipped(tarname, skiplist, lg)
+	return skiplist, nil
 }
 
-func zip_it(zipwriter *zip.Writer, data []byte, name string, ts time.Time) error {
+func zip_it(zipwriter *zip.Writer, data []byte, name string, ts time.Time, lg *logging.Logger) error {
 	header := &zip.FileHeader{
 		Name:   name,
 		Method: zip.Deflate,
@@ -169,7 +331,7 @@ func zip_it(zipwriter *zip.Writer, data []byte, name string, ts time.Time) error
 	if err != nil {
 		return err
 	}
-	log.Printf("zip %d bytes for file %s", len(data), name)
+	lg.Debugf("zip %d bytes for file %s", len(data), name)
 	_, err = f.Write(data)
 	if err != nil {
 		return err
@@ -177,104 +339,103 @@ func zip_it(zipwriter *zip.Writer, data []byte, name string, ts time.Time) error
 	return nil
 }
 
-func MakeZip(zipname string, fnames []string) (skiplist []string, err error) {
-	skiplist = []string{}
+// Build zip from fnames merged with existing zip of the same name,
+// nil lg logs through the root logger
+func MakeZip(zipname string, fnames []string, lg *logging.Logger) (skiplist []string, err error) {
 	tmpname := zipname + ".tmp"
-	log.Printf("zipping %d entrires to %s ...", len(fnames), zipname)
+	lg.Infof("zipping %d entrires to %s ...", len(fnames), zipname)
 	zipfile, err := os.Create(tmpname)
 	if err != nil {
-		return skiplist, err
+		return []string{}, err
 	}
-	empty := true
+	added := 0
 	defer func() {
 		zipfile.Close()
-		if empty {
-			if err := os.Remove(tmpname); err != nil {
-				log.Printf("[!] deferred routine error for empty file: %s", err)
-			} else {
-				log.Printf("empty archive removed")
-			}
-		} else if err := util.Rotate(zipname); err != nil {
-			log.Printf("[!] deferred routine error: %s", err)
-		}
+		finish_archive(zipname, added, err, lg)
 	}()
 
 	zipwriter := zip.NewWriter(zipfile)
-	defer zipwriter.Close()
-
-	var md5sum bytes.Buffer
-	var sha1sum bytes.Buffer
+	skiplist, added, err = fillArchive(func(data []byte, name string, ts time.Time) error {
+		return zip_it(zipwriter, data, name, ts, lg)
+	}, zipname, fnames, lg)
+	if err != nil {
+		return skiplist, err
+	}
+	if err = zipwriter.Close(); err != nil {
+		lg.Warnf("cannot flush zip: %s", err)
+		return skiplist, err
+	}
+	report_skipped(zipname, skiplist, lg)
+	return skiplist, nil
+}
 
-	for _, fname := range fnames {
-		realm, ts, good := util.Parse_FName(fname)
-		if !good {
-			log.Printf("warning: skip ill-named file '%s'", fname)
-			skiplist = append(skiplist, fname)
-			continue // skip
+// Check snapshot against hash index of its realm in dir (indexes are
+// opened on demand and cached in hashes). Unloadable snapshots are not
+// duplicates, they are reported while archiving.
+func isDuplicate(dir string, hashes map[string]*dedup.Index, fname, realm string, ts time.Time, lg *logging.Logger) bool {
+	ix, ok := hashes[realm]
+	if !ok {
+		var err error
+		if ix, err = dedup.Open(dir, realm); err != nil {
+			lg.Warnf("hash index of %s not loaded: %s", realm, err)
 		}
+		hashes[realm] = ix
+	}
+	if ix == nil {
+		return false
+	}
+	orig, dup := ix.Duplicate(ts)
+	if _, known := ix.Lookup(ts); !known {
 		data, err := util.Load(fname)
 		if err != nil {
-			log.Printf("[!]: skip unloadable file %s: error %s", fname, err)
-			skiplist = append(skiplist, fname)
-			continue // skip
-		}
-		if err := validate_blob(data); err != nil {
-			log.Printf("[!]: skip bad blob from file %s: %s", fname, err)
-			skiplist = append(skiplist, fname)
-			continue // skip
-		}
-		name := util.Make_FName(realm, ts, false)
-		fmt.Fprintln(&md5sum, util.MakeMD5(data), name)
-		fmt.Fprintln(&sha1sum, util.MakeSHA1(data), name)
-		if err := zip_it(zipwriter, data, name, ts); err != nil {
-			log.Printf("[!]: cannot zip %s: %s", fname, err)
-			skiplist = append(skiplist, fname)
-			continue // skip
+			return false
 		}
-		empty = false // at least one file stored
-	}
-	if !empty {
-		ts := time.Now()
-		if err = zip_it(zipwriter, md5sum.Bytes(), "md5sum.txt", ts); err != nil {
-			log.Printf("[!]: cannot zip md5sum.txt: %s", err)
-			return skiplist, err
-		}
-		if err = zip_it(zipwriter, sha1sum.Bytes(), "sha1sum.txt", ts); err != nil {
-			log.Printf("[!]: cannot zip sha1sum.txt: %s", err)
-			return skiplist, err
+		if orig, dup, err = ix.Check(ts, data); err != nil {
+			return false
 		}
 	}
-	if err = zipwriter.Flush(); err != nil {
-		log.Printf("[!]: cannot flush zip: %s", err)
-		return skiplist, err
+	if dup {
+		lg.Infof("%s has the same content as snapshot %s, not archived", fname, util.TSStr(orig))
 	}
-	if len(skiplist) == 0 {
-		log.Printf("%s zipped without errors", zipname)
-	} else {
-		log.Printf("%s zipped with %d issue(s)", zipname, len(skiplist))
-		for _, fname := range skiplist {
-			log.Printf("...  not zipped: %s", fname)
-		}
-	}
-	return skiplist, err
+	return dup
 }
 
-func Backup(srcdir, dstdir, timeformat, ext string, completeOnly bool, doMove bool) {
-	// Backup("/opt/wowauc/download", "/opt/wowauc/backup", "20060102", ".tar.gz")
+// zopts (may be nil) is used for .tar.zst backups only, only (if not nil)
+// limits backup to snapshots of these realms
+func Backup(srcdir, dstdir, timeformat, ext string, completeOnly bool, doMove bool, zopts *ZstdOptions, only []string, lg *logging.Logger) error {
+	// Backup("/opt/wowauc/download", "/opt/wowauc/backup", "20060102", ".tar.gz", false, false, nil)
+	if ext != ".tar.gz" && ext != ".tar.xz" && ext != ".tar.zst" && ext != ".zip" {
+		return fmt.Errorf("%w: %s", ErrBadBackupExt, ext)
+	}
 	fnames, err := filepath.Glob(srcdir + "/*.json.gz")
 	if err != nil {
-		log.Fatalln("glob failed:", err)
+		return fmt.Errorf("glob failed: %w", err)
 	}
-	log.Printf("... %d entries collected", len(fnames))
+	lg.Infof("... %d entries collected", len(fnames))
 
 	rmap := make(map[string]map[string][]string)
+	realms := make(map[string]string) // safe name -> realm
+	hashes := make(map[string]*dedup.Index)
 
 	for _, fname := range fnames {
 		realm, ts, good := util.Parse_FName(fname)
+		if good && only != nil && !contains(only, realm) {
+			continue
+		}
+		if good && isDuplicate(srcdir, hashes, fname, realm, ts, lg.With("realm", realm)) {
+			if doMove {
+				lg.Infof("remove duplicate %s", fname)
+				if err := os.Remove(fname); err != nil {
+					lg.Warnf("remove(%s) failed: %s", fname, err)
+				}
+			}
+			continue
+		}
 		if good {
-			log.Printf("fname %s -> %s, %v", fname, realm, ts)
+			lg.Debugf("fname %s -> %s, %v", fname, realm, ts)
 			rlm := util.Safe_Realm(realm)
-			key := rlm + "-" + ts.Format(timeformat)
+			realms[rlm] = realm
+			key := util.Make_ArchName(realm, ts.Format(timeformat), "")
 			if _, ok := rmap[rlm]; !ok {
 				rmap[rlm] = make(map[string][]string)
 			}
@@ -283,13 +444,21 @@ func Backup(srcdir, dstdir, timeformat, ext string, completeOnly bool, doMove bo
 			}
 			rmap[rlm][key] = append(rmap[rlm][key], fname)
 		} else {
-			log.Printf("skip fname %s", fname)
+			lg.Debugf("skip fname %s", fname)
+		}
+	}
+	for realm, ix := range hashes {
+		if ix == nil {
+			continue
+		}
+		if err := ix.Save(); err != nil {
+			lg.Warnf("hash index of %s not saved: %s", realm, err)
 		}
 	}
 	if completeOnly {
-		log.Println("throw out last keys from every collected realm")
+		lg.Infof("throw out last keys from every collected realm")
 		for rlm, _ := range rmap {
-			log.Printf("... for realm %s (%d entries)", rlm, len(rmap[rlm]))
+			lg.Infof("... for realm %s (%d entries)", rlm, len(rmap[rlm]))
 			var keys []string
 			for key, _ := range rmap[rlm] {
 				keys = append(keys, key)
@@ -297,11 +466,11 @@ func Backup(srcdir, dstdir, timeformat, ext string, completeOnly bool, doMove bo
 			sort.Sort(util.ByContent(keys))
 			sz := len(keys)
 			lastkey := keys[sz-1]
-			log.Printf("... ... remove %v", lastkey)
+			lg.Infof("... ... remove %v", lastkey)
 			delete(rmap[rlm], lastkey)
 		}
 	} else {
-		log.Println("keep all keys")
+		lg.Infof("keep all keys")
 	}
 
 	var rlms []string
@@ -310,7 +479,21 @@ func Backup(srcdir, dstdir, timeformat, ext string, completeOnly bool, doMove bo
 	}
 	sort.Sort(util.ByContent(rlms))
 
+	failed := 0
 	for _, rlm := range rlms {
+		rlg := lg.With("realm", realms[rlm])
+		var dict []byte
+		if ext == ".tar.zst" && zopts != nil && zopts.Dict && len(rmap[rlm]) > 0 {
+			var samples []string
+			for _, fnames := range rmap[rlm] {
+				samples = append(samples, fnames...)
+			}
+			sort.Sort(util.ByBasename(samples))
+			if dict, err = loadOrTrainDict(dstdir, realms[rlm], samples, zopts, rlg); err != nil {
+				rlg.Warnf("no zstd dictionary for %s, compress without it: %s", realms[rlm], err)
+				dict = nil
+			}
+		}
 		var keys []string
 		for key, _ := range rmap[rlm] {
 			keys = append(keys, key)
@@ -320,50 +503,52 @@ func Backup(srcdir, dstdir, timeformat, ext string, completeOnly bool, doMove bo
 			var skiplist []string
 			var err error
 			fnames := rmap[rlm][key]
-			log.Printf("backup %d entries for %s ...", len(fnames), key)
+			rlg.Infof("backup %d entries for %s ...", len(fnames), key)
 			sort.Sort(util.ByBasename(fnames))
-			if ext == ".tar.gz" {
+			if ext == ".tar.gz" || ext == ".tar.xz" || ext == ".tar.zst" {
 				tarname := dstdir + "/" + key + ext
-				skiplist, err = MakeTarball(tarname, fnames)
+				skiplist, err = MakeTarball(tarname, fnames, zopts, dict, rlg)
 				if err != nil {
-					log.Printf("[!] MakeTarball(%s) failed: %s", tarname, err)
-					continue
-				}
-			} else if ext == ".tar.xz" {
-				tarname := dstdir + "/" + key + ext
-				skiplist, err = MakeTarball(tarname, fnames)
-				if err != nil {
-					log.Printf("[!] MakeTarball(%s) failed: %s", tarname, err)
+					rlg.Warnf("MakeTarball(%s) failed: %s", tarname, err)
+					failed++
+					backupArchives.Inc(realms[rlm], "failed")
 					continue
 				}
+				backupArchives.Inc(realms[rlm], "ok")
 			} else if ext == ".zip" {
 				zipname := dstdir + "/" + key + ext
-				skiplist, err = MakeZip(zipname, fnames)
+				skiplist, err = MakeZip(zipname, fnames, rlg)
 				if err != nil {
-					log.Printf("[!] MakeZip(%s) failed: %s", zipname, err)
+					rlg.Warnf("MakeZip(%s) failed: %s", zipname, err)
+					failed++
+					backupArchives.Inc(realms[rlm], "failed")
 					continue
 				}
+				backupArchives.Inc(realms[rlm], "ok")
 			}
 			if doMove {
 				skipped := make(map[string]bool)
 				for _, name := range skiplist {
 					skipped[name] = true
 				}
-				log.Printf("remove %d backed entries...", len(fnames))
+				rlg.Infof("remove %d backed entries...", len(fnames))
 				for _, fname := range fnames {
 					if skipped[fname] {
-						log.Printf("   %s is bad, so rename it", fname)
+						rlg.Infof("   %s is bad, so rename it", fname)
 						if err := os.Rename(fname, fname+".bad"); err != nil {
-							log.Printf("[!] rename(%s) failed: %s", fname, err)
+							rlg.Warnf("rename(%s) failed: %s", fname, err)
 						}
 					} else {
 						if err := os.Remove(fname); err != nil {
-							log.Printf("[!] remove(%s) failed: %s", fname, err)
+							rlg.Warnf("remove(%s) failed: %s", fname, err)
 						}
 					}
 				}
 			}
 		}
 	}
-	return
+	if failed > 0 {
+		return fmt.Errorf("%w: %d archive(s) not created", ErrBackupFailed, failed)
+	}
+	return nil
 }
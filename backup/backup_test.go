@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const testBlob = `{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[]}`
+
+func writeLegacyZip(t *testing.T, fname string, names []string) {
+	t.Helper()
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(testBlob))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+// a snapshot archived under its legacy name is not stored again after
+// the loose file was renamed to the current scheme
+func TestMakeZipLegacyEntries(t *testing.T) {
+	dir := t.TempDir()
+	zipname := filepath.Join(dir, "eu.fordragon_20160210.zip")
+	writeLegacyZip(t, zipname, []string{"eu-fordragon-20160210_120000.json"})
+
+	ts1 := time.Date(2016, 2, 10, 12, 0, 0, 0, time.UTC)
+	ts2 := time.Date(2016, 2, 10, 13, 0, 0, 0, time.UTC)
+	var fnames []string
+	for _, ts := range []time.Time{ts1, ts2} {
+		fname := filepath.Join(dir, util.Make_FName("eu:fordragon", ts, true))
+		if err := util.Store(fname, util.Zip([]byte(testBlob))); err != nil {
+			t.Fatal(err)
+		}
+		fnames = append(fnames, fname)
+	}
+
+	skiplist, err := MakeZip(zipname, fnames, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skiplist) != 0 {
+		t.Errorf("skipped %v", skiplist)
+	}
+	names, err := archive.List(zipname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	want := []string{
+		"eu-fordragon-20160210_120000.json",
+		"eu.fordragon_20160210_130000.json",
+		MD5SUM,
+		SHA1SUM,
+	}
+	sort.Strings(want)
+	if len(names) != len(want) {
+		t.Fatalf("entries %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("entries %v, want %v", names, want)
+		}
+	}
+}
+
+func TestEntryKey(t *testing.T) {
+	cases := []struct{ a, b string }{
+		{"eu-fordragon-20160210_120000.json", "eu.fordragon_20160210_120000.json"},
+		{"eu.fordragon_20160210_120000.json.gz", "eu.fordragon_20160210_120000.json"},
+	}
+	for _, c := range cases {
+		if entryKey(c.a) != entryKey(c.b) {
+			t.Errorf("%s and %s differ: %#v, %#v", c.a, c.b, entryKey(c.a), entryKey(c.b))
+		}
+	}
+	if entryKey(MD5SUM) != MD5SUM {
+		t.Errorf("manifest key changed: %#v", entryKey(MD5SUM))
+	}
+	if entryKey("eu.fordragon_20160210_120000.json") == entryKey("eu.fordragon_20160210_130000.json") {
+		t.Error("different times have the same key")
+	}
+}
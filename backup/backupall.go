@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// backup settings which may differ between realms
+type realmBackup struct {
+	ext    string
+	nolast bool
+	clean  bool
+}
+
+// Backup snapshots of enabled realms from DownloadDirectory to daily
+// archives of BackupDirectory with settings of every realm. Realms with
+// the same settings are done in one pass.
+func BackupAll(cf *config.Config, lg *logging.Logger) error {
+	if err := util.CheckDir(cf.BackupDirectory); err != nil {
+		return err
+	}
+	var order []realmBackup
+	groups := make(map[realmBackup][]string)
+	for _, realm := range cf.RealmsList {
+		rs := cf.Realm(realm)
+		key := realmBackup{rs.BackupExt, rs.BackupWithoutLast, rs.RemoveAfterBackup}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], realm)
+	}
+	zopts := &ZstdOptions{Level: cf.BackupZstdLevel, Dict: cf.BackupZstdDict}
+	failed := 0
+	for _, key := range order {
+		err := Backup(cf.DownloadDirectory, cf.BackupDirectory, "20060102", key.ext,
+			key.nolast, key.clean, zopts, groups[key], lg)
+		if err != nil {
+			lg.Warnf("backup of %v failed: %s", groups[key], err)
+			failed++
+		}
+	}
+	result := "ok"
+	if failed > 0 {
+		result = "failed"
+	}
+	backupRuns.Inc(result)
+	backupLastRun.Set(float64(time.Now().Unix()), result)
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d pass(es)", ErrBackupFailed, failed, len(order))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// Entries of archive to drop when only the first snapshot of every
+// hour of every realm is kept.
+func HourlyExtra(fname string) ([]string, error) {
+	names, err := archive.List(fname)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var extra []string
+	for _, name := range names {
+		realm, ts, good := util.Parse_FName(name)
+		if !good {
+			continue
+		}
+		hour := realm + " " + ts.Truncate(time.Hour).Format("2006010215")
+		if seen[hour] {
+			extra = append(extra, name)
+		}
+		seen[hour] = true
+	}
+	return extra, nil
+}
+
+// Rewrite archive without extra entries. The kept ones are checked
+// against the manifests and extracted to tmpdir first, nothing is
+// changed when any of them doesn't match. The new archive replaces
+// the old one by rename in its directory.
+func Downsample(fname string, extra []string, tmpdir string, zopts *ZstdOptions, lg *logging.Logger) error {
+	realm, _, ext, good := util.Parse_ArchName(fname)
+	if !good {
+		return fmt.Errorf("%w: %s", ErrBadBackupExt, fname)
+	}
+	lg = lg.With("realm", realm)
+	drop := make(map[string]bool)
+	for _, name := range extra {
+		drop[name] = true
+	}
+	workdir := filepath.Join(tmpdir, "downsample-"+filepath.Base(fname))
+	if err := os.RemoveAll(workdir); err != nil {
+		return err
+	}
+	if err := util.CheckDir(workdir); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(workdir); err != nil {
+			lg.Warnf("%s not removed: %s", workdir, err)
+		}
+	}()
+
+	var kept []string
+	mismatches, err := ScanArchive(fname, func(name string) bool { return !drop[name] },
+		func(name string, ts time.Time, data []byte) error {
+			e_realm, ts, good := util.Parse_FName(name)
+			if !good {
+				return fmt.Errorf("ill-named entry %s", name)
+			}
+			target := filepath.Join(workdir, util.Make_FName(e_realm, ts, true))
+			kept = append(kept, target)
+			return util.Store(target, util.Zip(data))
+		})
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%s: %d mismatch(es) with manifests, first: %s %s",
+			fname, len(mismatches), mismatches[0].Entry, mismatches[0].Problem)
+	}
+
+	// built next to the original one, so it is renamed within a file system
+	newname := filepath.Join(filepath.Dir(fname), ".downsample-"+filepath.Base(fname))
+	if err := os.Remove(newname); err != nil && !os.IsNotExist(err) {
+		return err // left by an interrupted run, it would be merged
+	}
+	defer func() {
+		if err := os.Remove(newname); err != nil && !os.IsNotExist(err) {
+			lg.Warnf("%s not removed: %s", newname, err)
+		}
+	}()
+	var skiplist []string
+	switch ext {
+	case ".zip":
+		skiplist, err = MakeZip(newname, kept, lg)
+	default:
+		var dict []byte
+		if ext == ".tar.zst" && zopts != nil && zopts.Dict {
+			if dict, err = loadOrTrainDict(filepath.Dir(fname), realm, kept, zopts, lg); err != nil {
+				lg.Warnf("no zstd dictionary for %s, compress without it: %s", realm, err)
+				dict = nil
+			}
+		}
+		skiplist, err = MakeTarball(newname, kept, zopts, dict, lg)
+	}
+	if err != nil {
+		return err
+	}
+	if len(skiplist) > 0 {
+		return fmt.Errorf("%s: %d snapshot(s) not repacked", fname, len(skiplist))
+	}
+	return os.Rename(newname, fname)
+}
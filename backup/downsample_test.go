@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// the second snapshot of 12 hour is dropped, nothing but the archive
+// is left in its directory
+func TestDownsample(t *testing.T) {
+	srcdir, dir := t.TempDir(), t.TempDir()
+	zipname := filepath.Join(dir, "eu.fordragon_20160210.zip")
+	var fnames, kept []string
+	for _, ts := range []time.Time{
+		time.Date(2016, 2, 10, 12, 0, 0, 0, time.UTC),
+		time.Date(2016, 2, 10, 12, 20, 0, 0, time.UTC),
+		time.Date(2016, 2, 10, 13, 0, 0, 0, time.UTC),
+	} {
+		name := util.Make_FName("eu:fordragon", ts, true)
+		if err := util.Store(filepath.Join(srcdir, name), util.Zip([]byte(testBlob))); err != nil {
+			t.Fatal(err)
+		}
+		fnames = append(fnames, filepath.Join(srcdir, name))
+		if ts.Minute() == 0 {
+			kept = append(kept, util.Make_FName("eu:fordragon", ts, false))
+		}
+	}
+	if _, err := MakeZip(zipname, fnames, nil); err != nil {
+		t.Fatal(err)
+	}
+	extra, err := HourlyExtra(zipname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extra) != 1 {
+		t.Fatalf("extra %v", extra)
+	}
+
+	if err := Downsample(zipname, extra, t.TempDir(), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	names, err := archive.List(zipname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(kept, MD5SUM, SHA1SUM)
+	sort.Strings(names)
+	sort.Strings(want)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries %v, want %v", names, want)
+	}
+	left, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].Name() != filepath.Base(zipname) {
+		t.Errorf("files left %v", left)
+	}
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const (
+	MD5SUM  = "md5sum.txt"
+	SHA1SUM = "sha1sum.txt"
+)
+
+// entry of archive which does not match its manifests
+type Mismatch struct {
+	Archive string `json:"archive"`
+	Entry   string `json:"entry"`
+	Problem string `json:"problem"`
+}
+
+// parse "hash name" lines of md5sum.txt / sha1sum.txt
+func parseManifest(data []byte) map[string]string {
+	m := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		v := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+		if len(v) != 2 {
+			continue
+		}
+		m[strings.TrimLeft(v[1], " *")] = v[0]
+	}
+	return m
+}
+
+// Walk snapshot entries of archive accepted by want (nil means all),
+// pass their data to fn (may be nil) and check them against the
+// manifests stored in the same archive. When all entries are wanted
+// manifest lines without entries are reported too.
+func ScanArchive(fname string, want func(name string) bool,
+	fn func(name string, ts time.Time, data []byte) error) (mismatches []Mismatch, err error) {
+	var md5sum, sha1sum map[string]string
+	seen_md5 := make(map[string]string)
+	seen_sha1 := make(map[string]string)
+	var order []string
+	err = archive.Walk(fname, func(name string, ts time.Time, r io.Reader) error {
+		switch name {
+		case MD5SUM, SHA1SUM:
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if name == MD5SUM {
+				md5sum = parseManifest(data)
+			} else {
+				sha1sum = parseManifest(data)
+			}
+			return nil
+		}
+		if want != nil && !want(name) {
+			return nil
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		order = append(order, name)
+		seen_md5[name] = util.MakeMD5(data)
+		seen_sha1[name] = util.MakeSHA1(data)
+		if fn != nil {
+			return fn(name, ts, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if md5sum == nil {
+		mismatches = append(mismatches, Mismatch{fname, MD5SUM, "manifest missing"})
+	}
+	if sha1sum == nil {
+		mismatches = append(mismatches, Mismatch{fname, SHA1SUM, "manifest missing"})
+	}
+	for _, name := range order {
+		if md5sum != nil {
+			if sum, ok := md5sum[name]; !ok {
+				mismatches = append(mismatches, Mismatch{fname, name, "not in " + MD5SUM})
+			} else if sum != seen_md5[name] {
+				mismatches = append(mismatches, Mismatch{fname, name, "md5 mismatch"})
+			}
+		}
+		if sha1sum != nil {
+			if sum, ok := sha1sum[name]; !ok {
+				mismatches = append(mismatches, Mismatch{fname, name, "not in " + SHA1SUM})
+			} else if sum != seen_sha1[name] {
+				mismatches = append(mismatches, Mismatch{fname, name, "sha1 mismatch"})
+			}
+		}
+	}
+	if want == nil {
+		lost := make(map[string]bool)
+		for _, m := range []map[string]string{md5sum, sha1sum} {
+			for name := range m {
+				if _, ok := seen_md5[name]; !ok {
+					lost[name] = true
+				}
+			}
+		}
+		var names []string
+		for name := range lost {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			mismatches = append(mismatches, Mismatch{fname, name, "listed but not stored"})
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,14 @@
+package backup
+
+import (
+	metrics "github.com/wowauc/gowowuction/metrics"
+)
+
+var (
+	backupArchives = metrics.NewCounter("gowowuction_backup_archives_total",
+		"Archives written by backup by result: ok or failed.", "realm", "result")
+	backupRuns = metrics.NewCounter("gowowuction_backup_runs_total",
+		"Runs of BackupAll by result: ok or failed.", "result")
+	backupLastRun = metrics.NewGauge("gowowuction_backup_last_run_timestamp_seconds",
+		"Time of the last run of BackupAll by result.", "result")
+)
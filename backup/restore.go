@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// what to restore; zero values mean "everything"
+type RestoreFilter struct {
+	Realms   []string
+	From     time.Time
+	To       time.Time
+	Archives []string // glob patterns for archive base names
+}
+
+func (f *RestoreFilter) matchArchive(fname string) bool {
+	realm, key, _, good := util.Parse_ArchName(fname)
+	if !good {
+		return false
+	}
+	if len(f.Realms) > 0 && !contains(f.Realms, realm) {
+		return false
+	}
+	if len(key) == len("20060102") { // daily archive out of time range
+		if day, err := util.ParseTS(key); err == nil {
+			if !f.To.IsZero() && day.After(f.To) {
+				return false
+			}
+			if !f.From.IsZero() && !day.AddDate(0, 0, 1).After(f.From) {
+				return false
+			}
+		}
+	}
+	if len(f.Archives) == 0 {
+		return true
+	}
+	for _, pattern := range f.Archives {
+		if ok, _ := filepath.Match(pattern, filepath.Base(fname)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *RestoreFilter) matchEntry(name string) bool {
+	realm, ts, good := util.Parse_FName(name)
+	if !good {
+		return false
+	}
+	if len(f.Realms) > 0 && !contains(f.Realms, realm) {
+		return false
+	}
+	if !f.From.IsZero() && ts.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && ts.After(f.To) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type RestoreReport struct {
+	Archives   int
+	Restored   int
+	Existing   int // already present in target directory
+	Mismatches []Mismatch
+}
+
+// Extract snapshots matched by filter from archives of srcdir to dstdir
+// as zipped files with standard names. Existing files are not touched.
+// Entries which don't match the manifests are stored with ".bad" suffix.
+func Restore(srcdir, dstdir string, filter *RestoreFilter, lg *logging.Logger) (*RestoreReport, error) {
+	report := new(RestoreReport)
+	fnames, err := filepath.Glob(filepath.Join(srcdir, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("glob failed: %w", err)
+	}
+	sort.Sort(util.ByBasename(fnames))
+	if err := util.CheckDir(dstdir); err != nil {
+		return nil, err
+	}
+	for _, fname := range fnames {
+		if !archive.IsArchive(fname) || !filter.matchArchive(fname) {
+			continue
+		}
+		lg.Infof("restore from %s ...", fname)
+		report.Archives++
+		stored := make(map[string]string) // entry -> tmp file
+		mismatches, err := ScanArchive(fname, filter.matchEntry,
+			func(name string, ts time.Time, data []byte) error {
+				realm, ts, _ := util.Parse_FName(name)
+				target := filepath.Join(dstdir, util.Make_FName(realm, ts, true))
+				if exists, err := util.CheckFile(target); err != nil {
+					return err
+				} else if exists {
+					lg.Infof("... %s already exists", target)
+					report.Existing++
+					return nil
+				}
+				if err := util.Store(target+".tmp", util.Zip(data)); err != nil {
+					return err
+				}
+				stored[name] = target
+				return nil
+			})
+		bad := make(map[string]bool)
+		for _, m := range mismatches {
+			lg.Warnf("%s: %s: %s", m.Archive, m.Entry, m.Problem)
+			bad[m.Entry] = true
+		}
+		report.Mismatches = append(report.Mismatches, mismatches...)
+		for name, target := range stored {
+			final := target
+			if err != nil {
+				if rmerr := os.Remove(target + ".tmp"); rmerr != nil {
+					lg.Warnf("%s", rmerr)
+				}
+				continue
+			}
+			if bad[name] {
+				final = target + ".bad"
+			} else {
+				report.Restored++
+			}
+			if err := os.Rename(target+".tmp", final); err != nil {
+				return report, err
+			}
+		}
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", fname, err)
+		}
+	}
+	lg.Infof("%d snapshot(s) restored from %d archive(s), %d existing, %d mismatch(es)",
+		report.Restored, report.Archives, report.Existing, len(report.Mismatches))
+	return report, nil
+}
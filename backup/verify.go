@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	logging "github.com/wowauc/gowowuction/logging"
+	parser "github.com/wowauc/gowowuction/parser"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// hole in snapshot timeline of realm
+type Gap struct {
+	Realm  string    `json:"realm"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Length string    `json:"length"`
+}
+
+type VerifyReport struct {
+	Archives     int        `json:"archives"`
+	Entries      int        `json:"entries"`
+	BadArchives  []Mismatch `json:"badArchives"`
+	Mismatches   []Mismatch `json:"mismatches"`
+	BadSnapshots []Mismatch `json:"badSnapshots"`
+	Gaps         []Gap      `json:"gaps"`
+}
+
+func (r *VerifyReport) OK() bool {
+	return len(r.BadArchives) == 0 && len(r.Mismatches) == 0 &&
+		len(r.BadSnapshots) == 0 && len(r.Gaps) == 0
+}
+
+// Check every archive of dir against its manifests, validate every
+// snapshot and look for holes longer than maxgap in timeline of every
+// realm (zero maxgap disables the check).
+func Verify(dir string, maxgap time.Duration, lg *logging.Logger) (*VerifyReport, error) {
+	report := new(VerifyReport)
+	fnames, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("glob failed: %w", err)
+	}
+	sort.Sort(util.ByBasename(fnames))
+	timeline := make(map[string][]time.Time)
+	for _, fname := range fnames {
+		if !archive.IsArchive(fname) {
+			continue
+		}
+		lg.Infof("verify %s ...", fname)
+		report.Archives++
+		mismatches, err := ScanArchive(fname, nil,
+			func(name string, ts time.Time, data []byte) error {
+				report.Entries++
+				realm, ts, good := util.Parse_FName(name)
+				if !good {
+					report.BadSnapshots = append(report.BadSnapshots,
+						Mismatch{fname, name, "ill-named entry"})
+					return nil
+				}
+				if _, err := parser.ParseSnapshot(data); err != nil {
+					report.BadSnapshots = append(report.BadSnapshots,
+						Mismatch{fname, name, err.Error()})
+					return nil
+				}
+				timeline[realm] = append(timeline[realm], ts)
+				return nil
+			})
+		if err != nil {
+			lg.Warnf("%s: %s", fname, err)
+			report.BadArchives = append(report.BadArchives, Mismatch{fname, "", err.Error()})
+			continue
+		}
+		report.Mismatches = append(report.Mismatches, mismatches...)
+	}
+	if maxgap > 0 {
+		var realms []string
+		for realm := range timeline {
+			realms = append(realms, realm)
+		}
+		sort.Strings(realms)
+		for _, realm := range realms {
+			report.Gaps = append(report.Gaps, findGaps(realm, timeline[realm], maxgap)...)
+		}
+	}
+	lg.Infof("%d archive(s), %d entries: %d bad archive(s), %d mismatch(es), %d bad snapshot(s), %d gap(s)",
+		report.Archives, report.Entries, len(report.BadArchives), len(report.Mismatches),
+		len(report.BadSnapshots), len(report.Gaps))
+	return report, nil
+}
+
+func findGaps(realm string, times []time.Time, maxgap time.Duration) (gaps []Gap) {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i := 1; i < len(times); i++ {
+		if d := times[i].Sub(times[i-1]); d > maxgap {
+			gaps = append(gaps, Gap{realm, times[i-1], times[i], d.String()})
+		}
+	}
+	return gaps
+}
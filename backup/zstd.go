@@ -0,0 +1,148 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	zstd "github.com/klauspost/compress/zstd"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const (
+	ZSTD_DEFAULT_LEVEL     = 3
+	ZSTD_DEFAULT_DICT_SIZE = 112 * 1024
+	ZSTD_DICT_SAMPLES      = 16
+	ZSTD_SAMPLE_SIZE       = 1024 * 1024 // only head of every sample is used
+	ZSTD_BLOCK_SIZE        = 64 * 1024
+)
+
+var ErrNoSamples = errors.New("not enough samples for dictionary")
+
+// settings of .tar.zst backups
+type ZstdOptions struct {
+	Level    int  // zstd level 1..22, zero means ZSTD_DEFAULT_LEVEL
+	Dict     bool // compress with per-realm dictionary
+	DictSize int  // zero means ZSTD_DEFAULT_DICT_SIZE
+}
+
+func (o *ZstdOptions) level() int {
+	if o == nil || o.Level <= 0 {
+		return ZSTD_DEFAULT_LEVEL
+	}
+	return o.Level
+}
+
+func (o *ZstdOptions) dictSize() int {
+	if o == nil || o.DictSize <= 0 {
+		return ZSTD_DEFAULT_DICT_SIZE
+	}
+	return o.DictSize
+}
+
+// Name of dictionary file for realm. Dictionaries are never replaced,
+// so every archive can be read with the dictionaries lying near it
+// (zstd frames refer to them by id).
+func DictName(dir, realm string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_dict-%08x%s", util.Safe_Realm(realm), id, archive.DICT_EXT))
+}
+
+func dictMask(dir, realm string) string {
+	return filepath.Join(dir, util.Safe_Realm(realm)+"_dict-*"+archive.DICT_EXT)
+}
+
+// Train zstd dictionary on raw snapshots
+func TrainDict(samples [][]byte, level, size int) (dict []byte, err error) {
+	if len(samples) == 0 {
+		return nil, ErrNoSamples
+	}
+	var history []byte
+	var contents [][]byte // BuildDict encodes every content as a single block
+	total := 0
+	chunk := size / len(samples)
+	for _, sample := range samples {
+		if len(sample) > ZSTD_SAMPLE_SIZE {
+			sample = sample[:ZSTD_SAMPLE_SIZE]
+		}
+		total += len(sample)
+		for i := 0; i < len(sample); i += ZSTD_BLOCK_SIZE {
+			end := i + ZSTD_BLOCK_SIZE
+			if end > len(sample) {
+				end = len(sample)
+			}
+			contents = append(contents, sample[i:end])
+		}
+		if len(sample) > chunk {
+			sample = sample[:chunk]
+		}
+		history = append(history, sample...)
+	}
+	// samples have to be much bigger than the dictionary,
+	// BuildDict even panics when they are covered by it entirely
+	if total < 2*len(history) {
+		return nil, fmt.Errorf("%w: %d bytes only", ErrNoSamples, total)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			dict, err = nil, fmt.Errorf("dictionary training failed: %v", r)
+		}
+	}()
+	// ids below 32768 are reserved by zstd format
+	id := crc32.ChecksumIEEE(history)&0x7fffffff | 0x8000
+	return zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: contents,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.EncoderLevelFromZstd(level),
+	})
+}
+
+// Dictionary of realm from dir. When there is none yet it is trained
+// on the first snapshots of fnames and stored in dir.
+func loadOrTrainDict(dir, realm string, fnames []string, opts *ZstdOptions, lg *logging.Logger) ([]byte, error) {
+	found, err := filepath.Glob(dictMask(dir, realm))
+	if err != nil {
+		return nil, fmt.Errorf("glob failed: %w", err)
+	}
+	if len(found) > 0 {
+		sort.Strings(found)
+		return ioutil.ReadFile(found[len(found)-1])
+	}
+	var samples [][]byte
+	for _, fname := range fnames {
+		if len(samples) >= ZSTD_DICT_SAMPLES {
+			break
+		}
+		data, err := util.Load(fname)
+		if err != nil || validate_blob(data) != nil {
+			continue
+		}
+		samples = append(samples, data)
+	}
+	lg.Infof("train zstd dictionary for %s on %d sample(s) ...", realm, len(samples))
+	dict, err := TrainDict(samples, opts.level(), opts.dictSize())
+	if err != nil {
+		return nil, err
+	}
+	d, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		return nil, err
+	}
+	dname := DictName(dir, realm, d.ID())
+	if err := util.Store(dname+".tmp", dict); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(dname+".tmp", dname); err != nil {
+		return nil, err
+	}
+	lg.Infof("... dictionary %s stored (%d bytes)", dname, len(dict))
+	return dict, nil
+}
@@ -0,0 +1,585 @@
+package main
+
+// Command line is
+//
+//	gowowuction [global flags] command [flags] [command [flags] ...]
+//
+// commands run in the given order, so cron line may look like
+// "gowowuction fetch parse backup".
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	backup "github.com/wowauc/gowowuction/backup"
+	config "github.com/wowauc/gowowuction/config"
+	export "github.com/wowauc/gowowuction/export"
+	logging "github.com/wowauc/gowowuction/logging"
+	query "github.com/wowauc/gowowuction/query"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// exit code is a sum of failed stages
+const (
+	EXIT_FETCH  = 1 << iota // fetch failed
+	EXIT_PARSE              // parse or reparse failed
+	EXIT_BACKUP             // backup failed
+	EXIT_OTHER              // any other command failed
+	EXIT_USAGE              // bad command line, nothing was run
+	EXIT_CONFIG             // config not loaded, nothing was run
+)
+
+var (
+	ErrNoCommand      = errors.New("no command given")
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrNoDryRun       = errors.New("command does not support --dry-run")
+	ErrUnknownRealm   = errors.New("realm is not in config")
+)
+
+// global flags
+type options struct {
+	config string
+	realms listFlag
+	dryrun bool
+	stdout bool // stdout is taken by output of command, log to stderr
+}
+
+type runFunc func(cf *config.Config, lg *logging.Logger) error
+
+type command struct {
+	name   string
+	short  string
+	stage  int  // exit code bit
+	config bool // needs loaded config
+	dryrun bool // honours --dry-run
+	// define flags of command, returned func runs it with them
+	setup func(fs *flag.FlagSet, o *options) runFunc
+}
+
+// repeatable flag, every value may be a comma separated list
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// time flag as 20060102[_150405], zero when not set
+type timeFlag struct {
+	time.Time
+}
+
+func (t *timeFlag) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return util.TSStr(t.Time)
+}
+
+func (t *timeFlag) Set(s string) (err error) {
+	t.Time, err = util.ParseTS(s)
+	return
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"dfltcfg", "write default config next to config as <config>.default", EXIT_OTHER, false, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return func(cf *config.Config, lg *logging.Logger) error {
+					fname := o.config + ".default"
+					if err := config.Default().Save(fname); err != nil {
+						return fmt.Errorf("default config not saved: %w", err)
+					}
+					lg.Infof("default config stored to %s", fname)
+					return nil
+				}
+			}},
+		{"config check", "validate config and report all its problems", EXIT_CONFIG, false, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return func(*config.Config, *logging.Logger) error {
+					return checkConfig(o)
+				}
+			}},
+		{"config show", "print config as loaded, with secrets redacted", EXIT_CONFIG, false, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return func(*config.Config, *logging.Logger) error {
+					return showConfig(o)
+				}
+			}},
+		{"fetch", "download fresh snapshots", EXIT_FETCH, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return DoFetch
+			}},
+		{"parse", "parse downloaded snapshots", EXIT_PARSE, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return DoParse
+			}},
+		{"backup", "pack downloaded snapshots to daily archives", EXIT_BACKUP, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return DoBackup
+			}},
+		{"pets", "print the most profitable pets", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				top := fs.Int("top", PETS_TOP, "number of pets to print per realm")
+				return func(cf *config.Config, lg *logging.Logger) error {
+					return DoPets(cf, lg, *top)
+				}
+			}},
+		{"migrate", "rename files of old naming scheme", EXIT_OTHER, true, true,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return func(cf *config.Config, lg *logging.Logger) error {
+					return DoMigrate(cf, lg, o.dryrun)
+				}
+			}},
+		{"reparse", "parse snapshots again from downloads and backups", EXIT_PARSE, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				var from, to timeFlag
+				fs.Var(&from, "from", "first snapshot time as 20060102[_150405] (default the first one)")
+				fs.Var(&to, "to", "last snapshot time as 20060102[_150405] (default the last one)")
+				return func(cf *config.Config, lg *logging.Logger) error {
+					return DoReparse(cf, lg, from.Time, to.Time)
+				}
+			}},
+		{"restore", "extract snapshots from backups", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				var from, to timeFlag
+				var archives listFlag
+				dir := fs.String("dir", "", "target directory (default download directory)")
+				fs.Var(&from, "from", "first snapshot time as 20060102[_150405]")
+				fs.Var(&to, "to", "last snapshot time as 20060102[_150405]")
+				fs.Var(&archives, "archive", "restore from these archives only (repeatable)")
+				return func(cf *config.Config, lg *logging.Logger) error {
+					filter := &backup.RestoreFilter{Archives: archives, From: from.Time, To: to.Time}
+					filter.Realms = o.realms // all realms of backups unless given
+					return DoRestore(cf, lg, *dir, filter)
+				}
+			}},
+		{"verify", "check backups against their manifests and look for gaps", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				gap := fs.Duration("gap", VERIFY_MAX_GAP, "max hole in timeline, 0 disables the check")
+				report := fs.String("report", "", "file for json report, \"-\" for stdout (default in log directory)")
+				return func(cf *config.Config, lg *logging.Logger) error {
+					return DoVerify(cf, lg, *gap, *report)
+				}
+			}},
+		{"delta", "store new snapshots to delta directory", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return DoDelta
+			}},
+		{"undelta", "rebuild snapshots from delta directory", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				var from, to timeFlag
+				dir := fs.String("dir", "", "target directory (default download directory)")
+				fs.Var(&from, "from", "first snapshot time as 20060102[_150405]")
+				fs.Var(&to, "to", "last snapshot time as 20060102[_150405]")
+				return func(cf *config.Config, lg *logging.Logger) error {
+					return DoUndelta(cf, lg, *dir, from.Time, to.Time)
+				}
+			}},
+		{"daemon", "run stages periodically, serving /metrics", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				every := fs.Duration("every", DAEMON_EVERY, "interval between starts of runs")
+				listen := fs.String("listen", DAEMON_LISTEN, "address of /metrics endpoint, empty disables it")
+				var stages listFlag
+				fs.Var(&stages, "run", "stages to run, comma separated (default fetch,parse,backup)")
+				return func(cf *config.Config, lg *logging.Logger) error {
+					if stages == nil {
+						stages = DAEMON_STAGES
+					}
+					steps, err := daemonSteps(stages)
+					if err != nil {
+						return err
+					}
+					return DoDaemon(cf, lg, *every, *listen, steps)
+				}
+			}},
+		{"import", "copy json lines results to embedded database", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return DoImport
+			}},
+		{"export", "write closed auctions as csv or parquet files by month", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				var from, to timeFlag
+				var columns listFlag
+				opts := export.Options{}
+				fs.StringVar(&opts.Format, "format", export.FORMAT_CSV, "csv or parquet")
+				fs.StringVar(&opts.Dir, "dir", "", "target directory (default "+EXPORT_DIR+" in result directory)")
+				fs.Var(&columns, "columns", "columns to write, comma separated (default all: "+
+					strings.Join(export.ColumnNames(), ",")+")")
+				fs.Var(&from, "from", "first closing time as 20060102[_150405]")
+				fs.Var(&to, "to", "last closing time as 20060102[_150405]")
+				return func(cf *config.Config, lg *logging.Logger) error {
+					opts.Columns, opts.From, opts.To = columns, from.Time, to.Time
+					return DoExport(cf, lg, opts)
+				}
+			}},
+		{"query", "search closed auctions with filter, grouping and aggregation", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				var fields, group, aggs, sorting listFlag
+				spec := &query.Spec{}
+				fs.StringVar(&spec.Filter, "where", "", "filter as space separated <field><op><value> terms, "+
+					"ops are = != < <= > >=, = and != take comma separated values, "+
+					"times are 20060102[_150405] (e.g. \"item=124101 result=sold,bought closed>=20160210\")")
+				fs.Var(&fields, "fields", "fields to list without aggregation, comma separated (default "+
+					strings.Join(query.DEFAULT_FIELDS, ",")+"), known are "+strings.Join(query.FieldNames(), ","))
+				fs.Var(&group, "group", "fields to group by, comma separated")
+				fs.Var(&aggs, "agg", "aggregations of groups: count, sum(f), avg(f), min(f), max(f), p<N>(f) (default count)")
+				fs.Var(&sorting, "sort", "columns of result to sort by, \"-\" prefix for descending order")
+				fs.IntVar(&spec.Limit, "limit", 0, "max rows of result, 0 is unlimited")
+				output := fs.String("output", query.OUTPUT_TABLE, "table, json or csv")
+				o.stdout = true
+				return func(cf *config.Config, lg *logging.Logger) error {
+					spec.Fields, spec.Group, spec.Aggs, spec.Sort = fields, group, aggs, sorting
+					return DoQuery(cf, lg, spec, *output)
+				}
+			}},
+		{"diff", "compare two snapshots as the processor sees them", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				older := fs.String("old", "", "older snapshot file or its time as 20060102_150405 (for the realm of --realm)")
+				newer := fs.String("new", "", "newer snapshot file or its time as 20060102_150405")
+				output := fs.String("output", DIFF_TEXT, "text or json")
+				o.stdout = true
+				return func(cf *config.Config, lg *logging.Logger) error {
+					if *older == "" || *newer == "" {
+						return errors.New("--old and --new are required")
+					}
+					return DoDiff(cf, lg, *older, *newer, *output)
+				}
+			}},
+		{"serve", "serve read-only json api over processed data", EXIT_OTHER, true, false,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				listen := fs.String("listen", SERVE_LISTEN, "address of api")
+				return func(cf *config.Config, lg *logging.Logger) error {
+					return DoServe(cf, lg, *listen)
+				}
+			}},
+		{"prune", "apply retention rules of config", EXIT_OTHER, true, true,
+			func(fs *flag.FlagSet, o *options) runFunc {
+				return func(cf *config.Config, lg *logging.Logger) error {
+					return DoPrune(cf, lg, o.dryrun)
+				}
+			}},
+	}
+}
+
+// command named by the first one or two words of args ("config check")
+// and the rest args
+func findCommand(args []string) (*command, []string) {
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):]
+		}
+	}
+	return nil, args
+}
+
+func globalFlags(o *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("gowowuction", flag.ContinueOnError)
+	fs.StringVar(&o.config, "config", "", "config file (default <executable>.config.json)")
+	fs.Var(&o.realms, "realm", "work on these configured realms only (repeatable, comma separated)")
+	fs.BoolVar(&o.dryrun, "dry-run", false, "change nothing, for commands which support it")
+	return fs
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: gowowuction [global flags] command [flags] [command [flags] ...]")
+	fmt.Fprintln(w, "\ncommands run in the given order, e.g. \"gowowuction fetch parse backup\"")
+	fmt.Fprintln(w, "\nglobal flags:")
+	fs := globalFlags(new(options))
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(w, "  %-13s %s\n", "help", "print help of all commands or of the given one")
+	fmt.Fprintln(w, "\nexit code is a sum of:")
+	fmt.Fprintf(w, "  %2d fetch failed\n", EXIT_FETCH)
+	fmt.Fprintf(w, "  %2d parse or reparse failed\n", EXIT_PARSE)
+	fmt.Fprintf(w, "  %2d backup failed\n", EXIT_BACKUP)
+	fmt.Fprintf(w, "  %2d other command failed\n", EXIT_OTHER)
+	fmt.Fprintf(w, "  %2d bad command line\n", EXIT_USAGE)
+	fmt.Fprintf(w, "  %2d config not loaded\n", EXIT_CONFIG)
+}
+
+func printCommandUsage(w io.Writer, cmd *command, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "usage: gowowuction [global flags] %s [flags]\n\n%s\n", cmd.name, cmd.short)
+	if cmd.dryrun {
+		fmt.Fprintln(w, "honours global --dry-run")
+	}
+	n := 0
+	fs.VisitAll(func(*flag.Flag) { n++ })
+	if n > 0 {
+		fmt.Fprintln(w, "\nflags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+func newFlagSet(cmd *command, o *options) (*flag.FlagSet, runFunc) {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	run := cmd.setup(fs, o)
+	fs.Usage = func() { printCommandUsage(os.Stderr, cmd, fs) }
+	return fs, run
+}
+
+// "help [command]", prints to stdout
+func printHelp(args []string) error {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return nil
+	}
+	cmd, _ := findCommand(args)
+	if cmd == nil {
+		return fmt.Errorf("%w %#v", ErrUnknownCommand, strings.Join(args, " "))
+	}
+	fs, _ := newFlagSet(cmd, new(options))
+	printCommandUsage(os.Stdout, cmd, fs)
+	return nil
+}
+
+// error of flag package, it is printed already with usage
+type flagError struct {
+	error
+}
+
+func (e flagError) Unwrap() error {
+	return e.error
+}
+
+type step struct {
+	cmd *command
+	run runFunc
+}
+
+// commands changing downloads, backups or results, they run under lock
+// shared with daemon and console of other processes
+var LOCKED_COMMANDS = map[string]bool{
+	"fetch": true, "parse": true, "backup": true, "reparse": true,
+	"migrate": true, "delta": true, "import": true, "prune": true,
+}
+
+// run step, holding the data lock if its command needs it
+func (st *step) exec(cf *config.Config, lg *logging.Logger) error {
+	if LOCKED_COMMANDS[st.cmd.name] {
+		lock, err := util.TryLock(cf.GetLockFName())
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+	return st.run(cf, lg)
+}
+
+// Parse whole command line before anything is run. flag.ErrHelp is
+// returned when help was asked and printed.
+func parseArgs(args []string) (*options, []step, error) {
+	o := new(options)
+	fs := globalFlags(o)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() { printUsage(os.Stderr) }
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, flagError{err}
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return nil, nil, ErrNoCommand
+	}
+	var steps []step
+	for len(args) > 0 {
+		if args[0] == "help" {
+			if err := printHelp(args[1:]); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, flag.ErrHelp
+		}
+		cmd, rest := findCommand(args)
+		if cmd == nil {
+			return nil, nil, fmt.Errorf("%w %#v, run \"gowowuction help\" for the list", ErrUnknownCommand, args[0])
+		}
+		if o.dryrun && !cmd.dryrun {
+			return nil, nil, fmt.Errorf("%s: %w", cmd.name, ErrNoDryRun)
+		}
+		cfs, run := newFlagSet(cmd, o)
+		if err := cfs.Parse(rest); err != nil {
+			return nil, nil, flagError{err}
+		}
+		args = cfs.Args()
+		steps = append(steps, step{cmd, run})
+	}
+	return o, steps, nil
+}
+
+// leave only realms given by --realm in config
+func filterRealms(cf *config.Config, realms []string) error {
+	if len(realms) == 0 {
+		return nil
+	}
+	for _, realm := range realms {
+		found := false
+		for _, r := range cf.RealmsList {
+			found = found || r == realm
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", ErrUnknownRealm, realm)
+		}
+	}
+	cf.RealmsList = realms
+	return nil
+}
+
+// load config without side effects of setup, print its problems
+func checkConfig(o *options) error {
+	cf, err := config.Load(o.config)
+	var verr *config.ValidationError
+	if errors.As(err, &verr) {
+		for _, problem := range verr.Problems {
+			fmt.Printf("%s: %s\n", o.config, problem)
+		}
+		return fmt.Errorf("%d problem(s) found", len(verr.Problems))
+	}
+	if err != nil {
+		return err
+	}
+	if err := filterRealms(cf, o.realms); err != nil {
+		return err
+	}
+	if cf.APIKey == "" {
+		fmt.Printf("%s: apikey is empty, fetch will fail\n", o.config)
+	}
+	fmt.Printf("%s is valid\n", o.config)
+	return nil
+}
+
+func showConfig(o *options) error {
+	cf, err := config.Load(o.config)
+	if err != nil {
+		return err
+	}
+	if err := filterRealms(cf, o.realms); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cf.Redacted(), "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// load config and start logging to file of log directory
+func setup(o *options) (*config.Config, error) {
+	log.Println("preinitialize ...")
+	cf, err := config.Load(o.config)
+	if err != nil {
+		return nil, err
+	}
+	if err := filterRealms(cf, o.realms); err != nil {
+		return nil, err
+	}
+	if err := util.CheckDir(cf.LogDirectory); err != nil {
+		return nil, err
+	}
+	level, _ := logging.ParseLevel(cf.Log.Level) // checked by config.Load
+	console := os.Stdout
+	if o.stdout {
+		console = os.Stderr
+	}
+	err = logging.Setup(logging.Options{
+		Level:    level,
+		Format:   cf.Log.Format,
+		Dir:      cf.LogDirectory,
+		MaxSize:  int64(cf.Log.MaxSizeMB) << 20,
+		Compress: !cf.Log.NoCompress,
+		Console:  console,
+	})
+	if err != nil {
+		logging.Warnf("log file not opened, log to console only: %s", err)
+	}
+	log.Println("=== application started at " + util.TSStr(time.Now()))
+	cf.Dump()
+	dirs := []string{cf.DownloadDirectory, cf.ResultDirectory, cf.BackupDirectory}
+	for _, realm := range cf.RealmsList {
+		dirs = append(dirs, cf.Realm(realm).ResultDirectory)
+	}
+	for _, dir := range dirs {
+		if err := util.CheckDir(dir); err != nil {
+			return nil, err
+		}
+	}
+	return cf, nil
+}
+
+func main() {
+	// until config is loaded
+	logging.Setup(logging.Options{Level: logging.INFO, Format: logging.FORMAT_LOGFMT, Console: os.Stderr})
+
+	o, steps, err := parseArgs(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	var ferr flagError
+	if errors.As(err, &ferr) {
+		os.Exit(EXIT_USAGE)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), err)
+		os.Exit(EXIT_USAGE)
+	}
+	if o.config == "" {
+		if o.config, err = config.ConfigName(); err != nil {
+			log.Println(err)
+			os.Exit(EXIT_CONFIG)
+		}
+	}
+	var cf *config.Config
+	for _, st := range steps {
+		if st.cmd.config {
+			if cf, err = setup(o); err != nil {
+				logging.Errorf("config load error: %s", err)
+				os.Exit(EXIT_CONFIG)
+			}
+			break
+		}
+	}
+
+	code := 0
+	var summary []string
+	for _, st := range steps {
+		lg := logging.With("stage", st.cmd.name)
+		if err := st.exec(cf, lg); err != nil {
+			lg.Errorf("%s failed: %s", st.cmd.name, err)
+			code |= st.cmd.stage
+			summary = append(summary, st.cmd.name+" FAILED")
+		} else {
+			summary = append(summary, st.cmd.name+" ok")
+		}
+	}
+	log.Printf("=== application finished at %s: %s", util.TSStr(time.Now()), strings.Join(summary, ", "))
+	logging.Close()
+	os.Exit(code)
+}
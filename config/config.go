@@ -1,66 +1,150 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"path/filepath"
-	//	"regexp"
+	"regexp"
 	"strings"
 	"time"
 
+	logging "github.com/wowauc/gowowuction/logging"
 	util "github.com/wowauc/gowowuction/util"
 )
 
 const SLASH = filepath.Separator
 
+// lock file in download directory, see util.TryLock
+const LOCK_NAME = "gowowuction.lock"
+
+var (
+	ErrConfigRead  = errors.New("config not read")
+	ErrConfigParse = errors.New("config not parsed")
+)
+
+// expiration interval for timeLeft bucket, in time.ParseDuration format
+type Interval struct {
+	Min string `json:"min"`
+	Max string `json:"max"`
+}
+
+// Retention rules as ages like "14d", "6m" (see util.ParseAge),
+// empty age means "keep forever"
+type Retention struct {
+	Downloads  string `json:"downloads"`  // loose snapshots, only parsed and archived ones or duplicates go
+	Backups    string `json:"backups"`    // daily archives
+	Results    string `json:"results"`    // result files not modified for that long
+	Downsample string `json:"downsample"` // older archives keep one snapshot per hour
+}
+
+// Log records of main application, see logging package
+type LogOptions struct {
+	Level      string `json:"level"`       // debug, info, warn or error
+	Format     string `json:"format"`      // logfmt or json
+	MaxSizeMB  int    `json:"max_size_mb"` // rotate above this size too, 0 for daily rotation only
+	NoCompress bool   `json:"no_compress"` // keep rotated logs as is
+}
+
+// storage of results, see parser.OpenStore
+const (
+	STORAGE_JSONL = "jsonl" // monthly json lines files
+	STORAGE_BOLT  = "bolt"  // embedded database file per realm
+)
+
 type Config struct {
-	APIKey            string   `json:"apikey"`
-	RealmsList        []string `json:"realms"`
-	LocalesList       []string `json:"locales"`
-	LogDirectory      string   `json:"log_dir"`
-	DownloadDirectory string   `json:"download_dir"`
-	TempDirectory     string   `json:"temp_dir"`
-	ResultDirectory   string   `json:"result_dir"`
-	BackupDirectory   string   `json:"backup_dir"`
-	BackupExt         string   `json:"backup_ext"`
-	NameFormat        string   `json:"name_format"`
-	TimedNameFormat   string   `json:"timed_name_format"`
-	BackupWithoutLast bool     `json:"backup_without_last"`
-	RemoveAfterBackup bool     `json:"remove_after_backup"`
-}
-
-func defaultConfig() *Config {
+	APIKey            string              `json:"apikey" secret:"true"`
+	Realms            RealmList           `json:"realms"`
+	RealmsList        []string            `json:"-"` // enabled realms, set by Load
+	LocalesList       []string            `json:"locales"`
+	LogDirectory      string              `json:"log_dir"`
+	DownloadDirectory string              `json:"download_dir"`
+	TempDirectory     string              `json:"temp_dir"`
+	ResultDirectory   string              `json:"result_dir"`
+	Storage           string              `json:"storage"`
+	BackupDirectory   string              `json:"backup_dir"`
+	BackupExt         string              `json:"backup_ext"`
+	BackupZstdLevel   int                 `json:"backup_zstd_level"`
+	BackupZstdDict    bool                `json:"backup_zstd_dict"`
+	DeltaDirectory    string              `json:"delta_dir"`
+	DeltaKeyframes    int                 `json:"delta_keyframe_every"`
+	NameFormat        string              `json:"name_format"`
+	TimedNameFormat   string              `json:"timed_name_format"`
+	BackupWithoutLast bool                `json:"backup_without_last"`
+	RemoveAfterBackup bool                `json:"remove_after_backup"`
+	TimeLeftIntervals map[string]Interval `json:"time_left_intervals"`
+	Retention         Retention           `json:"retention"`
+	Log               LogOptions          `json:"log"`
+}
+
+func DefaultTimeLeftIntervals() map[string]Interval {
+	return map[string]Interval{
+		"SHORT":     {"0s", "30m"},
+		"MEDIUM":    {"30m", "2h"},
+		"LONG":      {"2h", "12h"},
+		"VERY_LONG": {"12h", "48h"},
+	}
+}
+
+// config with default values, as written by "dfltcfg"
+func Default() *Config {
 	cf := new(Config)
 	cf.APIKey = ""
-	cf.RealmsList = []string{"eu:fordragon"}
+	cf.Realms = RealmList{{Name: "eu:fordragon"}}
+	cf.RealmsList = cf.Realms.enabled()
 	cf.LocalesList = []string{"en_US", "ru_RU"}
 	cf.LogDirectory = "data/log"
 	cf.DownloadDirectory = "data/download"
 	cf.TempDirectory = "data/tmp"
 	cf.ResultDirectory = "data/result"
+	cf.Storage = STORAGE_JSONL
 	cf.BackupDirectory = "data/backup"
 	cf.BackupExt = ".zip"
+	cf.BackupZstdLevel = 3 // used with ".tar.zst" only
+	cf.BackupZstdDict = false
+	cf.DeltaDirectory = "data/delta"
+	cf.DeltaKeyframes = 48 // every 48th snapshot is stored in full
 	cf.NameFormat = "{realm}-{name}"
 	cf.TimedNameFormat = "2006_01-{realm}-{name}" // split by month
 	cf.BackupWithoutLast = false
 	cf.RemoveAfterBackup = false
+	cf.TimeLeftIntervals = DefaultTimeLeftIntervals()
+	cf.Retention = Retention{} // nothing expires
+	cf.Log = LogOptions{Level: "info", Format: logging.FORMAT_LOGFMT}
 	return cf
 }
 
+// log config, secrets are redacted
 func (cf *Config) Dump() {
-	log.Println("APIKey: ", cf.APIKey)
+	log.Println("APIKey: ", cf.Redacted().APIKey)
 	log.Println("RealmsList: ", cf.RealmsList)
+	for i := range cf.Realms {
+		if r := &cf.Realms[i]; !r.plain() {
+			log.Printf("Realm %s: %+v", r.Name, *cf.Realm(r.Name))
+		}
+	}
 	log.Println("LocalesList: ", cf.LocalesList)
 	log.Println("LogDirectory: ", cf.LogDirectory)
 	log.Println("DownloadDirectory: ", cf.DownloadDirectory)
 	log.Println("TempDirectory: ", cf.TempDirectory)
 	log.Println("ResultDirectory: ", cf.ResultDirectory)
+	log.Println("Storage: ", cf.Storage)
 	log.Println("BackupDirectory: ", cf.BackupDirectory)
+	log.Println("BackupExt: ", cf.BackupExt)
+	log.Println("BackupZstdLevel: ", cf.BackupZstdLevel)
+	log.Println("BackupZstdDict: ", cf.BackupZstdDict)
+	log.Println("DeltaDirectory: ", cf.DeltaDirectory)
+	log.Println("DeltaKeyframes: ", cf.DeltaKeyframes)
 	log.Println("NameFormat:", cf.NameFormat)
 	log.Println("TimedNameFormat:", cf.TimedNameFormat)
 	log.Println("BackupWithoutLast: ", cf.BackupWithoutLast)
 	log.Println("RemoveAfterBackup: ", cf.RemoveAfterBackup)
+	log.Println("TimeLeftIntervals: ", cf.TimeLeftIntervals)
+	log.Printf("Retention: %+v", cf.Retention)
+	log.Printf("Log: %+v", cf.Log)
 }
 
 func (cf *Config) GetTimedName(name string, realm string, ts time.Time) string {
@@ -70,6 +154,15 @@ func (cf *Config) GetTimedName(name string, realm string, ts time.Time) string {
 	return s
 }
 
+// glob mask for all timed names (every digit of time part -> '?')
+func (cf *Config) GetTimedMask(name string, realm string) string {
+	s := regexp.MustCompile("\\d").ReplaceAllString(
+		time.Time{}.Format(cf.TimedNameFormat), "?")
+	s = strings.Replace(s, "{realm}", util.Safe_Realm(realm), -1)
+	s = strings.Replace(s, "{name}", name, -1)
+	return s
+}
+
 func (cf *Config) GetName(name string, realm string) string {
 	s := strings.Replace(cf.NameFormat, "{realm}", util.Safe_Realm(realm), -1)
 	s = strings.Replace(s, "{name}", name, -1)
@@ -105,25 +198,38 @@ func (cf *Config) GetLogFName(daily bool) string {
 	return cf.LogDirectory + string(SLASH) + name + ".log"
 }
 
+// lock file of long commands, shared by all processes using this config
+func (cf *Config) GetLockFName() string {
+	return cf.DownloadDirectory + LOCK_NAME
+}
+
 func (cf *Config) Save(fname string) error {
 	data, err := json.MarshalIndent(cf, "", "    ")
 	if err != nil {
-		log.Fatalf("json failed: %s", err)
+		return fmt.Errorf("json failed: %w", err)
 	}
 	return util.Store(fname, data)
 }
 
 func Load(fname string) (*Config, error) {
-	dflt := defaultConfig()
+	dflt := Default()
 	cf := new(Config)
 	data, err := ioutil.ReadFile(fname)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", ErrConfigRead, err)
 	}
-	err = json.Unmarshal(data, cf)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields() // typos in keys would be silently ignored
+	if err := dec.Decode(cf); err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrConfigParse, fname, err)
+	}
+	applied, err := cf.ApplyEnv()
 	if err != nil {
 		return nil, err
 	}
+	for _, key := range applied {
+		logging.Infof("%s overridden by environment", key)
+	}
 	basedir, err := filepath.Abs(filepath.Dir(fname))
 	if err != nil {
 		return nil, err
@@ -134,6 +240,25 @@ func Load(fname string) (*Config, error) {
 	cf.TempDirectory = fixD(cf.TempDirectory, dflt.TempDirectory, basedir)
 	cf.ResultDirectory = fixD(cf.ResultDirectory, dflt.ResultDirectory, basedir)
 	cf.BackupDirectory = fixD(cf.BackupDirectory, dflt.BackupDirectory, basedir)
+	cf.DeltaDirectory = fixD(cf.DeltaDirectory, dflt.DeltaDirectory, basedir)
+	for i := range cf.Realms {
+		if r := &cf.Realms[i]; r.ResultDirectory != "" {
+			r.ResultDirectory = fixD(r.ResultDirectory, "", basedir)
+		}
+	}
+	cf.RealmsList = cf.Realms.enabled()
+	if cf.DeltaKeyframes == 0 {
+		cf.DeltaKeyframes = dflt.DeltaKeyframes
+	}
+	if cf.Log.Level == "" {
+		cf.Log.Level = dflt.Log.Level
+	}
+	if cf.Log.Format == "" {
+		cf.Log.Format = dflt.Log.Format
+	}
+	if cf.Storage == "" {
+		cf.Storage = dflt.Storage
+	}
 	if cf.BackupExt == "" {
 		cf.BackupExt = dflt.BackupExt
 	}
@@ -143,22 +268,35 @@ func Load(fname string) (*Config, error) {
 	if cf.TimedNameFormat == "" {
 		cf.TimedNameFormat = dflt.TimedNameFormat
 	}
+	if cf.TimeLeftIntervals == nil {
+		cf.TimeLeftIntervals = dflt.TimeLeftIntervals
+	} else {
+		for name, iv := range dflt.TimeLeftIntervals {
+			if _, ok := cf.TimeLeftIntervals[name]; !ok {
+				cf.TimeLeftIntervals[name] = iv
+			}
+		}
+	}
 
-	cf.Dump()
+	if err := cf.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
 	return cf, nil
 }
 
-func ConfigName() string {
-	return util.AppBaseFileName() + ".config.json"
+func ConfigName() (string, error) {
+	base, err := util.AppBaseFileName()
+	if err != nil {
+		return "", err
+	}
+	return base + ".config.json", nil
 }
 
 func AppConfig() (*Config, error) {
-	cfg_fname := ConfigName()
-	log.Println("config    : ", cfg_fname)
-	cf, err := Load(cfg_fname)
+	cfg_fname, err := ConfigName()
 	if err != nil {
-		log.Fatalln("config load error: ", err)
-		return nil, err // unreachable
+		return nil, err
 	}
-	return cf, nil
+	log.Println("config    : ", cfg_fname)
+	return Load(cfg_fname)
 }
@@ -0,0 +1,144 @@
+package config
+
+// Every field of config may be overridden by environment variable
+// GOWOWUCTION_<json key in upper case>, keys of nested objects are
+// joined by "_" (GOWOWUCTION_RETENTION_BACKUPS). Lists are comma
+// separated, maps are given as json. Secret fields may be read from
+// file named by GOWOWUCTION_<KEY>_FILE as well, so they stay out of
+// the config file and of the process environment.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	ENV_PREFIX = "GOWOWUCTION_"
+	REDACTED   = "********"
+)
+
+var ErrBadEnv = errors.New("bad environment override")
+
+func envKey(prefix string, f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return ""
+	}
+	return prefix + strings.ToUpper(name)
+}
+
+func isSecret(f reflect.StructField) bool {
+	return f.Tag.Get("secret") == "true"
+}
+
+// values with own parser, like RealmList
+type setter interface {
+	Set(s string) error
+}
+
+func setField(v reflect.Value, s string) error {
+	if st, ok := v.Addr().Interface().(setter); ok {
+		return st.Set(s)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m := reflect.New(v.Type())
+		if err := json.Unmarshal([]byte(s), m.Interface()); err != nil {
+			return err
+		}
+		v.Set(m.Elem())
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func applyEnv(v reflect.Value, prefix string, applied *[]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := envKey(prefix, f)
+		if key == "" {
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct {
+			if err := applyEnv(v.Field(i), key+"_", applied); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := os.LookupEnv(key)
+		if !ok && isSecret(f) {
+			if fname, found := os.LookupEnv(key + "_FILE"); found {
+				data, err := ioutil.ReadFile(fname)
+				if err != nil {
+					return fmt.Errorf("%w: %s_FILE: %s", ErrBadEnv, key, err)
+				}
+				s, ok, key = strings.TrimSpace(string(data)), true, key+"_FILE"
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), s); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrBadEnv, key, err)
+		}
+		*applied = append(*applied, key)
+	}
+	return nil
+}
+
+// ApplyEnv overrides fields of config by environment variables and
+// returns names of the variables used.
+func (cf *Config) ApplyEnv() (applied []string, err error) {
+	err = applyEnv(reflect.ValueOf(cf).Elem(), ENV_PREFIX, &applied)
+	return applied, err
+}
+
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			redact(v.Field(i))
+		case isSecret(f) && f.Type.Kind() == reflect.String && v.Field(i).String() != "":
+			v.Field(i).SetString(REDACTED)
+		}
+	}
+}
+
+// copy of config with secrets hidden, for logs and output
+func (cf *Config) Redacted() *Config {
+	c := *cf
+	redact(reflect.ValueOf(&c).Elem())
+	return &c
+}
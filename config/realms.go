@@ -0,0 +1,160 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Realm block of config. Empty fields take the global value, so a realm
+// given by plain name string (the old "realms" format) uses only global
+// settings.
+type Realm struct {
+	Name              string              `json:"name"`
+	Enabled           *bool               `json:"enabled,omitempty"`             // true by default
+	Locales           []string            `json:"locales,omitempty"`             // global locales by default
+	FetchInterval     string              `json:"fetch_interval,omitempty"`      // like "1h", fetched on every run by default
+	ResultDirectory   string              `json:"result_dir,omitempty"`          // global result_dir by default
+	BackupExt         string              `json:"backup_ext,omitempty"`          // global backup_ext by default
+	BackupWithoutLast *bool               `json:"backup_without_last,omitempty"` // global value by default
+	RemoveAfterBackup *bool               `json:"remove_after_backup,omitempty"` // global value by default
+	TimeLeftIntervals map[string]Interval `json:"time_left_intervals,omitempty"` // merged over global ones
+}
+
+func (r *Realm) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// only name is set, so realm is written as string
+func (r *Realm) plain() bool {
+	return r.Enabled == nil && r.Locales == nil && r.FetchInterval == "" &&
+		r.ResultDirectory == "" && r.BackupExt == "" && r.BackupWithoutLast == nil &&
+		r.RemoveAfterBackup == nil && r.TimeLeftIntervals == nil
+}
+
+// realms of config, every item is a realm name or a realm block
+type RealmList []Realm
+
+func (l *RealmList) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	list := make(RealmList, len(items))
+	for i, item := range items {
+		if err := json.Unmarshal(item, &list[i].Name); err == nil {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&list[i]); err != nil {
+			return fmt.Errorf("realms[%d]: %w", i, err)
+		}
+	}
+	*l = list
+	return nil
+}
+
+func (l RealmList) MarshalJSON() ([]byte, error) {
+	items := make([]interface{}, len(l))
+	for i := range l {
+		if l[i].plain() {
+			items[i] = l[i].Name
+		} else {
+			items[i] = &l[i]
+		}
+	}
+	return json.Marshal(items)
+}
+
+// comma separated realm names, used by environment override
+func (l *RealmList) Set(s string) error {
+	var list RealmList
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			list = append(list, Realm{Name: name})
+		}
+	}
+	*l = list
+	return nil
+}
+
+func (l RealmList) find(name string) *Realm {
+	for i := range l {
+		if l[i].Name == name {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+// effective settings of realm
+type RealmSettings struct {
+	Name              string
+	Locales           []string
+	FetchInterval     time.Duration
+	ResultDirectory   string
+	BackupExt         string
+	BackupWithoutLast bool
+	RemoveAfterBackup bool
+	TimeLeftIntervals map[string]Interval
+}
+
+// Settings of realm with global values for fields not given in its
+// block. Realms not in config get global settings.
+func (cf *Config) Realm(name string) *RealmSettings {
+	rs := &RealmSettings{
+		Name:              name,
+		Locales:           cf.LocalesList,
+		ResultDirectory:   cf.ResultDirectory,
+		BackupExt:         cf.BackupExt,
+		BackupWithoutLast: cf.BackupWithoutLast,
+		RemoveAfterBackup: cf.RemoveAfterBackup,
+		TimeLeftIntervals: cf.TimeLeftIntervals,
+	}
+	r := cf.Realms.find(name)
+	if r == nil {
+		return rs
+	}
+	if r.Locales != nil {
+		rs.Locales = r.Locales
+	}
+	if r.FetchInterval != "" {
+		rs.FetchInterval, _ = time.ParseDuration(r.FetchInterval) // checked by Validate
+	}
+	if r.ResultDirectory != "" {
+		rs.ResultDirectory = r.ResultDirectory
+	}
+	if r.BackupExt != "" {
+		rs.BackupExt = r.BackupExt
+	}
+	if r.BackupWithoutLast != nil {
+		rs.BackupWithoutLast = *r.BackupWithoutLast
+	}
+	if r.RemoveAfterBackup != nil {
+		rs.RemoveAfterBackup = *r.RemoveAfterBackup
+	}
+	if r.TimeLeftIntervals != nil {
+		rs.TimeLeftIntervals = make(map[string]Interval)
+		for name, iv := range cf.TimeLeftIntervals {
+			rs.TimeLeftIntervals[name] = iv
+		}
+		for name, iv := range r.TimeLeftIntervals {
+			rs.TimeLeftIntervals[name] = iv
+		}
+	}
+	return rs
+}
+
+// names of enabled realms, kept in RealmsList
+func (l RealmList) enabled() []string {
+	var names []string
+	for i := range l {
+		if l[i].IsEnabled() {
+			names = append(names, l[i].Name)
+		}
+	}
+	return names
+}
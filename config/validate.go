@@ -0,0 +1,209 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+var ErrConfigInvalid = errors.New("config is invalid")
+
+const (
+	ZSTD_MIN_LEVEL = 1
+	ZSTD_MAX_LEVEL = 22
+)
+
+var (
+	Regions  = []string{"eu", "us", "kr", "tw", "cn"}
+	rxSlug   = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
+	rxLocale = regexp.MustCompile("^[a-z]{2}_[A-Z]{2}$")
+)
+
+// all problems found by Validate
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrConfigInvalid, strings.Join(e.Problems, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrConfigInvalid
+}
+
+// realm as "region:slug", e.g. "eu:twisting-nether"
+func CheckRealm(realm string) error {
+	v := strings.Split(realm, ":")
+	if len(v) != 2 {
+		return fmt.Errorf("realm %#v is not in region:slug format", realm)
+	}
+	known := false
+	for _, region := range Regions {
+		known = known || v[0] == region
+	}
+	if !known {
+		return fmt.Errorf("realm %#v: region must be one of %v", realm, Regions)
+	}
+	if !rxSlug.MatchString(v[1]) {
+		return fmt.Errorf("realm %#v: slug must be lowercase letters, digits and dashes", realm)
+	}
+	return nil
+}
+
+type addFunc func(format string, args ...interface{})
+
+func checkLocales(add addFunc, key string, locales []string) {
+	if len(locales) == 0 {
+		add("%s: no locales", key)
+	}
+	for _, locale := range locales {
+		if !rxLocale.MatchString(locale) {
+			add("%s: %#v is not like \"en_US\"", key, locale)
+		}
+	}
+}
+
+func checkDir(add addFunc, key, dir string) {
+	if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+		add("%s: %s is not a directory", key, dir)
+	}
+}
+
+func checkExt(add addFunc, key, ext string) {
+	for _, known := range archive.Exts {
+		if ext == known {
+			return
+		}
+	}
+	add("%s: %#v must be one of %v", key, ext, archive.Exts)
+}
+
+func checkIntervals(add addFunc, key string, intervals map[string]Interval) {
+	for name, iv := range intervals {
+		min, err1 := time.ParseDuration(iv.Min)
+		max, err2 := time.ParseDuration(iv.Max)
+		switch {
+		case err1 != nil:
+			add("%s: %s: %s", key, name, err1)
+		case err2 != nil:
+			add("%s: %s: %s", key, name, err2)
+		case min > max:
+			add("%s: %s: min %s is above max %s", key, name, iv.Min, iv.Max)
+		}
+	}
+}
+
+// Validate reports every problem of config at once. It is done by Load
+// after defaults and environment overrides are applied.
+func (cf *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(cf.RealmsList) == 0 {
+		add("realms: no enabled realms")
+	}
+	seen := make(map[string]bool)
+	for i := range cf.Realms {
+		r := &cf.Realms[i]
+		if err := CheckRealm(r.Name); err != nil {
+			add("realms: %s", err)
+		} else if seen[r.Name] {
+			add("realms: %#v is listed twice", r.Name)
+		}
+		seen[r.Name] = true
+		key := "realms." + r.Name
+		if r.Locales != nil {
+			checkLocales(add, key+".locales", r.Locales)
+		}
+		if r.FetchInterval != "" {
+			if d, err := time.ParseDuration(r.FetchInterval); err != nil || d < 0 {
+				add("%s.fetch_interval: %#v is not a duration like \"2h\"", key, r.FetchInterval)
+			}
+		}
+		if r.ResultDirectory != "" {
+			checkDir(add, key+".result_dir", r.ResultDirectory)
+		}
+		if r.BackupExt != "" {
+			checkExt(add, key+".backup_ext", r.BackupExt)
+		}
+		checkIntervals(add, key+".time_left_intervals", r.TimeLeftIntervals)
+	}
+	checkLocales(add, "locales", cf.LocalesList)
+
+	dirs := []struct{ key, dir string }{
+		{"log_dir", cf.LogDirectory},
+		{"download_dir", cf.DownloadDirectory},
+		{"temp_dir", cf.TempDirectory},
+		{"result_dir", cf.ResultDirectory},
+		{"backup_dir", cf.BackupDirectory},
+		{"delta_dir", cf.DeltaDirectory},
+	}
+	used := make(map[string]string)
+	for _, d := range dirs {
+		checkDir(add, d.key, d.dir)
+		// snapshot, archive and delta files are told apart by directory
+		if d.key == "log_dir" || d.key == "temp_dir" {
+			continue
+		}
+		if other, ok := used[d.dir]; ok {
+			add("%s: same directory as %s", d.key, other)
+		}
+		used[d.dir] = d.key
+	}
+
+	if cf.Storage != STORAGE_JSONL && cf.Storage != STORAGE_BOLT {
+		add("storage: %#v must be %s or %s", cf.Storage, STORAGE_JSONL, STORAGE_BOLT)
+	}
+	checkExt(add, "backup_ext", cf.BackupExt)
+	// zero means default level
+	if lvl := cf.BackupZstdLevel; lvl != 0 && (lvl < ZSTD_MIN_LEVEL || lvl > ZSTD_MAX_LEVEL) {
+		add("backup_zstd_level: %d is out of [%d, %d]", cf.BackupZstdLevel, ZSTD_MIN_LEVEL, ZSTD_MAX_LEVEL)
+	}
+	if cf.DeltaKeyframes < 1 {
+		add("delta_keyframe_every: %d must be positive", cf.DeltaKeyframes)
+	}
+	for key, format := range map[string]string{"name_format": cf.NameFormat, "timed_name_format": cf.TimedNameFormat} {
+		if !strings.Contains(format, "{realm}") || !strings.Contains(format, "{name}") {
+			add("%s: %#v must contain {realm} and {name}", key, format)
+		}
+	}
+
+	checkIntervals(add, "time_left_intervals", cf.TimeLeftIntervals)
+	for key, age := range map[string]string{
+		"downloads":  cf.Retention.Downloads,
+		"backups":    cf.Retention.Backups,
+		"results":    cf.Retention.Results,
+		"downsample": cf.Retention.Downsample,
+	} {
+		if _, err := util.ParseAge(age); err != nil {
+			add("retention.%s: %s", key, err)
+		}
+	}
+
+	if _, err := logging.ParseLevel(cf.Log.Level); err != nil {
+		add("log.level: %s", err)
+	}
+	if err := logging.CheckFormat(cf.Log.Format); err != nil {
+		add("log.format: %s", err)
+	}
+	if cf.Log.MaxSizeMB < 0 {
+		add("log.max_size_mb: %d must not be negative", cf.Log.MaxSizeMB)
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &ValidationError{problems}
+	}
+	return nil
+}
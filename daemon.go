@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+	metrics "github.com/wowauc/gowowuction/metrics"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const (
+	DAEMON_EVERY  = 30 * time.Minute
+	DAEMON_LISTEN = "127.0.0.1:9150"
+)
+
+var DAEMON_STAGES = []string{"fetch", "parse", "backup"}
+
+var (
+	stageRuns = metrics.NewCounter("gowowuction_stage_runs_total",
+		"Runs of daemon stages by result: ok or failed.", "stage", "result")
+	stageDuration = metrics.NewHistogram("gowowuction_stage_duration_seconds",
+		"Time of daemon stages.", metrics.DurationBuckets, "stage")
+	stageLastRun = metrics.NewGauge("gowowuction_stage_last_run_timestamp_seconds",
+		"Time of the last run of daemon stage by result.", "stage", "result")
+)
+
+// stages of daemon by name, with default flags
+func daemonSteps(names []string) ([]step, error) {
+	var steps []step
+	for _, name := range names {
+		cmd, rest := findCommand([]string{name})
+		if cmd == nil || len(rest) > 0 || !cmd.config || name == "daemon" {
+			return nil, fmt.Errorf("%w %#v for daemon", ErrUnknownCommand, name)
+		}
+		_, run := newFlagSet(cmd, new(options))
+		steps = append(steps, step{cmd, run})
+	}
+	return steps, nil
+}
+
+// Run stages every interval until SIGINT or SIGTERM, serving /metrics
+// at listen address (if not empty) meanwhile.
+func DoDaemon(cf *config.Config, lg *logging.Logger, every time.Duration, listen string, steps []step) error {
+	lg.Infof("=== DAEMON BEGIN ===")
+	if every <= 0 {
+		return fmt.Errorf("bad daemon interval %s", every)
+	}
+	if listen != "" {
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		srv := &http.Server{Handler: mux}
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				lg.Warnf("metrics server failed: %s", err)
+			}
+		}()
+		defer srv.Shutdown(context.Background())
+		lg.Infof("metrics served at http://%s/metrics", ln.Addr())
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	for {
+		started := time.Now()
+		for _, st := range steps {
+			slg := lg.With("stage", st.cmd.name)
+			t := time.Now()
+			err := st.exec(cf, slg)
+			stageDuration.Observe(time.Since(t).Seconds(), st.cmd.name)
+			result := "ok"
+			if err != nil {
+				slg.Errorf("%s failed: %s", st.cmd.name, err)
+				result = "failed"
+			}
+			stageRuns.Inc(st.cmd.name, result)
+			stageLastRun.Set(float64(time.Now().Unix()), st.cmd.name, result)
+		}
+		// next run is due every interval from start of previous one
+		next := started.Add(every)
+		lg.Infof("next run at %s", util.TSStr(next))
+		select {
+		case sig := <-stop:
+			lg.Infof("got %s, stopping", sig)
+			lg.Infof("=== DAEMON END ===")
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
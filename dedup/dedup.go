@@ -0,0 +1,188 @@
+package dedup
+
+// Content hashes of snapshots. The API sometimes gives the same data
+// under a new lastModified, such copies are recognized by the hash of
+// the normalized auction set.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	util "github.com/wowauc/gowowuction/util"
+)
+
+var ErrNoAuctions = errors.New("snapshot has no auctions list")
+
+type auction map[string]interface{}
+
+func (a auction) id() string {
+	if v, ok := a["auc"].(json.Number); ok {
+		return v.String()
+	}
+	return ""
+}
+
+// SHA-1 of snapshot with auctions sorted by id and fields in fixed
+// order, so formatting and auction order don't matter
+func Hash(data []byte) (string, error) {
+	var snapshot struct {
+		Realms   interface{} `json:"realms"`
+		Auctions []auction   `json:"auctions"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&snapshot); err != nil {
+		return "", err
+	}
+	if snapshot.Auctions == nil {
+		return "", ErrNoAuctions
+	}
+	sort.SliceStable(snapshot.Auctions, func(i, j int) bool {
+		a, b := snapshot.Auctions[i].id(), snapshot.Auctions[j].id()
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	norm, err := json.Marshal(snapshot) // map keys are sorted
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(norm)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hashes of all known snapshots of realm
+type Index struct {
+	fname     string
+	Snapshots map[string]string `json:"snapshots"` // time -> hash
+	first     map[string]string // hash -> earliest time
+	dirty     bool
+}
+
+func IndexName(dir, realm string) string {
+	return filepath.Join(dir, util.Safe_Realm(realm)+".hashes.json")
+}
+
+// load index of realm from dir, missing index is empty
+func Open(dir, realm string) (*Index, error) {
+	ix := &Index{fname: IndexName(dir, realm), Snapshots: make(map[string]string)}
+	data, err := ioutil.ReadFile(ix.fname)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, ix); err != nil {
+			return nil, fmt.Errorf("%s: %w", ix.fname, err)
+		}
+	}
+	ix.first = make(map[string]string)
+	for ts, hash := range ix.Snapshots {
+		if first, ok := ix.first[hash]; !ok || ts < first {
+			ix.first[hash] = ts
+		}
+	}
+	return ix, nil
+}
+
+// hash of snapshot taken at ts, if known
+func (ix *Index) Lookup(ts time.Time) (hash string, known bool) {
+	hash, known = ix.Snapshots[util.TSStr(ts.UTC())]
+	return
+}
+
+// Duplicate tells whether snapshot at ts is known as a copy of an
+// earlier one and returns the time of the original.
+func (ix *Index) Duplicate(ts time.Time) (orig time.Time, dup bool) {
+	hash, known := ix.Lookup(ts)
+	if !known {
+		return time.Time{}, false
+	}
+	return ix.original(ts, hash)
+}
+
+func (ix *Index) original(ts time.Time, hash string) (time.Time, bool) {
+	first := ix.first[hash]
+	if first == util.TSStr(ts.UTC()) {
+		return time.Time{}, false
+	}
+	orig, err := util.ParseTS(first)
+	return orig, err == nil
+}
+
+// Record hash of snapshot taken at ts. dup is true when the same
+// content is recorded for another (earlier) snapshot.
+func (ix *Index) Add(ts time.Time, hash string) (orig time.Time, dup bool) {
+	key := util.TSStr(ts.UTC())
+	if old, ok := ix.Snapshots[key]; !ok || old != hash {
+		ix.Snapshots[key] = hash
+		ix.dirty = true
+	}
+	if first, ok := ix.first[hash]; !ok || key < first {
+		ix.first[hash] = key
+	}
+	return ix.original(ts, hash)
+}
+
+// hash data and record it, see Add
+func (ix *Index) Check(ts time.Time, data []byte) (orig time.Time, dup bool, err error) {
+	if hash, known := ix.Lookup(ts); known {
+		orig, dup = ix.original(ts, hash)
+		return orig, dup, nil
+	}
+	hash, err := Hash(data)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	orig, dup = ix.Add(ts, hash)
+	return orig, dup, nil
+}
+
+// hash snapshot files which are not in index yet
+func (ix *Index) AddFiles(fnames []string) error {
+	for _, fname := range fnames {
+		_, ts, good := util.Parse_FName(fname)
+		if !good {
+			continue
+		}
+		if _, known := ix.Lookup(ts); known {
+			continue
+		}
+		data, err := util.Load(fname)
+		if err != nil {
+			return err
+		}
+		if _, _, err := ix.Check(ts, data); err != nil {
+			return fmt.Errorf("%s: %w", fname, err)
+		}
+	}
+	return nil
+}
+
+func (ix *Index) Save() error {
+	if !ix.dirty {
+		return nil
+	}
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	if err := util.Store(ix.fname+".tmp", data); err != nil {
+		return err
+	}
+	if err := util.Rotate(ix.fname); err != nil {
+		return err
+	}
+	ix.dirty = false
+	return nil
+}
@@ -0,0 +1,155 @@
+package dedup
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const testBase = `{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[{"auc":9,"item":1,"bid":100},{"auc":10,"item":2,"bid":200}]}`
+
+func TestHash(t *testing.T) {
+	want, err := Hash([]byte(testBase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		data string
+		same bool
+	}{
+		{"auction order",
+			`{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[{"auc":10,"item":2,"bid":200},{"auc":9,"item":1,"bid":100}]}`, true},
+		{"field order and spacing",
+			`{ "auctions": [ {"bid":100, "item":1, "auc":9}, {"item":2, "auc":10, "bid":200} ],
+			   "realms": [ {"slug":"fordragon", "name":"Fordragon"} ] }`, true},
+		{"fields out of snapshot",
+			`{"realms":[{"name":"Fordragon","slug":"fordragon"}],"files":"x","auctions":[{"auc":9,"item":1,"bid":100},{"auc":10,"item":2,"bid":200}]}`, true},
+		{"changed bid",
+			`{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[{"auc":9,"item":1,"bid":150},{"auc":10,"item":2,"bid":200}]}`, false},
+		{"removed auction",
+			`{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[{"auc":10,"item":2,"bid":200}]}`, false},
+		{"other realm",
+			`{"realms":[{"name":"Silvermoon","slug":"silvermoon"}],"auctions":[{"auc":9,"item":1,"bid":100},{"auc":10,"item":2,"bid":200}]}`, false},
+	}
+	for _, c := range cases {
+		hash, err := Hash([]byte(c.data))
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if (hash == want) != c.same {
+			t.Errorf("%s: same hash %v, want %v", c.name, hash == want, c.same)
+		}
+	}
+}
+
+func TestHashBad(t *testing.T) {
+	cases := []struct {
+		data string
+		want error
+	}{
+		{`{"realms":[]}`, ErrNoAuctions},
+		{`{"realms":[],"auctions":null}`, ErrNoAuctions},
+	}
+	for _, c := range cases {
+		if _, err := Hash([]byte(c.data)); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.data, err, c.want)
+		}
+	}
+	if _, err := Hash([]byte(`{"auctions":`)); err == nil {
+		t.Error("broken json hashed")
+	}
+}
+
+func TestIndexPersistence(t *testing.T) {
+	dir := t.TempDir()
+	realm := "eu:fordragon"
+	ts := func(h int) time.Time { return time.Date(2016, 2, 10, h, 0, 0, 0, time.UTC) }
+
+	ix, err := Open(dir, realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// added out of order: the earliest snapshot is the original
+	for _, a := range []struct {
+		hour int
+		hash string
+		dup  bool
+	}{
+		{13, "aaa", false},
+		{12, "aaa", false},
+		{14, "bbb", false},
+		{15, "aaa", true},
+	} {
+		if _, dup := ix.Add(ts(a.hour), a.hash); dup != a.dup {
+			t.Errorf("add %02d:00: dup %v, want %v", a.hour, dup, a.dup)
+		}
+	}
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(IndexName(dir, realm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err = Open(dir, realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		hour int
+		dup  bool
+		orig int
+	}{
+		{12, false, 0},
+		{13, true, 12},
+		{14, false, 0},
+		{15, true, 12},
+	} {
+		orig, dup := ix.Duplicate(ts(c.hour))
+		if dup != c.dup || (dup && !orig.Equal(ts(c.orig))) {
+			t.Errorf("%02d:00: dup %v of %s, want %v of %s", c.hour, dup, util.TSStr(orig), c.dup, util.TSStr(ts(c.orig)))
+		}
+	}
+	if _, dup := ix.Duplicate(ts(16)); dup {
+		t.Error("unknown snapshot is a duplicate")
+	}
+
+	// nothing changed, nothing written
+	ix.Add(ts(12), "aaa")
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := util.CheckFile(IndexName(dir, realm) + ".bak"); exists {
+		t.Error("unchanged index saved")
+	}
+	if fi2, err := os.Stat(IndexName(dir, realm)); err != nil || !fi2.ModTime().Equal(fi.ModTime()) {
+		t.Errorf("unchanged index rewritten: %v", err)
+	}
+}
+
+func TestIndexCheck(t *testing.T) {
+	ix, err := Open(t.TempDir(), "eu:fordragon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts1 := time.Date(2016, 2, 10, 12, 0, 0, 0, time.UTC)
+	ts2 := ts1.Add(time.Hour)
+	if _, dup, err := ix.Check(ts1, []byte(testBase)); err != nil || dup {
+		t.Fatalf("first: dup %v, %v", dup, err)
+	}
+	reordered := `{"auctions":[{"auc":10,"item":2,"bid":200},{"auc":9,"item":1,"bid":100}],"realms":[{"name":"Fordragon","slug":"fordragon"}]}`
+	orig, dup, err := ix.Check(ts2, []byte(reordered))
+	if err != nil || !dup || !orig.Equal(ts1) {
+		t.Errorf("second: dup %v of %s, %v", dup, orig, err)
+	}
+	// known snapshot is not hashed again
+	if _, _, err := ix.Check(ts2, []byte("not json")); err != nil {
+		t.Errorf("known snapshot rehashed: %s", err)
+	}
+}
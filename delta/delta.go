@@ -0,0 +1,191 @@
+package delta
+
+// Delta encoding of consecutive snapshots of one realm.
+// Auctions are compared by their "auc" id as raw json, so fields
+// unknown to the parser survive the round trip.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	parser "github.com/wowauc/gowowuction/parser"
+)
+
+var (
+	ErrDupAuc    = errors.New("duplicate auction id")
+	ErrNoAuc     = errors.New("auction without id")
+	ErrBadDelta  = errors.New("delta does not match its base")
+	ErrRoundTrip = errors.New("rebuilt snapshot differs from original")
+)
+
+// snapshot split into auctions by id
+type doc struct {
+	header map[string]json.RawMessage // everything but auctions
+	ids    []int64
+	aucs   map[int64]json.RawMessage
+}
+
+type aucId struct {
+	Auc *int64 `json:"auc"`
+}
+
+func auctionId(raw json.RawMessage) (int64, error) {
+	var v aucId
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0, err
+	}
+	if v.Auc == nil {
+		return 0, ErrNoAuc
+	}
+	return *v.Auc, nil
+}
+
+func parseDoc(data []byte) (*doc, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(top["auctions"], &raws); err != nil {
+		return nil, err
+	}
+	delete(top, "auctions")
+	d := &doc{header: top, aucs: make(map[int64]json.RawMessage, len(raws))}
+	for _, raw := range raws {
+		id, err := auctionId(raw)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := d.aucs[id]; ok {
+			return nil, fmt.Errorf("%w: %d", ErrDupAuc, id)
+		}
+		d.ids = append(d.ids, id)
+		d.aucs[id] = raw
+	}
+	return d, nil
+}
+
+func (d *doc) bytes() ([]byte, error) {
+	top := make(map[string]interface{}, len(d.header)+1)
+	for k, v := range d.header {
+		top[k] = v
+	}
+	raws := make([]json.RawMessage, 0, len(d.ids))
+	for _, id := range d.ids {
+		raws = append(raws, d.aucs[id])
+	}
+	top["auctions"] = raws
+	return json.Marshal(top)
+}
+
+// Changes between two consecutive snapshots. Without Order the
+// auctions of the next snapshot go in the base order with removed
+// ones dropped and added ones appended.
+type Delta struct {
+	Base    string                     `json:"base"` // time of base snapshot
+	Header  map[string]json.RawMessage `json:"header"`
+	Removed []int64                    `json:"removed,omitempty"`
+	Added   []json.RawMessage          `json:"added,omitempty"`
+	Changed []json.RawMessage          `json:"changed,omitempty"`
+	Order   []int64                    `json:"order,omitempty"`
+}
+
+func makeDelta(base, next *doc, basets string) *Delta {
+	dl := &Delta{Base: basets, Header: next.header}
+	var order []int64
+	for _, id := range base.ids {
+		raw, ok := next.aucs[id]
+		if !ok {
+			dl.Removed = append(dl.Removed, id)
+			continue
+		}
+		order = append(order, id)
+		if string(raw) != string(base.aucs[id]) {
+			dl.Changed = append(dl.Changed, raw)
+		}
+	}
+	for _, id := range next.ids {
+		if _, ok := base.aucs[id]; !ok {
+			dl.Added = append(dl.Added, next.aucs[id])
+			order = append(order, id)
+		}
+	}
+	if !reflect.DeepEqual(order, next.ids) {
+		dl.Order = next.ids
+	}
+	return dl
+}
+
+func (d *doc) apply(dl *Delta) (*doc, error) {
+	next := &doc{header: dl.Header, aucs: make(map[int64]json.RawMessage, len(d.aucs))}
+	for id, raw := range d.aucs {
+		next.aucs[id] = raw
+	}
+	removed := make(map[int64]bool, len(dl.Removed))
+	for _, id := range dl.Removed {
+		if _, ok := next.aucs[id]; !ok {
+			return nil, fmt.Errorf("%w: removed %d is absent", ErrBadDelta, id)
+		}
+		removed[id] = true
+		delete(next.aucs, id)
+	}
+	for _, raw := range dl.Changed {
+		id, err := auctionId(raw)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := next.aucs[id]; !ok {
+			return nil, fmt.Errorf("%w: changed %d is absent", ErrBadDelta, id)
+		}
+		next.aucs[id] = raw
+	}
+	var added []int64
+	for _, raw := range dl.Added {
+		id, err := auctionId(raw)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := next.aucs[id]; ok {
+			return nil, fmt.Errorf("%w: added %d is present", ErrBadDelta, id)
+		}
+		next.aucs[id] = raw
+		added = append(added, id)
+	}
+	if dl.Order != nil {
+		if len(dl.Order) != len(next.aucs) {
+			return nil, fmt.Errorf("%w: order of %d auctions for %d", ErrBadDelta, len(dl.Order), len(next.aucs))
+		}
+		for _, id := range dl.Order {
+			if _, ok := next.aucs[id]; !ok {
+				return nil, fmt.Errorf("%w: ordered %d is absent", ErrBadDelta, id)
+			}
+		}
+		next.ids = dl.Order
+		return next, nil
+	}
+	for _, id := range d.ids {
+		if !removed[id] {
+			next.ids = append(next.ids, id)
+		}
+	}
+	next.ids = append(next.ids, added...)
+	return next, nil
+}
+
+// compare snapshots as the parser sees them
+func sameSnapshot(a, b []byte) error {
+	sa, err := parser.ParseSnapshot(a)
+	if err != nil {
+		return err
+	}
+	sb, err := parser.ParseSnapshot(b)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRoundTrip, err)
+	}
+	if !reflect.DeepEqual(sa, sb) {
+		return ErrRoundTrip
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package delta
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	parser "github.com/wowauc/gowowuction/parser"
+)
+
+const testRealm = "eu:fordragon"
+
+var testStart = time.Date(2016, 2, 10, 12, 0, 0, 0, time.UTC)
+
+// snapshot with auctions given as "auc:bid", unknown field x survives too
+func testSnapshot(aucs ...string) []byte {
+	var parts []string
+	for _, a := range aucs {
+		var id, bid int64
+		fmt.Sscanf(a, "%d:%d", &id, &bid)
+		parts = append(parts, fmt.Sprintf(
+			`{"auc":%d,"item":%d,"owner":"Owner%d","ownerRealm":"Fordragon","bid":%d,"buyout":%d,"quantity":1,"timeLeft":"LONG","rand":0,"seed":0,"context":0,"x":"keep"}`,
+			id, 1000+id, id, bid, 2*bid))
+	}
+	return []byte(`{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[` + strings.Join(parts, ",") + `]}`)
+}
+
+// keyframe, changed bid, changed order, added auction, removed auction
+var testSequence = [][]byte{
+	testSnapshot("1:100", "2:200", "3:300"),
+	testSnapshot("1:100", "2:250", "3:300"),
+	testSnapshot("3:300", "1:100", "2:250"),
+	testSnapshot("3:300", "1:100", "2:250", "4:400"),
+	testSnapshot("3:300", "2:250", "4:400"),
+}
+
+func testTime(i int) time.Time {
+	return testStart.Add(time.Duration(i) * time.Hour)
+}
+
+func putSequence(t *testing.T, w *Writer, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		key, err := w.Put(testTime(i), testSequence[i])
+		if err != nil {
+			t.Fatalf("put %d: %s", i, err)
+		}
+		if key != (i == 0) {
+			t.Errorf("put %d: keyframe %v", i, key)
+		}
+	}
+}
+
+func checkRebuild(t *testing.T, dir string) {
+	t.Helper()
+	n := 0
+	err := Rebuild(dir, testRealm, time.Time{}, time.Time{}, func(frame *Frame, data []byte) error {
+		if !frame.Time.Equal(testTime(n)) {
+			return fmt.Errorf("frame %d at %s", n, frame.Time)
+		}
+		want, err := parser.ParseSnapshot(testSequence[n])
+		if err != nil {
+			return err
+		}
+		got, err := parser.ParseSnapshot(data)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", n, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("frame %d rebuilt as %s", n, data)
+		}
+		if !strings.Contains(string(data), `"x":"keep"`) {
+			t.Errorf("frame %d lost unknown field: %s", n, data)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(testSequence) {
+		t.Errorf("rebuilt %d frames, want %d", n, len(testSequence))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWriter(dir, testRealm, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putSequence(t, w, 0, len(testSequence))
+	checkRebuild(t, dir)
+}
+
+func TestOpenWriterResume(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWriter(dir, testRealm, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putSequence(t, w, 0, 3)
+
+	w, err = OpenWriter(dir, testRealm, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.LastTime().Equal(testTime(2)) {
+		t.Errorf("resumed at %s, want %s", w.LastTime(), testTime(2))
+	}
+	if _, err := w.Put(testTime(2), testSequence[2]); !errors.Is(err, ErrNotAfter) {
+		t.Errorf("put at last time: got %v, want %v", err, ErrNotAfter)
+	}
+	putSequence(t, w, 3, len(testSequence))
+	checkRebuild(t, dir)
+}
+
+func TestCorruptedStore(t *testing.T) {
+	cases := []struct {
+		name    string
+		removed int // frame removed from the store
+		want    error
+	}{
+		{"missing delta", 2, ErrBrokenRef},
+		{"missing keyframe", 0, ErrNoKey},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := OpenWriter(dir, testRealm, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			putSequence(t, w, 0, len(testSequence))
+			if err := os.Remove(FrameName(dir, testRealm, testTime(c.removed), c.removed == 0)); err != nil {
+				t.Fatal(err)
+			}
+			err = Rebuild(dir, testRealm, time.Time{}, time.Time{}, func(frame *Frame, data []byte) error { return nil })
+			if !errors.Is(err, c.want) {
+				t.Errorf("rebuild: got %v, want %v", err, c.want)
+			}
+			if _, err := OpenWriter(dir, testRealm, 10); !errors.Is(err, c.want) {
+				t.Errorf("open writer: got %v, want %v", err, c.want)
+			}
+		})
+	}
+}
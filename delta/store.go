@@ -0,0 +1,243 @@
+package delta
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// Every snapshot of realm is stored as a frame: either the original
+// snapshot (keyframe) or the delta against the previous frame.
+const (
+	KEY_EXT   = ".key.json.gz"
+	DELTA_EXT = ".delta.json.gz"
+)
+
+var (
+	ErrNotAfter  = errors.New("snapshot is not after the last frame")
+	ErrNoKey     = errors.New("no keyframe before delta")
+	ErrBrokenRef = errors.New("delta refers to missing frame")
+)
+
+var rxFrameName = regexp.MustCompile("^([^._-]+)\\.([^_]+)_(\\d{8}_\\d{6})(\\.key\\.json\\.gz|\\.delta\\.json\\.gz)$")
+
+type Frame struct {
+	Realm string
+	Time  time.Time
+	FName string
+	Key   bool
+}
+
+func FrameName(dir, realm string, ts time.Time, key bool) string {
+	ext := DELTA_EXT
+	if key {
+		ext = KEY_EXT
+	}
+	return filepath.Join(dir, util.Safe_Realm(realm)+util.TS_SEP+util.TSStr(ts.UTC())+ext)
+}
+
+func parseFrameName(fname string) (frame Frame, good bool) {
+	v := rxFrameName.FindStringSubmatch(filepath.Base(fname))
+	if v == nil {
+		return frame, false
+	}
+	ts, err := time.Parse("20060102_150405", v[3])
+	if err != nil {
+		return frame, false
+	}
+	return Frame{v[1] + ":" + v[2], ts, fname, v[4] == KEY_EXT}, true
+}
+
+// frames of realm sorted by time
+func ListFrames(dir, realm string) ([]Frame, error) {
+	fnames, err := filepath.Glob(filepath.Join(dir, util.Safe_Realm(realm)+util.TS_SEP+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("glob failed: %w", err)
+	}
+	var frames []Frame
+	for _, fname := range fnames {
+		if frame, good := parseFrameName(fname); good && frame.Realm == realm {
+			frames = append(frames, frame)
+		}
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Time.Before(frames[j].Time) })
+	return frames, nil
+}
+
+// replay frames in order, prev is the document of the previous frame
+func loadFrame(frame *Frame, prev *doc, prevts time.Time) (*doc, []byte, error) {
+	data, err := util.Load(frame.FName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if frame.Key {
+		d, err := parseDoc(data)
+		return d, data, err
+	}
+	if prev == nil {
+		return nil, nil, fmt.Errorf("%s: %w", frame.FName, ErrNoKey)
+	}
+	dl := new(Delta)
+	if err := json.Unmarshal(data, dl); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", frame.FName, err)
+	}
+	if dl.Base != util.TSStr(prevts) {
+		return nil, nil, fmt.Errorf("%s: %w %s", frame.FName, ErrBrokenRef, dl.Base)
+	}
+	d, err := prev.apply(dl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", frame.FName, err)
+	}
+	data, err = d.bytes()
+	return d, data, err
+}
+
+// Rebuild snapshots of realm from dir in [from, to] (zero values mean
+// open range) and pass them to fn. Replay starts from the keyframe
+// preceding the range.
+func Rebuild(dir, realm string, from, to time.Time, fn func(frame *Frame, data []byte) error) error {
+	frames, err := ListFrames(dir, realm)
+	if err != nil {
+		return err
+	}
+	start := 0
+	for i, frame := range frames {
+		if from.IsZero() || !frame.Time.Before(from) {
+			break
+		}
+		if frame.Key {
+			start = i
+		}
+	}
+	var prev *doc
+	var prevts time.Time
+	for i := start; i < len(frames); i++ {
+		frame := &frames[i]
+		if !to.IsZero() && frame.Time.After(to) {
+			break
+		}
+		d, data, err := loadFrame(frame, prev, prevts)
+		if err != nil {
+			return err
+		}
+		prev, prevts = d, frame.Time
+		if !from.IsZero() && frame.Time.Before(from) {
+			continue
+		}
+		if err := fn(frame, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appends snapshots of one realm to the store
+type Writer struct {
+	dir      string
+	realm    string
+	every    int // keyframe interval in frames
+	last     *doc
+	lastTS   time.Time
+	sinceKey int
+	Log      *logging.Logger // records with realm, set by OpenWriter
+}
+
+// Open store of realm for appending. The last frame is rebuilt to be the
+// base of the next delta.
+func OpenWriter(dir, realm string, every int) (*Writer, error) {
+	if err := util.CheckDir(dir); err != nil {
+		return nil, err
+	}
+	w := &Writer{dir: dir, realm: realm, every: every, Log: logging.With("realm", realm)}
+	frames, err := ListFrames(dir, realm)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return w, nil
+	}
+	// replay from the last keyframe
+	start := len(frames) - 1
+	for start > 0 && !frames[start].Key {
+		start--
+	}
+	for i := start; i < len(frames); i++ {
+		d, _, err := loadFrame(&frames[i], w.last, w.lastTS)
+		if err != nil {
+			return nil, err
+		}
+		w.last, w.lastTS = d, frames[i].Time
+		w.sinceKey = i - start
+	}
+	return w, nil
+}
+
+func (w *Writer) LastTime() time.Time {
+	return w.lastTS
+}
+
+// Store snapshot taken at ts. It becomes a keyframe when the interval
+// is over or its delta doesn't rebuild the original snapshot.
+func (w *Writer) Put(ts time.Time, data []byte) (key bool, err error) {
+	if !w.lastTS.IsZero() && !ts.After(w.lastTS) {
+		return false, fmt.Errorf("%w: %s", ErrNotAfter, util.TSStr(ts))
+	}
+	d, err := parseDoc(data)
+	if err != nil {
+		return false, err
+	}
+	key = w.last == nil || w.every <= 0 || w.sinceKey+1 >= w.every
+	var blob []byte
+	if !key {
+		dl := makeDelta(w.last, d, util.TSStr(w.lastTS))
+		if blob, err = json.Marshal(dl); err == nil {
+			err = w.check(blob, data)
+		}
+		if err != nil {
+			w.Log.Warnf("delta of %s %s not usable, store keyframe: %s", w.realm, util.TSStr(ts), err)
+			key = true
+		}
+	}
+	if key {
+		blob = data
+	}
+	fname := FrameName(w.dir, w.realm, ts, key)
+	if err := util.Store(fname+".tmp", util.Zip(blob)); err != nil {
+		return false, err
+	}
+	if err := os.Rename(fname+".tmp", fname); err != nil {
+		return false, err
+	}
+	w.last, w.lastTS = d, ts
+	if key {
+		w.sinceKey = 0
+	} else {
+		w.sinceKey++
+	}
+	return key, nil
+}
+
+// round trip: stored delta must give the snapshot the parser sees in data
+func (w *Writer) check(blob []byte, data []byte) error {
+	dl := new(Delta)
+	if err := json.Unmarshal(blob, dl); err != nil {
+		return err
+	}
+	d, err := w.last.apply(dl)
+	if err != nil {
+		return err
+	}
+	rebuilt, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	return sameSnapshot(data, rebuilt)
+}
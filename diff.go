@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+	parser "github.com/wowauc/gowowuction/parser"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const (
+	DIFF_TEXT = "text"
+	DIFF_JSON = "json"
+)
+
+var (
+	ErrBadDiffOutput    = errors.New("bad diff output")
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrDiffRealm        = errors.New("realm of diff is ambiguous")
+	ErrDiffOrder        = errors.New("snapshots are not in order")
+)
+
+// refs of snapshots given as file names or as times of a realm
+// snapshot from downloads or backups
+func diffRefs(cf *config.Config, args []string) ([]parser.SnapshotRef, error) {
+	refs := make([]parser.SnapshotRef, len(args))
+	var realm string
+	var wanted []int // refs given by time
+	for i, arg := range args {
+		if ts, err := util.ParseTS(arg); err == nil {
+			refs[i].Time = ts
+			wanted = append(wanted, i)
+			continue
+		}
+		f_realm, ts, good := util.Parse_FName(filepath.Base(arg))
+		if !good {
+			return nil, fmt.Errorf("%w: %s is neither a time nor a snapshot file", parser.ErrIllNamed, arg)
+		}
+		if realm != "" && f_realm != realm {
+			return nil, fmt.Errorf("%w: %s and %s", ErrDiffRealm, realm, f_realm)
+		}
+		realm = f_realm
+		refs[i] = parser.SnapshotRef{Realm: realm, Time: ts, FName: arg}
+	}
+	if len(wanted) > 0 {
+		if realm == "" {
+			if len(cf.RealmsList) != 1 {
+				return nil, fmt.Errorf("%w, select one with --realm", ErrDiffRealm)
+			}
+			realm = cf.RealmsList[0]
+		}
+		badfiles := make(map[string]error)
+		found, err := parser.ListSnapshotRefs(cf, realm, refs[wanted[0]].Time, badfiles)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range wanted {
+			ts := refs[i].Time
+			refs[i].FName = ""
+			for _, ref := range found {
+				if ref.Time.Equal(ts) {
+					refs[i] = ref
+					break
+				}
+			}
+			if refs[i].FName == "" {
+				return nil, fmt.Errorf("%w: %s at %s", ErrSnapshotNotFound, realm, util.TSStr(ts))
+			}
+		}
+	}
+	for i := 1; i < len(refs); i++ {
+		if !refs[i-1].Time.Before(refs[i].Time) {
+			return nil, fmt.Errorf("%w: %s is not older than %s", ErrDiffOrder, &refs[i-1], &refs[i])
+		}
+	}
+	return refs, nil
+}
+
+// compare two snapshots given as file names or times of realm, output
+// goes to stdout
+func DoDiff(cf *config.Config, lg *logging.Logger, older, newer, output string) error {
+	lg.Infof("=== DIFF BEGIN ===")
+	if output != DIFF_TEXT && output != DIFF_JSON {
+		return fmt.Errorf("%w %#v, must be %s or %s", ErrBadDiffOutput, output, DIFF_TEXT, DIFF_JSON)
+	}
+	refs, err := diffRefs(cf, []string{older, newer})
+	if err != nil {
+		return err
+	}
+	snapshots := make([]*parser.SnapshotData, len(refs))
+	err = parser.EachSnapshot(refs, func(ref *parser.SnapshotRef, data []byte, err error) error {
+		if err != nil {
+			return fmt.Errorf("%s not loaded: %w", ref, err)
+		}
+		ss, err := parser.ParseSnapshot(data)
+		if err != nil {
+			return fmt.Errorf("%s not parsed: %w", ref, err)
+		}
+		for i := range refs {
+			if ref == &refs[i] {
+				snapshots[i] = ss
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, ss := range snapshots {
+		if ss == nil {
+			return fmt.Errorf("%w: %s", ErrSnapshotNotFound, &refs[i])
+		}
+	}
+	d, err := parser.DiffSnapshots(cf, refs[0].Realm, snapshots[0], snapshots[1], refs[0].Time, refs[1].Time)
+	if err != nil {
+		return err
+	}
+	if output == DIFF_JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	} else {
+		err = writeDiffText(os.Stdout, d)
+	}
+	if err != nil {
+		return err
+	}
+	lg.Infof("%d added, %d removed, %d changed", len(d.Added), len(d.Removed), len(d.Changed))
+	lg.Infof("=== DIFF END ===")
+	return nil
+}
+
+func auctionText(a *parser.Auction) string {
+	return fmt.Sprintf("%d item:%d owner:%s-%s bid:%d buyout:%d quantity:%d timeLeft:%s",
+		a.Auc, a.Item, a.Owner, a.OwnerRealm, a.Bid, a.Buyout, a.Quantity, a.TimeLeft)
+}
+
+func writeDiffText(w io.Writer, d *parser.SnapshotDiff) error {
+	fmt.Fprintf(w, "diff of %s %s -> %s\n", d.Realm, util.TSStr(d.Old), util.TSStr(d.New))
+	fmt.Fprintf(w, "summary %s\n", d.Summary)
+	fmt.Fprintf(w, "added %d:\n", len(d.Added))
+	for i := range d.Added {
+		fmt.Fprintf(w, "+ %s\n", auctionText(&d.Added[i]))
+	}
+	fmt.Fprintf(w, "removed %d:\n", len(d.Removed))
+	for _, c := range d.Removed {
+		fmt.Fprintf(w, "- %s => %s profit:%d\n", auctionText(&c.Entry), c.Meta.Result, c.Meta.Profit)
+	}
+	fmt.Fprintf(w, "changed %d:\n", len(d.Changed))
+	for _, c := range d.Changed {
+		fmt.Fprintf(w, "~ %d item:%d", c.Auc, c.Item)
+		for _, ch := range c.Changes {
+			fmt.Fprintf(w, " %s:%v->%v", ch.Field, ch.Old, ch.New)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+// Package export writes closed auctions (Auction joined with its
+// AuctionMeta) as flat CSV or Parquet tables, a file per realm and month.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+	parser "github.com/wowauc/gowowuction/parser"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const (
+	FORMAT_CSV     = "csv"
+	FORMAT_PARQUET = "parquet"
+)
+
+const (
+	KIND_INT = iota
+	KIND_STRING
+	KIND_TIME
+)
+
+var (
+	ErrBadFormat = errors.New("bad export format")
+	ErrBadColumn = errors.New("unknown column")
+)
+
+type Column struct {
+	Name string
+	Kind int
+	get  func(c *parser.ClosedAuction) interface{} // int64, string or time.Time
+}
+
+// Value of column for closed auction c of realm
+func (col *Column) Value(realm string, c *parser.ClosedAuction) interface{} {
+	if col.get == nil {
+		return realm
+	}
+	return col.get(c)
+}
+
+func bonusLists(bl parser.BonusList) string {
+	s := make([]string, len(bl))
+	for i, b := range bl {
+		s[i] = strconv.Itoa(int(b.BonusListId))
+	}
+	return strings.Join(s, ",")
+}
+
+func modifiers(ml parser.ModList) string {
+	s := make([]string, len(ml))
+	for i, m := range ml {
+		s[i] = fmt.Sprintf("%d:%d", m.Type, m.Value)
+	}
+	return strings.Join(s, ";")
+}
+
+// all columns in default order
+var Columns = []*Column{
+	{"realm", KIND_STRING, nil}, // filled by exporter
+	{"auc", KIND_INT, func(c *parser.ClosedAuction) interface{} { return c.Entry.Auc }},
+	{"item", KIND_INT, func(c *parser.ClosedAuction) interface{} { return c.Entry.Item }},
+	{"owner", KIND_STRING, func(c *parser.ClosedAuction) interface{} { return c.Entry.Owner }},
+	{"ownerRealm", KIND_STRING, func(c *parser.ClosedAuction) interface{} { return c.Entry.OwnerRealm }},
+	{"bid", KIND_INT, func(c *parser.ClosedAuction) interface{} { return c.Entry.Bid }},
+	{"buyout", KIND_INT, func(c *parser.ClosedAuction) interface{} { return c.Entry.Buyout }},
+	{"quantity", KIND_INT, func(c *parser.ClosedAuction) interface{} { return int64(c.Entry.Quantity) }},
+	{"timeLeft", KIND_STRING, func(c *parser.ClosedAuction) interface{} { return c.Entry.TimeLeft.String() }},
+	{"rand", KIND_INT, func(c *parser.ClosedAuction) interface{} { return c.Entry.Rand }},
+	{"seed", KIND_INT, func(c *parser.ClosedAuction) interface{} { return c.Entry.Seed }},
+	{"context", KIND_INT, func(c *parser.ClosedAuction) interface{} { return c.Entry.Context }},
+	{"bonusLists", KIND_STRING, func(c *parser.ClosedAuction) interface{} { return bonusLists(c.Entry.BonusLists) }},
+	{"modifiers", KIND_STRING, func(c *parser.ClosedAuction) interface{} { return modifiers(c.Entry.Modifiers) }},
+	{"petSpeciesId", KIND_INT, func(c *parser.ClosedAuction) interface{} { return int64(c.Entry.PetSpeciesId) }},
+	{"petBreedId", KIND_INT, func(c *parser.ClosedAuction) interface{} { return int64(c.Entry.PetBreedId) }},
+	{"petLevel", KIND_INT, func(c *parser.ClosedAuction) interface{} { return int64(c.Entry.PetLevel) }},
+	{"petQualityId", KIND_INT, func(c *parser.ClosedAuction) interface{} { return int64(c.Entry.PetQualityId) }},
+	{"opened", KIND_TIME, func(c *parser.ClosedAuction) interface{} { return c.Meta.Opened }},
+	{"closed", KIND_TIME, func(c *parser.ClosedAuction) interface{} { return c.Meta.Closed }},
+	{"result", KIND_STRING, func(c *parser.ClosedAuction) interface{} { return c.Meta.Result }},
+	{"profit", KIND_INT, func(c *parser.ClosedAuction) interface{} { return c.Meta.Profit }},
+}
+
+func ColumnNames() []string {
+	names := make([]string, len(Columns))
+	for i, c := range Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// SelectColumns picks columns by names, all of them for empty list
+func SelectColumns(names []string) ([]*Column, error) {
+	if len(names) == 0 {
+		return Columns, nil
+	}
+	var r []*Column
+	for _, name := range names {
+		found := false
+		for _, c := range Columns {
+			if c.Name == name {
+				r = append(r, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w %#v, known are %s", ErrBadColumn, name,
+				strings.Join(ColumnNames(), ","))
+		}
+	}
+	return r, nil
+}
+
+type Options struct {
+	Format  string
+	Dir     string // shared by realms, names differ
+	Columns []string
+	From    time.Time // by closing time, zero is unbound
+	To      time.Time
+}
+
+// rowWriter is implemented by csv and parquet outputs
+type rowWriter interface {
+	Write(values []interface{}) error
+	Close() error
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(f io.Writer, columns []*Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(f)}
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Name
+	}
+	return cw, cw.w.Write(header)
+}
+
+func (cw *csvWriter) Write(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case string:
+			record[i] = v
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// output file of one month
+type monthFile struct {
+	fname string
+	f     *os.File
+	buf   *bufio.Writer
+	rw    rowWriter
+	rows  int
+}
+
+func (m *monthFile) close() error {
+	err := m.rw.Close()
+	if ferr := m.buf.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func FileName(realm string, month time.Time, format string) string {
+	return fmt.Sprintf("%s-%s-closed.%s", month.Format("2006_01"), util.Safe_Realm(realm), format)
+}
+
+func (opts *Options) Check() error {
+	if opts.Format != FORMAT_CSV && opts.Format != FORMAT_PARQUET {
+		return fmt.Errorf("%w %#v, must be %s or %s", ErrBadFormat, opts.Format, FORMAT_CSV, FORMAT_PARQUET)
+	}
+	_, err := SelectColumns(opts.Columns)
+	return err
+}
+
+// Realm exports closed auctions of realm, returns names of written
+// files. Existing files of exported months are overwritten.
+func Realm(cf *config.Config, realm string, opts *Options, lg *logging.Logger) (fnames []string, err error) {
+	lg = lg.With("realm", realm)
+	if err := opts.Check(); err != nil {
+		return nil, err
+	}
+	columns, err := SelectColumns(opts.Columns)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	store, err := parser.OpenStore(cf, realm, cf.Realm(realm).ResultDirectory)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	// auctions come ordered by closing time, so the file of a month is
+	// closed once the next month begins
+	var out *monthFile
+	done := make(map[string]bool) // months with closed files
+	finish := func() error {
+		if out == nil {
+			return nil
+		}
+		err := out.close()
+		lg.Infof("%s: %d auctions exported", out.fname, out.rows)
+		out = nil
+		return err
+	}
+	defer func() {
+		if cerr := finish(); err == nil {
+			err = cerr
+		}
+	}()
+	month := ""
+	values := make([]interface{}, len(columns))
+	q := &parser.Query{From: opts.From, To: opts.To}
+	err = store.EachClosed(q, func(c *parser.ClosedAuction) error {
+		closed := c.Meta.Closed.UTC()
+		if m := closed.Format("2006_01"); out == nil || m != month {
+			if done[m] {
+				// file would be overwritten by the rest of month
+				return fmt.Errorf("auctions of %s are not ordered by closing time", m)
+			}
+			if err := finish(); err != nil {
+				return err
+			}
+			done[month] = true
+			fname := filepath.Join(opts.Dir, FileName(realm, closed, opts.Format))
+			var err error
+			if out, err = createMonthFile(fname, opts.Format, columns); err != nil {
+				return err
+			}
+			month = m
+			fnames = append(fnames, fname)
+		}
+		for i, col := range columns {
+			v := col.Value(realm, c)
+			if t, ok := v.(time.Time); ok {
+				if opts.Format == FORMAT_PARQUET {
+					v = t.UnixMilli()
+				} else {
+					v = t.UTC().Format(time.RFC3339)
+				}
+			}
+			values[i] = v
+		}
+		out.rows++
+		return out.rw.Write(values)
+	})
+	return fnames, err
+}
+
+func createMonthFile(fname, format string, columns []*Column) (*monthFile, error) {
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	m := &monthFile{fname: fname, f: f, buf: bufio.NewWriter(f)}
+	if format == FORMAT_CSV {
+		m.rw, err = newCSVWriter(m.buf, columns)
+	} else {
+		m.rw, err = NewParquetWriter(m.buf, columns)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return m, nil
+}
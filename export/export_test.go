@@ -0,0 +1,87 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	parser "github.com/wowauc/gowowuction/parser"
+)
+
+const testRealm = "eu:fordragon"
+
+// results of testRealm, one auction closed every day of January and
+// February 2016, auc is the day number
+func setupResults(t *testing.T) (*config.Config, []time.Time) {
+	t.Helper()
+	cf := config.Default()
+	cf.ResultDirectory = filepath.Join(t.TempDir(), "result") + string(os.PathSeparator)
+	if err := os.MkdirAll(cf.ResultDirectory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	st := parser.NewJSONLStore(cf, testRealm, cf.ResultDirectory)
+	var times []time.Time
+	for ts := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC); ts.Month() < 3; ts = ts.AddDate(0, 0, 1) {
+		if err := st.Begin(ts); err != nil {
+			t.Fatal(err)
+		}
+		var c parser.ClosedAuction
+		c.Entry.Auc = int64(len(times) + 1)
+		c.Entry.Owner = "Foo"
+		c.Meta = parser.AuctionMeta{Auc: c.Entry.Auc, Opened: ts.Add(-time.Hour), Closed: ts, Result: "sold"}
+		if err := st.AddClosed(&c); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, ts)
+	}
+	return cf, times
+}
+
+func TestRealm(t *testing.T) {
+	cf, times := setupResults(t)
+	columns := []string{"auc", "owner", "closed"}
+	for _, format := range []string{FORMAT_CSV, FORMAT_PARQUET} {
+		opts := &Options{Format: format, Dir: t.TempDir(), Columns: columns}
+		fnames, err := Realm(cf, testRealm, opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantnames := []string{
+			filepath.Join(opts.Dir, FileName(testRealm, times[0], format)),
+			filepath.Join(opts.Dir, FileName(testRealm, times[31], format)),
+		}
+		if !reflect.DeepEqual(fnames, wantnames) {
+			t.Fatalf("%s: files %v, want %v", format, fnames, wantnames)
+		}
+		cols, _ := SelectColumns(columns)
+		for i, part := range [][]time.Time{times[:31], times[31:]} {
+			data, err := os.ReadFile(fnames[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([][]interface{}, len(cols))
+			lines := []string{strings.Join(columns, ",")}
+			for _, ts := range part {
+				auc := int64(ts.YearDay())
+				want[0] = append(want[0], auc)
+				want[1] = append(want[1], "Foo")
+				want[2] = append(want[2], ts.UnixMilli())
+				lines = append(lines, strings.Join([]string{
+					strconv.Itoa(ts.YearDay()), "Foo", ts.Format(time.RFC3339)}, ","))
+			}
+			if format == FORMAT_PARQUET {
+				checkParquet(t, data, cols, want)
+			} else if got := strings.Join(lines, "\n") + "\n"; string(data) != got {
+				t.Errorf("%s:\n%s\nwant\n%s", fnames[i], data, got)
+			}
+		}
+	}
+}
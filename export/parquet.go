@@ -0,0 +1,235 @@
+package export
+
+// Minimal parquet writer: flat schema of required INT64 and UTF8
+// BYTE_ARRAY columns, PLAIN encoding, one gzipped data page per column
+// chunk. See https://github.com/apache/parquet-format for the layout.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	PARQUET_MAGIC      = "PAR1"
+	PARQUET_ROW_GROUP  = 65536 // rows per row group
+	PARQUET_CREATED_BY = "gowowuction export"
+)
+
+// parquet enums used here
+const (
+	pqTypeInt64     = 2
+	pqTypeByteArray = 6
+
+	pqRequired = 0
+
+	pqConvertedUTF8       = 0
+	pqConvertedTimeMillis = 9 // TIMESTAMP_MILLIS
+
+	pqEncodingPlain = 0
+	pqEncodingRLE   = 3
+
+	pqCodecGzip = 2
+
+	pqPageData = 0
+)
+
+type pqColumn struct {
+	name string
+	kind int
+	ints []int64
+	strs bytes.Buffer // plain encoded
+	n    int
+}
+
+type pqChunk struct {
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+type pqRowGroup struct {
+	chunks []pqChunk
+	rows   int64
+	size   int64
+}
+
+type ParquetWriter struct {
+	w      io.Writer
+	offset int64
+	cols   []*pqColumn
+	rows   int
+	groups []pqRowGroup
+	total  int64
+}
+
+func NewParquetWriter(w io.Writer, columns []*Column) (*ParquetWriter, error) {
+	pw := &ParquetWriter{w: w}
+	for _, c := range columns {
+		pw.cols = append(pw.cols, &pqColumn{name: c.Name, kind: c.Kind})
+	}
+	return pw, pw.write([]byte(PARQUET_MAGIC))
+}
+
+func (pw *ParquetWriter) write(data []byte) error {
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	return err
+}
+
+// values are int64 for KIND_INT and KIND_TIME (in ms), string for KIND_STRING
+func (pw *ParquetWriter) Write(values []interface{}) error {
+	for i, c := range pw.cols {
+		if c.kind == KIND_STRING {
+			s := values[i].(string)
+			c.strs.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+			c.strs.WriteString(s)
+		} else {
+			c.ints = append(c.ints, values[i].(int64))
+		}
+		c.n++
+	}
+	pw.rows++
+	if pw.rows >= PARQUET_ROW_GROUP {
+		return pw.flush()
+	}
+	return nil
+}
+
+func (c *pqColumn) plain() []byte {
+	if c.kind == KIND_STRING {
+		return c.strs.Bytes()
+	}
+	data := make([]byte, 0, 8*len(c.ints))
+	for _, v := range c.ints {
+		data = binary.LittleEndian.AppendUint64(data, uint64(v))
+	}
+	return data
+}
+
+// write buffered rows as a row group
+func (pw *ParquetWriter) flush() error {
+	if pw.rows == 0 {
+		return nil
+	}
+	g := pqRowGroup{rows: int64(pw.rows)}
+	for _, c := range pw.cols {
+		data := c.plain()
+		var zdata bytes.Buffer
+		zw := gzip.NewWriter(&zdata)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		var h thriftWriter
+		h.begin()
+		h.i32(1, pqPageData)
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(zdata.Len()))
+		h.structField(5) // data_page_header
+		h.i32(1, int32(c.n))
+		h.i32(2, pqEncodingPlain)
+		h.i32(3, pqEncodingRLE)
+		h.i32(4, pqEncodingRLE)
+		h.end()
+		h.end()
+
+		chunk := pqChunk{
+			offset:       pw.offset,
+			uncompressed: int64(h.buf.Len() + len(data)),
+			compressed:   int64(h.buf.Len() + zdata.Len()),
+		}
+		if err := pw.write(h.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(zdata.Bytes()); err != nil {
+			return err
+		}
+		g.chunks = append(g.chunks, chunk)
+		g.size += chunk.uncompressed
+
+		c.ints, c.n = c.ints[:0], 0
+		c.strs.Reset()
+	}
+	pw.groups = append(pw.groups, g)
+	pw.total += g.rows
+	pw.rows = 0
+	return nil
+}
+
+func (c *pqColumn) pqType() int32 {
+	if c.kind == KIND_STRING {
+		return pqTypeByteArray
+	}
+	return pqTypeInt64
+}
+
+// Close flushes rows and writes footer, underlying writer is not closed
+func (pw *ParquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	var m thriftWriter
+	m.begin()
+	m.i32(1, 1) // version
+	m.list(2, tStruct, len(pw.cols)+1)
+	m.begin() // root of schema
+	m.string(4, "schema")
+	m.i32(5, int32(len(pw.cols)))
+	m.end()
+	for _, c := range pw.cols {
+		m.begin()
+		m.i32(1, c.pqType())
+		m.i32(3, pqRequired)
+		m.string(4, c.name)
+		switch c.kind {
+		case KIND_STRING:
+			m.i32(6, pqConvertedUTF8)
+		case KIND_TIME:
+			m.i32(6, pqConvertedTimeMillis)
+		}
+		m.end()
+	}
+	m.i64(3, pw.total)
+	m.list(4, tStruct, len(pw.groups))
+	for _, g := range pw.groups {
+		m.begin()
+		m.list(1, tStruct, len(g.chunks))
+		for i, ch := range g.chunks {
+			c := pw.cols[i]
+			m.begin()
+			m.i64(2, ch.offset)
+			m.structField(3) // meta_data
+			m.i32(1, c.pqType())
+			m.list(2, tI32, 2)
+			m.varint(zigzag(pqEncodingPlain))
+			m.varint(zigzag(pqEncodingRLE))
+			m.list(3, tBinary, 1)
+			m.rawString(c.name)
+			m.i32(4, pqCodecGzip)
+			m.i64(5, g.rows)
+			m.i64(6, ch.uncompressed)
+			m.i64(7, ch.compressed)
+			m.i64(9, ch.offset)
+			m.end()
+			m.end()
+		}
+		m.i64(2, g.size)
+		m.i64(3, g.rows)
+		m.end()
+	}
+	m.string(6, PARQUET_CREATED_BY)
+	m.end()
+
+	if err := pw.write(m.buf.Bytes()); err != nil {
+		return err
+	}
+	if err := pw.write(binary.LittleEndian.AppendUint32(nil, uint32(m.buf.Len()))); err != nil {
+		return err
+	}
+	return pw.write([]byte(PARQUET_MAGIC))
+}
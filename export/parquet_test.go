@@ -0,0 +1,276 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// Reader of thrift compact protocol: structs are decoded to maps by
+// field id, lists to slices, integers to int64 and binary to string.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		panic("thrift: unexpected end of data")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		panic("thrift: bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case tI32, tI64:
+		return r.varint()
+	case tBinary:
+		n := int(r.uvarint())
+		if r.pos+n > len(r.data) {
+			panic("thrift: binary out of data")
+		}
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case tList:
+		h := r.byte()
+		n, etyp := int(h>>4), h&0x0f
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(etyp)
+		}
+		return list
+	case tStruct:
+		return r.fields()
+	}
+	panic(fmt.Sprintf("thrift: type %d not expected", typ))
+}
+
+func (r *thriftReader) fields() map[int16]interface{} {
+	m := make(map[int16]interface{})
+	var last int16
+	for {
+		h := r.byte()
+		if h == 0 {
+			return m
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.varint())
+		}
+		m[id] = r.value(h & 0x0f)
+		last = id
+	}
+}
+
+// decode struct at the start of data, n is its encoded size
+func decodeStruct(t *testing.T, data []byte) (s map[int16]interface{}, n int) {
+	t.Helper()
+	defer func() {
+		if e := recover(); e != nil {
+			t.Fatal(e)
+		}
+	}()
+	r := &thriftReader{data: data}
+	return r.fields(), r.pos
+}
+
+func field[T any](t *testing.T, s map[int16]interface{}, id int16) T {
+	t.Helper()
+	v, ok := s[id].(T)
+	if !ok {
+		t.Fatalf("field %d is %#v, want %T", id, s[id], v)
+	}
+	return v
+}
+
+// plain encoded values of column
+func decodePlain(t *testing.T, kind int, data []byte, n int) []interface{} {
+	t.Helper()
+	var values []interface{}
+	for i := 0; i < n; i++ {
+		if kind == KIND_STRING {
+			size := int(binary.LittleEndian.Uint32(data))
+			values = append(values, string(data[4:4+size]))
+			data = data[4+size:]
+		} else {
+			values = append(values, int64(binary.LittleEndian.Uint64(data)))
+			data = data[8:]
+		}
+	}
+	if len(data) != 0 {
+		t.Errorf("%d bytes after %d values", len(data), n)
+	}
+	return values
+}
+
+func TestParquetFile(t *testing.T) {
+	columns, err := SelectColumns([]string{"auc", "owner", "closed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := PARQUET_ROW_GROUP + 10 // the second row group is partial
+	var buf bytes.Buffer
+	pw, err := NewParquetWriter(&buf, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([][]interface{}, len(columns))
+	for i := 0; i < rows; i++ {
+		row := []interface{}{int64(i + 1), fmt.Sprintf("owner%d", i%7), int64(1455105600000 + i*1000)}
+		for j, v := range row {
+			want[j] = append(want[j], v)
+		}
+		if err := pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkParquet(t, buf.Bytes(), columns, want)
+}
+
+// Check layout of parquet file: magic at both ends, footer length,
+// file metadata, row groups and page headers of every column chunk.
+// want are column values of all rows.
+func checkParquet(t *testing.T, data []byte, columns []*Column, want [][]interface{}) {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != PARQUET_MAGIC || string(data[len(data)-4:]) != PARQUET_MAGIC {
+		t.Fatalf("no magic around %d bytes", len(data))
+	}
+	flen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	fstart := len(data) - 8 - flen
+	if fstart < 4 {
+		t.Fatalf("footer length %d out of file", flen)
+	}
+	meta, n := decodeStruct(t, data[fstart:len(data)-8])
+	if n != flen {
+		t.Errorf("metadata of %d bytes, footer length %d", n, flen)
+	}
+
+	if v := field[int64](t, meta, 1); v != 1 {
+		t.Errorf("version %d", v)
+	}
+	if v := field[string](t, meta, 6); v != PARQUET_CREATED_BY {
+		t.Errorf("created by %#v", v)
+	}
+	rows := int64(len(want[0]))
+	if v := field[int64](t, meta, 3); v != rows {
+		t.Errorf("%d rows, want %d", v, rows)
+	}
+	schema := field[[]interface{}](t, meta, 2)
+	if len(schema) != len(columns)+1 {
+		t.Fatalf("schema of %d elements", len(schema))
+	}
+	root := schema[0].(map[int16]interface{})
+	if field[string](t, root, 4) != "schema" || field[int64](t, root, 5) != int64(len(columns)) {
+		t.Errorf("schema root %v", root)
+	}
+	for i, c := range columns {
+		el := schema[i+1].(map[int16]interface{})
+		typ, converted := int64(pqTypeInt64), int64(-1)
+		switch c.Kind {
+		case KIND_STRING:
+			typ, converted = pqTypeByteArray, pqConvertedUTF8
+		case KIND_TIME:
+			converted = pqConvertedTimeMillis
+		}
+		if field[string](t, el, 4) != c.Name || field[int64](t, el, 1) != typ || field[int64](t, el, 3) != pqRequired {
+			t.Errorf("schema of %s: %v", c.Name, el)
+		}
+		if v, ok := el[6]; (converted < 0 && ok) || (converted >= 0 && v != converted) {
+			t.Errorf("converted type of %s: %v", c.Name, v)
+		}
+	}
+
+	groups := field[[]interface{}](t, meta, 4)
+	if want := (rows + PARQUET_ROW_GROUP - 1) / PARQUET_ROW_GROUP; int64(len(groups)) != want {
+		t.Fatalf("%d row groups, want %d", len(groups), want)
+	}
+	got := make([][]interface{}, len(columns))
+	offset := int64(len(PARQUET_MAGIC)) // chunks follow each other
+	for gi, g := range groups {
+		g := g.(map[int16]interface{})
+		grows := field[int64](t, g, 3)
+		if left := rows - int64(gi)*PARQUET_ROW_GROUP; grows != min(left, PARQUET_ROW_GROUP) {
+			t.Errorf("row group %d: %d rows", gi, grows)
+		}
+		chunks := field[[]interface{}](t, g, 1)
+		if len(chunks) != len(columns) {
+			t.Fatalf("row group %d: %d chunks", gi, len(chunks))
+		}
+		var size int64
+		for ci, ch := range chunks {
+			c := columns[ci]
+			ch := ch.(map[int16]interface{})
+			cm := field[map[int16]interface{}](t, ch, 3)
+			if field[int64](t, ch, 2) != offset || field[int64](t, cm, 9) != offset {
+				t.Fatalf("row group %d, %s: chunk at %v/%v, want %d", gi, c.Name, ch[2], cm[9], offset)
+			}
+			if !reflect.DeepEqual(cm[3], []interface{}{c.Name}) || field[int64](t, cm, 4) != pqCodecGzip || field[int64](t, cm, 5) != grows {
+				t.Errorf("row group %d, %s: column meta %v", gi, c.Name, cm)
+			}
+
+			// page header and gzipped plain values
+			ph, hlen := decodeStruct(t, data[offset:fstart])
+			dph := field[map[int16]interface{}](t, ph, 5)
+			if field[int64](t, ph, 1) != pqPageData || field[int64](t, dph, 1) != grows || field[int64](t, dph, 2) != pqEncodingPlain {
+				t.Errorf("row group %d, %s: page header %v", gi, c.Name, ph)
+			}
+			usize, csize := field[int64](t, ph, 2), field[int64](t, ph, 3)
+			if field[int64](t, cm, 7) != int64(hlen)+csize || field[int64](t, cm, 6) != int64(hlen)+usize {
+				t.Errorf("row group %d, %s: chunk sizes %v/%v, page %d+%d/%d", gi, c.Name, cm[6], cm[7], hlen, usize, csize)
+			}
+			start := offset + int64(hlen)
+			zr, err := gzip.NewReader(bytes.NewReader(data[start : start+csize]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(page)) != usize {
+				t.Errorf("row group %d, %s: page of %d bytes, want %d", gi, c.Name, len(page), usize)
+			}
+			got[ci] = append(got[ci], decodePlain(t, c.Kind, page, int(grows))...)
+			size += field[int64](t, cm, 6)
+			offset = start + csize
+		}
+		if field[int64](t, g, 2) != size {
+			t.Errorf("row group %d: size %v, want %d", gi, g[2], size)
+		}
+	}
+	if offset != int64(fstart) {
+		t.Errorf("chunks end at %d, footer starts at %d", offset, fstart)
+	}
+	for i, c := range columns {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("values of %s differ", c.Name)
+		}
+	}
+}
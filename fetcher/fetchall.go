@@ -0,0 +1,147 @@
+package fetcher
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	dedup "github.com/wowauc/gowowuction/dedup"
+	logging "github.com/wowauc/gowowuction/logging"
+	parser "github.com/wowauc/gowowuction/parser"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// file with time of the last complete fetch of realm
+func stampName(cf *config.Config, realm string) string {
+	return cf.DownloadDirectory + util.Safe_Realm(realm) + ".fetched"
+}
+
+// time of the last complete fetch of realm, zero if unknown
+func lastFetch(cf *config.Config, realm string) time.Time {
+	data, err := ioutil.ReadFile(stampName(cf, realm))
+	if err != nil {
+		return time.Time{}
+	}
+	ts, _ := util.ParseTS(strings.TrimSpace(string(data)))
+	return ts
+}
+
+// Download fresh snapshots of enabled realms in their locales to
+// DownloadDirectory, skipping known ones and duplicates by content.
+// Realms with fetch interval are skipped until it passes since their
+// last complete fetch.
+// Returns the number of stored snapshots and of realm/locale pairs
+// failed to fetch.
+func FetchAll(cf *config.Config, lg *logging.Logger) (stored, failed int) {
+	s := new(Session)
+	s.Config = cf
+	for _, realm := range cf.RealmsList {
+		rlg := lg.With("realm", realm)
+		s.Log = rlg
+		rs := cf.Realm(realm)
+		started := time.Now()
+		if last := lastFetch(cf, realm); rs.FetchInterval > 0 && started.Sub(last) < rs.FetchInterval {
+			rlg.Infof("%s fetched at %s, next fetch after %s", realm,
+				util.TSStr(last), util.TSStr(last.Add(rs.FetchInterval)))
+			continue
+		}
+		hashes, err := dedup.Open(cf.DownloadDirectory, realm)
+		if err != nil {
+			rlg.Warnf("hash index of %s not loaded: %s", realm, err)
+			failed += len(rs.Locales)
+			continue
+		}
+		failed_before := failed
+		for _, locale := range rs.Locales {
+			file_url, file_ts, err := s.Fetch_FileURL(realm, locale)
+			if err != nil {
+				rlg.Warnf("NO FILE URL FOR realm=%#v locale=%#v ", realm, locale)
+				failed++
+				fetchSnapshots.Inc(realm, "failed")
+				continue
+			}
+			if file_url == "" {
+				rlg.Infof("NO FILES FOR realm=%#v locale=%#v", realm, locale)
+				continue
+			}
+			rlg.Infof("FILE URL: %s", file_url)
+			rlg.Infof("FILE PIT: %s / %s", file_ts, util.TSStr(file_ts.UTC()))
+			snapshotTime.Set(float64(file_ts.Unix()), realm)
+			fname := util.Make_FName(realm, file_ts, true)
+			json_fname := cf.DownloadDirectory + fname
+			exists, err := util.CheckFile(json_fname)
+			if err != nil {
+				rlg.Warnf("%s", err)
+				failed++
+				fetchSnapshots.Inc(realm, "failed")
+				continue
+			}
+			if orig, dup := hashes.Duplicate(file_ts); dup {
+				rlg.Infof("... already seen as duplicate of %s", util.TSStr(orig))
+				fetchSnapshots.Inc(realm, "duplicate")
+				continue
+			}
+			if !exists {
+				rlg.Infof("downloading from %s ...", file_url)
+				started := time.Now()
+				data, err := s.Get(file_url)
+				if err != nil {
+					rlg.Warnf("DATA NOT RETRIEVED FOR realm=%#v locale=%#v", realm, locale)
+					failed++
+					fetchSnapshots.Inc(realm, "failed")
+					continue
+				}
+				fetchDuration.Observe(time.Since(started).Seconds(), realm)
+				fetchBytes.Add(float64(len(data)), realm)
+				rlg.Infof("... got %d octets", len(data))
+				rlg.Infof("validate snapshot data ...")
+				j, err := parser.ParseSnapshot(data)
+				if err != nil {
+					rlg.Warnf("%s", err)
+					failed++
+					fetchSnapshots.Inc(realm, "failed")
+					continue
+				}
+				rlg.Infof("... data seems valid and contains %d auctions from %d realm(s).",
+					len(j.Auctions), len(j.Realms))
+				orig, dup, err := hashes.Check(file_ts, data)
+				if err != nil {
+					rlg.Warnf("%s", err)
+					failed++
+					fetchSnapshots.Inc(realm, "failed")
+					continue
+				}
+				if dup {
+					rlg.Infof("same content as snapshot %s, not stored", util.TSStr(orig))
+					fetchSnapshots.Inc(realm, "duplicate")
+					continue
+				}
+				zdata := util.Zip(data)
+				rlg.Infof("... zipped to %d octets (%d%%)",
+					len(zdata), len(zdata)*100/len(data))
+				if err := util.Store(json_fname, zdata); err != nil {
+					rlg.Warnf("not stored to %s: %s", json_fname, err)
+					failed++
+					fetchSnapshots.Inc(realm, "failed")
+					continue
+				}
+				rlg.Infof("stored to %s .", json_fname)
+				stored++
+				fetchSnapshots.Inc(realm, "stored")
+			} else {
+				rlg.Infof("... already downloaded")
+				fetchSnapshots.Inc(realm, "existing")
+			}
+		}
+		if err := hashes.Save(); err != nil {
+			rlg.Warnf("hash index of %s not saved: %s", realm, err)
+		}
+		if failed == failed_before {
+			if err := ioutil.WriteFile(stampName(cf, realm), []byte(util.TSStr(started)+"\n"), 0644); err != nil {
+				rlg.Warnf("fetch time of %s not stored: %s", realm, err)
+			}
+		}
+	}
+	return stored, failed
+}
@@ -8,12 +8,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
 )
 
 type FDesc struct {
@@ -33,6 +34,7 @@ type Rec1 struct {
 type Session struct {
 	Config *config.Config
 	Client *http.Client
+	Log    *logging.Logger // root logger when nil
 }
 
 func (s *Session) Get(url string) (body []byte, err error) {
@@ -42,22 +44,24 @@ func (s *Session) Get(url string) (body []byte, err error) {
 	}
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Printf("[!] request not created: %s", url, err)
+		s.Log.Warnf("request for %s not created: %s", url, err)
 		return
 	}
 	request.Header.Add("Accept-Encoding", "gzip")
-	log.Printf("GET %s", url)
+	s.Log.Infof("GET %s", url)
 	response, err := s.Client.Do(request)
 	if err != nil {
-		log.Printf("[!] request failed: %s", err)
+		s.Log.Warnf("request failed: %s", err)
+		httpErrors.Inc("error")
 		return
 	}
 	defer response.Body.Close()
 	if response.StatusCode != 200 {
+		httpErrors.Inc(strconv.Itoa(response.StatusCode))
 		msg := fmt.Sprintf("status code %d != 200 : %s",
 			response.StatusCode, response.Status)
 		err = errors.New(msg)
-		log.Printf("[!] " + msg)
+		s.Log.Warnf("%s", msg)
 		return
 	}
 
@@ -67,7 +71,7 @@ func (s *Session) Get(url string) (body []byte, err error) {
 	case "gzip":
 		reader, err = gzip.NewReader(response.Body)
 		if err != nil {
-			log.Printf("[!] gzip reader failed: %s", err)
+			s.Log.Warnf("gzip reader failed: %s", err)
 			return
 		}
 		defer reader.Close()
@@ -76,7 +80,7 @@ func (s *Session) Get(url string) (body []byte, err error) {
 	}
 	body, err = ioutil.ReadAll(reader)
 	if err != nil {
-		log.Printf("[!] request read failed: %s", err)
+		s.Log.Warnf("request read failed: %s", err)
 		return
 	}
 	return
@@ -89,7 +93,7 @@ func (s *Session) Fetch_FileURL(realm string, locale string) (url string, ts tim
 	v := strings.Split(realm, ":")
 	if len(v) != 2 {
 		msg := "realm is in bad format: '" + realm + "'"
-		log.Println("[!] " + msg)
+		s.Log.Warnf("%s", msg)
 		err = errors.New(msg)
 		return
 	}
@@ -98,39 +102,39 @@ func (s *Session) Fetch_FileURL(realm string, locale string) (url string, ts tim
 		v[0], v[1], locale, s.Config.APIKey)
 	data, err = s.Get(url)
 	if err != nil {
-		log.Printf("[!] GET request failed for %s ...", url)
+		s.Log.Warnf("GET request failed for %s ...", url)
 		return
 	}
-	log.Println("parse auction file metainfo ...")
+	s.Log.Infof("parse auction file metainfo ...")
 
 	var p0 Rec0
 	if err = json.Unmarshal(data, &p0); err != nil {
-		log.Printf("[!] json to R0 failed: %s", err)
+		s.Log.Warnf("json to R0 failed: %s", err)
 		return
 	}
 
 	if p0.Status == "nok" {
 		msg := fmt.Sprintf("realm=%v locale=%v returned status=%v reason=%v",
 			realm, locale, p0.Status, p0.Reason)
-		log.Printf("[!] " + msg)
+		s.Log.Warnf("%s", msg)
 		err = errors.New(msg)
 		return
 	}
 
 	var p1 Rec1
 	if err = json.Unmarshal(data, &p1); err != nil {
-		log.Printf("[!] json failed: %s", err)
+		s.Log.Warnf("json failed: %s", err)
 		return
 	}
 
 	if len(p1.Files) < 1 {
-		log.Printf("thesre is no files (this is not an error)")
+		s.Log.Infof("thesre is no files (this is not an error)")
 		return
 	}
 
 	url = p1.Files[0].Url
 	lmt := p1.Files[0].Lmt
 	ts = time.Unix(lmt/1000, lmt%1000).UTC()
-	log.Printf("... url=%s, mtime=%s", url, ts)
+	s.Log.Infof("... url=%s, mtime=%s", url, ts)
 	return
 }
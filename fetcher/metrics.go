@@ -0,0 +1,23 @@
+package fetcher
+
+import (
+	"time"
+
+	metrics "github.com/wowauc/gowowuction/metrics"
+)
+
+var (
+	fetchDuration = metrics.NewHistogram("gowowuction_fetch_duration_seconds",
+		"Time to download snapshot data.", metrics.DurationBuckets, "realm")
+	fetchBytes = metrics.NewCounter("gowowuction_fetch_bytes_total",
+		"Bytes of snapshot data downloaded.", "realm")
+	fetchSnapshots = metrics.NewCounter("gowowuction_fetch_snapshots_total",
+		"Snapshots seen by fetch by result: stored, existing, duplicate or failed.", "realm", "result")
+	httpErrors = metrics.NewCounter("gowowuction_http_errors_total",
+		"Failed HTTP requests by status code, \"error\" when no response.", "code")
+	snapshotTime = metrics.NewGauge("gowowuction_snapshot_timestamp_seconds",
+		"Time of the latest snapshot offered by API.", "realm")
+	_ = metrics.NewGaugeFunc("gowowuction_snapshot_age_seconds",
+		"Age of the latest snapshot offered by API.", snapshotTime,
+		func(ts float64) float64 { return float64(time.Now().Unix()) - ts })
+)
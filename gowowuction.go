@@ -1,130 +1,420 @@
 package main
 
 import (
-	"io"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	backup "github.com/wowauc/gowowuction/backup"
 	config "github.com/wowauc/gowowuction/config"
+	delta "github.com/wowauc/gowowuction/delta"
+	export "github.com/wowauc/gowowuction/export"
 	fetcher "github.com/wowauc/gowowuction/fetcher"
+	logging "github.com/wowauc/gowowuction/logging"
 	parser "github.com/wowauc/gowowuction/parser"
+	query "github.com/wowauc/gowowuction/query"
+	retention "github.com/wowauc/gowowuction/retention"
 	util "github.com/wowauc/gowowuction/util"
 )
 
-func DoFetch(cf *config.Config) {
-	log.Println("=== FETCH BEGIN ===")
-	s := new(fetcher.Session)
-	s.Config = cf
+var (
+	ErrNotFetched      = errors.New("some snapshots not fetched")
+	ErrRealmsFailed    = errors.New("some realms failed")
+	ErrVerifyFailed    = errors.New("backup verification failed")
+	ErrPruneFailed     = errors.New("some files not pruned")
+	ErrRestoreMismatch = errors.New("checksum mismatches on restore")
+)
+
+func DoFetch(cf *config.Config, lg *logging.Logger) error {
+	lg.Infof("=== FETCH BEGIN ===")
+	stored, failed := fetcher.FetchAll(cf, lg)
+	lg.Infof("%d new snapshot(s) stored", stored)
+	lg.Infof("=== FETCH END ===")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d realm/locale pair(s)", ErrNotFetched, failed)
+	}
+	return nil
+}
+
+func DoParse(cf *config.Config, lg *logging.Logger) error {
+	lg.Infof("=== PARSE BEGIN ===")
+	failed := 0
 	for _, realm := range cf.RealmsList {
-		for _, locale := range cf.LocalesList {
-			file_url, file_ts, err := s.Fetch_FileURL(realm, locale)
-			if err != nil {
-				log.Printf("[!] NO FILE URL FOR realm=%#v locale=%#v ", realm, locale)
-				continue
+		rlg := lg.With("realm", realm)
+		err := parser.ParseDir(cf, realm, false, rlg)
+		var bad *parser.BadFilesError
+		switch {
+		case err == nil:
+		case errors.As(err, &bad):
+			rlg.Warnf("realm %s parsed with %d bad file(s)", realm, len(bad.Files))
+			failed++
+		default:
+			rlg.Warnf("realm %s not parsed: %s", realm, err)
+			failed++
+		}
+	}
+	lg.Infof("=== PARSE END ===")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrRealmsFailed, failed, len(cf.RealmsList))
+	}
+	return nil
+}
+
+const PETS_TOP = 25
+
+func DoPets(cf *config.Config, lg *logging.Logger, top int) error {
+	lg.Infof("=== PETS BEGIN ===")
+	failed := 0
+	for _, realm := range cf.RealmsList {
+		rlg := lg.With("realm", realm)
+		profits, err := parser.CollectPetProfits(cf, realm, rlg)
+		if err != nil {
+			rlg.Warnf("pets for realm %s not collected: %s", realm, err)
+			failed++
+			continue
+		}
+		fmt.Printf("most profitable pets for %s (%d keys):\n", realm, len(profits))
+		fmt.Printf("%-24s %8s %8s %12s %12s %5s\n",
+			"species/breed/qlty/level", "closed", "sold", "profit", "avg.price", "rate")
+		for i, p := range profits {
+			if i >= top {
+				break
 			}
-			if file_url == "" {
-				log.Printf("[i] NO FILES FOR realm=%#v locale=%#v", realm, locale)
+			fmt.Printf("%-24s %8d %8d %12d %12d %4d%%\n",
+				p.PetKey.String(), p.Closed, p.Sold, p.Profit, p.AvgPrice, p.SellRate)
+		}
+	}
+	lg.Infof("=== PETS END ===")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrRealmsFailed, failed, len(cf.RealmsList))
+	}
+	return nil
+}
+
+// reparse snapshots of every realm between from and to (zero is open bound)
+func DoReparse(cf *config.Config, lg *logging.Logger, from, to time.Time) error {
+	lg.Infof("=== REPARSE BEGIN ===")
+	failed := 0
+	for _, realm := range cf.RealmsList {
+		rlg := lg.With("realm", realm)
+		err := parser.Reparse(cf, realm, from, to, rlg)
+		var bad *parser.BadFilesError
+		switch {
+		case err == nil:
+		case errors.As(err, &bad):
+			rlg.Warnf("realm %s reparsed with %d bad file(s)", realm, len(bad.Files))
+			failed++
+		default:
+			rlg.Warnf("realm %s not reparsed: %s", realm, err)
+			failed++
+		}
+	}
+	lg.Infof("=== REPARSE END ===")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrRealmsFailed, failed, len(cf.RealmsList))
+	}
+	return nil
+}
+
+// restore snapshots matching filter from backups to dstdir
+// (download directory when empty)
+func DoRestore(cf *config.Config, lg *logging.Logger, dstdir string, filter *backup.RestoreFilter) error {
+	lg.Infof("=== RESTORE BEGIN ===")
+	if dstdir == "" {
+		dstdir = cf.DownloadDirectory
+	}
+	report, err := backup.Restore(cf.BackupDirectory, dstdir, filter, lg)
+	if err != nil {
+		return err
+	}
+	lg.Infof("=== RESTORE END ===")
+	if len(report.Mismatches) > 0 {
+		lg.Warnf("%d checksum mismatch(es), such snapshots are stored as .bad",
+			len(report.Mismatches))
+		return fmt.Errorf("%w: %d", ErrRestoreMismatch, len(report.Mismatches))
+	}
+	return nil
+}
+
+const VERIFY_MAX_GAP = 2 * time.Hour
+
+// verify backups, maxgap is max hole in timeline (0 disables), json report
+// goes to report_fname ("-" for stdout, log directory when empty)
+func DoVerify(cf *config.Config, lg *logging.Logger, maxgap time.Duration, report_fname string) error {
+	lg.Infof("=== VERIFY BEGIN ===")
+	if report_fname == "" {
+		report_fname = cf.LogDirectory + "verify-" + util.TSStr(time.Now()) + ".json"
+	}
+	report, err := backup.Verify(cf.BackupDirectory, maxgap, lg)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("report not marshalled: %w", err)
+	}
+	if report_fname == "-" {
+		fmt.Println(string(data))
+	} else if err := util.Store(report_fname, data); err != nil {
+		return fmt.Errorf("report not stored: %w", err)
+	} else {
+		lg.Infof("report stored to %s", report_fname)
+	}
+	lg.Infof("=== VERIFY END ===")
+	if !report.OK() {
+		return ErrVerifyFailed
+	}
+	return nil
+}
+
+// store new loose snapshots of every realm to delta directory
+func DoDelta(cf *config.Config, lg *logging.Logger) error {
+	lg.Infof("=== DELTA BEGIN ===")
+	failed := 0
+	for _, realm := range cf.RealmsList {
+		rlg := lg.With("realm", realm)
+		badfiles := make(map[string]error)
+		fnames, err := parser.ListSnapshots(cf, realm, badfiles)
+		if err != nil {
+			rlg.Warnf("realm %s not listed: %s", realm, err)
+			failed++
+			continue
+		}
+		w, err := delta.OpenWriter(cf.DeltaDirectory, realm, cf.DeltaKeyframes)
+		if err != nil {
+			rlg.Warnf("delta store of %s not opened: %s", realm, err)
+			failed++
+			continue
+		}
+		w.Log = rlg
+		keys, deltas := 0, 0
+		for _, fname := range fnames {
+			_, ts, _ := util.Parse_FName(fname)
+			if !ts.After(w.LastTime()) {
 				continue
 			}
-			log.Printf("FILE URL: %s", file_url)
-			log.Printf("FILE PIT: %s / %s", file_ts, util.TSStr(file_ts.UTC()))
-			fname := util.Make_FName(realm, file_ts, true)
-			json_fname := cf.DownloadDirectory + fname
-			if !util.CheckFile(json_fname) {
-				log.Printf("downloading from %s ...", file_url)
-				data, err := s.Get(file_url)
-				if err != nil {
-					log.Printf("[!] DATA NOT RETRIEVED FOR realm=%#v locale=%#v", realm, locale)
-					continue
-				}
-				log.Printf("... got %d octets", len(data))
-				log.Printf("validate snapshot data ...")
-				j, err := parser.ParseSnapshot(data)
-				if err != nil {
-					log.Printf("[!] %s", err)
-					continue
+			data, err := util.Load(fname)
+			if err == nil {
+				var key bool
+				if key, err = w.Put(ts, data); key {
+					keys++
+				} else if err == nil {
+					deltas++
 				}
-				log.Printf("... data seems valid and contains %d auctions from %d realm(s).",
-					len(j.Auctions), len(j.Realms))
-				zdata := util.Zip(data)
-				log.Printf("... zipped to %d octets (%d%%)",
-					len(zdata), len(zdata)*100/len(data))
-				util.Store(json_fname, zdata)
-				log.Printf("stored to %s .", json_fname)
-			} else {
-				log.Println("... already downloaded")
 			}
+			if err != nil {
+				rlg.Warnf("%s not stored: %s", fname, err)
+				badfiles[fname] = err
+			}
+		}
+		rlg.Infof("%s: %d keyframe(s), %d delta(s) stored, %d bad file(s)",
+			realm, keys, deltas, len(badfiles))
+		if len(badfiles) > 0 {
+			failed++
 		}
 	}
-	log.Println("=== FETCH END ===")
+	lg.Infof("=== DELTA END ===")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrRealmsFailed, failed, len(cf.RealmsList))
+	}
+	return nil
 }
 
-func DoParse(cf *config.Config) {
-	log.Println("=== PARSE BEGIN ===")
+// rebuild snapshots of every realm between from and to (zero is open
+// bound) from delta directory to dstdir (download directory when empty)
+func DoUndelta(cf *config.Config, lg *logging.Logger, dstdir string, from, to time.Time) error {
+	lg.Infof("=== UNDELTA BEGIN ===")
+	if dstdir == "" {
+		dstdir = cf.DownloadDirectory
+	}
+	if err := util.CheckDir(dstdir); err != nil {
+		return err
+	}
+	failed := 0
 	for _, realm := range cf.RealmsList {
-		parser.ParseDir(cf, realm, false)
+		rlg := lg.With("realm", realm)
+		rebuilt, existing := 0, 0
+		err := delta.Rebuild(cf.DeltaDirectory, realm, from, to, func(frame *delta.Frame, data []byte) error {
+			target := filepath.Join(dstdir, util.Make_FName(realm, frame.Time, true))
+			if exists, err := util.CheckFile(target); err != nil {
+				return err
+			} else if exists {
+				existing++
+				return nil
+			}
+			if err := util.Store(target+".tmp", util.Zip(data)); err != nil {
+				return err
+			}
+			rebuilt++
+			return os.Rename(target+".tmp", target)
+		})
+		rlg.Infof("%s: %d snapshot(s) rebuilt, %d existing", realm, rebuilt, existing)
+		if err != nil {
+			rlg.Warnf("realm %s not rebuilt: %s", realm, err)
+			failed++
+		}
+	}
+	lg.Infof("=== UNDELTA END ===")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrRealmsFailed, failed, len(cf.RealmsList))
+	}
+	return nil
+}
+
+func DoPrune(cf *config.Config, lg *logging.Logger, dryrun bool) error {
+	lg.Infof("=== PRUNE BEGIN ===")
+	report, err := retention.Prune(cf, time.Now(), dryrun, lg)
+	if err != nil {
+		return err
+	}
+	lg.Infof("=== PRUNE END ===")
+	if report.Failed > 0 {
+		return fmt.Errorf("%w: %d", ErrPruneFailed, report.Failed)
 	}
-	log.Println("=== PARSE END ===")
+	return nil
 }
 
-func DoBackup(cf *config.Config) {
-	log.Println("=== BACKUP BEGIN ===")
-	srcdir := cf.DownloadDirectory
-	dstdir := cf.BackupDirectory
-	ext := cf.BackupExt
-	nolast := cf.BackupWithoutLast
-	clean := cf.RemoveAfterBackup
-	util.CheckDir(dstdir)
-	//backup.Backup(srcdir, dstdir, "20060102", "", true, false)
-	//backup.Backup(srcdir, dstdir, "20060102", ".tar.gz", true, false)
-	//backup.Backup(srcdir, dstdir, "20060102", ".tar.xz", true, false)
-	//backup.Backup(srcdir, dstdir, "20060102", ".zip", true, false)
-	backup.Backup(srcdir, dstdir, "20060102", ext, nolast, clean)
-	log.Println("=== BACKUP END ===")
+// backup enabled realms, each with its own settings
+func DoBackup(cf *config.Config, lg *logging.Logger) error {
+	lg.Infof("=== BACKUP BEGIN ===")
+	if err := backup.BackupAll(cf, lg); err != nil {
+		return err
+	}
+	lg.Infof("=== BACKUP END ===")
+	return nil
 }
 
-func main() {
-	log.Println("preinitialize ...")
-	cf, err := config.AppConfig()
-	if err != nil {
-		log.Fatalln("config load error: ", err)
+// copy json lines results of every realm to its bolt store
+func DoImport(cf *config.Config, lg *logging.Logger) error {
+	lg.Infof("=== IMPORT BEGIN ===")
+	failed := 0
+	for _, realm := range cf.RealmsList {
+		rlg := lg.With("realm", realm)
+		if _, _, err := parser.ImportJSONL(cf, realm, rlg); err != nil {
+			rlg.Warnf("results of %s not imported: %s", realm, err)
+			failed++
+		}
 	}
-	util.CheckDir(cf.LogDirectory)
-	logname := cf.GetLogFName(true)
-	logf, err := os.OpenFile(logname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-	if err != nil {
-		log.Printf("[!] log file %s not opened: %s", logname, err)
-	} else {
-		defer logf.Close()
-		log.SetOutput(io.MultiWriter(logf, os.Stdout))
+	if cf.Storage != config.STORAGE_BOLT {
+		lg.Infof("set \"storage\": %#v in config to use imported results", config.STORAGE_BOLT)
+	}
+	lg.Infof("=== IMPORT END ===")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrRealmsFailed, failed, len(cf.RealmsList))
 	}
+	return nil
+}
 
-	log.Println("=== application started at " + util.TSStr(time.Now()))
-	cf.Dump()
+const EXPORT_DIR = "export"
 
-	util.CheckDir(cf.DownloadDirectory)
-	util.CheckDir(cf.ResultDirectory)
-	util.CheckDir(cf.BackupDirectory)
+// export closed auctions of every realm, to result directory of realm
+// when opts.Dir is empty
+func DoExport(cf *config.Config, lg *logging.Logger, opts export.Options) error {
+	lg.Infof("=== EXPORT BEGIN ===")
+	if err := opts.Check(); err != nil {
+		return err
+	}
+	failed := 0
+	dir := opts.Dir
+	for _, realm := range cf.RealmsList {
+		rlg := lg.With("realm", realm)
+		if dir == "" {
+			opts.Dir = cf.Realm(realm).ResultDirectory + EXPORT_DIR
+		}
+		fnames, err := export.Realm(cf, realm, &opts, rlg)
+		if err != nil {
+			rlg.Warnf("results of %s not exported: %s", realm, err)
+			failed++
+			continue
+		}
+		rlg.Infof("%d file(s) exported for %s", len(fnames), realm)
+	}
+	lg.Infof("=== EXPORT END ===")
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrRealmsFailed, failed, len(cf.RealmsList))
+	}
+	return nil
+}
 
-	if len(os.Args) == 0 {
-		DoFetch(cf)
-	} else {
-		for _, arg := range os.Args[1:] {
-			switch arg {
-			case "dfltcfg":
-				(cf).Save(config.ConfigName() + ".default")
-			case "fetch":
-				DoFetch(cf)
-			case "parse":
-				DoParse(cf)
-			case "backup":
-				DoBackup(cf)
-			default:
-				log.Printf("unknown arg: \"%s\", must be one of [dfltcfg, fetch, parse, backup]", arg)
+// run ad-hoc query over results of all realms, output goes to stdout
+func DoQuery(cf *config.Config, lg *logging.Logger, spec *query.Spec, output string) error {
+	lg.Infof("=== QUERY BEGIN ===")
+	if err := query.CheckOutput(output); err != nil {
+		return err
+	}
+	t, err := query.Run(cf, spec)
+	if err != nil {
+		return err
+	}
+	if err := t.Write(os.Stdout, output); err != nil {
+		return err
+	}
+	lg.Infof("%d row(s) found", len(t.Rows))
+	lg.Infof("=== QUERY END ===")
+	return nil
+}
+
+func migrateResults(cf *config.Config, realm string, dryrun bool, lg *logging.Logger) (renamed int, err error) {
+	safe := util.Safe_Realm(realm)
+	legacy := util.Legacy_Safe_Realm(realm)
+	var fnames []string
+	resdir := cf.Realm(realm).ResultDirectory
+	state := resdir + cf.GetName("state", realm) + ".gz"
+	fnames = append(fnames, strings.Replace(state, safe, legacy, 1))
+	for _, name := range parser.ResultNames {
+		mask := strings.Replace(cf.GetTimedMask(name, realm), safe, legacy, 1)
+		found, err := filepath.Glob(resdir + mask)
+		if err != nil {
+			return renamed, err
+		}
+		fnames = append(fnames, found...)
+	}
+	for _, fname := range fnames {
+		if exists, err := util.CheckFile(fname); err != nil {
+			return renamed, err
+		} else if !exists {
+			continue
+		}
+		base := filepath.Base(fname)
+		newname := filepath.Join(filepath.Dir(fname), strings.Replace(base, legacy, safe, 1))
+		if exists, err := util.CheckFile(newname); err != nil {
+			return renamed, err
+		} else if exists {
+			lg.Warnf("%s not renamed: %s already exists", fname, newname)
+			continue
+		}
+		lg.Infof("rename %s -> %s", fname, newname)
+		if !dryrun {
+			if err := os.Rename(fname, newname); err != nil {
+				return renamed, err
 			}
 		}
+		renamed++
+	}
+	return renamed, nil
+}
+
+func DoMigrate(cf *config.Config, lg *logging.Logger, dryrun bool) error {
+	lg.Infof("=== MIGRATE BEGIN ===")
+	for _, dir := range []string{cf.DownloadDirectory, cf.BackupDirectory} {
+		n, err := util.MigrateNames(dir, dryrun)
+		if err != nil {
+			return fmt.Errorf("migration of %s failed: %w", dir, err)
+		}
+		lg.Infof("%d file(s) renamed in %s", n, dir)
+	}
+	for _, realm := range cf.RealmsList {
+		rlg := lg.With("realm", realm)
+		n, err := migrateResults(cf, realm, dryrun, rlg)
+		if err != nil {
+			return fmt.Errorf("migration of results for %s failed: %w", realm, err)
+		}
+		rlg.Infof("%d result file(s) renamed for %s", n, realm)
 	}
-	log.Println("=== application finished at " + util.TSStr(time.Now()))
+	lg.Infof("=== MIGRATE END ===")
+	return nil
 }
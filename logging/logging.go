@@ -0,0 +1,272 @@
+package logging
+
+// Leveled structured records for the whole application. Code that
+// knows its stage or realm logs through a Logger derived by With, so
+// records of concurrent goroutines keep their own fields. Output of the
+// global log is turned into records too: "[!]", "[i]" and "[d]"
+// prefixes give the level, plain messages are info, fields are none.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DEBUG || l > ERROR {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+const (
+	FORMAT_LOGFMT = "logfmt"
+	FORMAT_JSON   = "json"
+)
+
+var (
+	ErrBadLevel  = errors.New("unknown log level")
+	ErrBadFormat = errors.New("unknown log format")
+)
+
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return INFO, fmt.Errorf("%w %#v, must be one of %v", ErrBadLevel, s, levelNames)
+}
+
+func CheckFormat(format string) error {
+	if format != FORMAT_LOGFMT && format != FORMAT_JSON {
+		return fmt.Errorf("%w %#v, must be %s or %s", ErrBadFormat, format, FORMAT_LOGFMT, FORMAT_JSON)
+	}
+	return nil
+}
+
+type Options struct {
+	Level    Level
+	Format   string    // FORMAT_LOGFMT or FORMAT_JSON
+	Dir      string    // log files go here when not empty
+	MaxSize  int64     // rotate file above this size, 0 for daily rotation only
+	Compress bool      // gzip rotated files
+	Console  io.Writer // copy of records, may be nil
+}
+
+type logger struct {
+	mu     sync.Mutex
+	opts   Options
+	file   *Rotator
+	failed bool // last write to file failed
+}
+
+var std = &logger{
+	opts: Options{Level: INFO, Format: FORMAT_LOGFMT, Console: os.Stderr},
+}
+
+// Setup replaces output of the global log with records by opts. It may
+// be called again, e.g. once config with log options is loaded.
+func Setup(opts Options) error {
+	if err := CheckFormat(opts.Format); err != nil {
+		return err
+	}
+	var file *Rotator
+	if opts.Dir != "" {
+		var err error
+		if file, err = OpenRotator(opts.Dir, opts.MaxSize, opts.Compress); err != nil {
+			return err
+		}
+	}
+	std.mu.Lock()
+	old := std.file
+	std.opts = opts
+	std.file = file
+	std.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	log.SetFlags(0)
+	log.SetOutput(bridge{})
+	return nil
+}
+
+// Close log file, waiting for compression of rotated ones
+func Close() error {
+	std.mu.Lock()
+	file := std.file
+	std.file = nil
+	std.mu.Unlock()
+	if file == nil {
+		return nil
+	}
+	return file.Close()
+}
+
+// name of the current log file, empty when logging to console only
+func FileName() string {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if std.file == nil {
+		return ""
+	}
+	return std.file.Name()
+}
+
+// Logger writes records with its fields. Nil logger is the root one,
+// it has no fields.
+type Logger struct {
+	fields []string // key, value pairs in order of setting
+}
+
+// With derives logger from the root one, see Logger.With
+func With(kv ...string) *Logger {
+	return (*Logger)(nil).With(kv...)
+}
+
+// With derives logger adding fields given as key, value pairs. Value of
+// a known key is replaced, empty value removes the field.
+func (lg *Logger) With(kv ...string) *Logger {
+	d := &Logger{}
+	if lg != nil {
+		d.fields = append(d.fields, lg.fields...)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		d.set(kv[i], kv[i+1])
+	}
+	return d
+}
+
+func (lg *Logger) set(key, value string) {
+	for i := 0; i < len(lg.fields); i += 2 {
+		if lg.fields[i] != key {
+			continue
+		}
+		if value == "" {
+			lg.fields = append(lg.fields[:i], lg.fields[i+2:]...)
+		} else {
+			lg.fields[i+1] = value
+		}
+		return
+	}
+	if value != "" {
+		lg.fields = append(lg.fields, key, value)
+	}
+}
+
+func (lg *Logger) output(level Level, format string, args []interface{}) {
+	var fields []string
+	if lg != nil {
+		fields = lg.fields
+	}
+	std.output(level, fields, fmt.Sprintf(format, args...))
+}
+
+func (lg *Logger) Debugf(format string, args ...interface{}) { lg.output(DEBUG, format, args) }
+func (lg *Logger) Infof(format string, args ...interface{})  { lg.output(INFO, format, args) }
+func (lg *Logger) Warnf(format string, args ...interface{})  { lg.output(WARN, format, args) }
+func (lg *Logger) Errorf(format string, args ...interface{}) { lg.output(ERROR, format, args) }
+
+// records of the root logger
+func Debugf(format string, args ...interface{}) { std.output(DEBUG, nil, fmt.Sprintf(format, args...)) }
+func Infof(format string, args ...interface{})  { std.output(INFO, nil, fmt.Sprintf(format, args...)) }
+func Warnf(format string, args ...interface{})  { std.output(WARN, nil, fmt.Sprintf(format, args...)) }
+func Errorf(format string, args ...interface{}) { std.output(ERROR, nil, fmt.Sprintf(format, args...)) }
+
+// writer for the global log, one Write per message
+type bridge struct{}
+
+var prefixes = []struct {
+	prefix string
+	level  Level
+}{
+	{"[!] ", WARN},
+	{"[i] ", INFO},
+	{"[d] ", DEBUG},
+}
+
+func (bridge) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := INFO
+	for _, pl := range prefixes {
+		if strings.HasPrefix(msg, pl.prefix) {
+			msg, level = strings.TrimPrefix(msg, pl.prefix), pl.level
+			break
+		}
+	}
+	std.output(level, nil, msg)
+	return len(p), nil
+}
+
+func (lg *logger) output(level Level, fields []string, msg string) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	if level < lg.opts.Level {
+		return
+	}
+	var line []byte
+	now := time.Now().Format(time.RFC3339)
+	if lg.opts.Format == FORMAT_JSON {
+		line = formatJSON(now, level, fields, msg)
+	} else {
+		line = formatLogfmt(now, level, fields, msg)
+	}
+	if lg.file != nil {
+		_, err := lg.file.Write(line)
+		if err != nil && !lg.failed {
+			// reported once and to stderr only, log would loop
+			fmt.Fprintf(os.Stderr, "log file write failed: %s\n", err)
+		}
+		lg.failed = err != nil
+	}
+	if lg.opts.Console != nil {
+		lg.opts.Console.Write(line)
+	}
+}
+
+func formatJSON(now string, level Level, fields []string, msg string) []byte {
+	rec := make(map[string]string, len(fields)/2+3)
+	for i := 0; i+1 < len(fields); i += 2 {
+		rec[fields[i]] = fields[i+1]
+	}
+	rec["time"] = now
+	rec["level"] = level.String()
+	rec["msg"] = msg
+	data, _ := json.Marshal(rec) // keys are sorted
+	return append(data, '\n')
+}
+
+func formatLogfmt(now string, level Level, fields []string, msg string) []byte {
+	var b strings.Builder
+	b.WriteString("time=" + now + " level=" + level.String())
+	for i := 0; i+1 < len(fields); i += 2 {
+		b.WriteString(" " + fields[i] + "=" + logfmtValue(fields[i+1]))
+	}
+	b.WriteString(" msg=" + logfmtValue(msg) + "\n")
+	return []byte(b.String())
+}
+
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,192 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Log files are named by day as 20060102.log. The current file is
+// switched when the day changes and renamed to 20060102-150405.log when
+// it grows above max size. Files left behind are gzipped.
+type Rotator struct {
+	mu       sync.Mutex
+	dir      string
+	maxSize  int64
+	compress bool
+	f        *os.File
+	day      string
+	size     int64
+	wg       sync.WaitGroup // running compressions
+}
+
+var rxLogName = regexp.MustCompile(`^\d{8}(?:-\d{6}(?:-\d+)?)?\.log$`)
+
+func OpenRotator(dir string, maxSize int64, compress bool) (*Rotator, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &Rotator{dir: dir, maxSize: maxSize, compress: compress}
+	if err := r.open(time.Now()); err != nil {
+		return nil, err
+	}
+	if compress {
+		// leftovers of previous runs
+		fnames, err := filepath.Glob(filepath.Join(dir, "*.log"))
+		if err != nil {
+			return nil, err
+		}
+		for _, fname := range fnames {
+			if base := filepath.Base(fname); rxLogName.MatchString(base) && fname != r.Name() {
+				r.gzip(fname)
+			}
+		}
+	}
+	return r, nil
+}
+
+func dayName(dir, day string) string {
+	return filepath.Join(dir, day+".log")
+}
+
+// current log file
+func (r *Rotator) Name() string {
+	return dayName(r.dir, r.day)
+}
+
+func (r *Rotator) open(now time.Time) error {
+	r.day = now.Format("20060102")
+	f, err := os.OpenFile(r.Name(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// close current file, keep it under name (when not empty) and compress
+func (r *Rotator) retire(name string) error {
+	old := r.Name()
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	if name != "" {
+		if err := os.Rename(old, name); err != nil {
+			return err
+		}
+		old = name
+	}
+	if r.compress {
+		r.gzip(old)
+	}
+	return nil
+}
+
+// free name for file rotated by size
+func (r *Rotator) sizeName(now time.Time) string {
+	base := r.day + "-" + now.Format("150405")
+	name := filepath.Join(r.dir, base+".log")
+	for i := 1; ; i++ {
+		_, err1 := os.Stat(name)
+		_, err2 := os.Stat(name + ".gz")
+		if os.IsNotExist(err1) && os.IsNotExist(err2) {
+			return name
+		}
+		name = filepath.Join(r.dir, fmt.Sprintf("%s-%d.log", base, i))
+	}
+}
+
+func (r *Rotator) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	switch {
+	case r.f == nil:
+		if err := r.open(now); err != nil {
+			return 0, err
+		}
+	case now.Format("20060102") != r.day:
+		if err := r.retire(""); err != nil {
+			return 0, err
+		}
+		if err := r.open(now); err != nil {
+			return 0, err
+		}
+	case r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize:
+		if err := r.retire(r.sizeName(now)); err != nil {
+			return 0, err
+		}
+		if err := r.open(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close current file (it is not compressed, so next run continues it)
+// and wait for compressions.
+func (r *Rotator) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.f != nil {
+		err = r.f.Close()
+		r.f = nil
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
+
+// compress fname to fname.gz in background, errors go to stderr as
+// the log may be the failing part
+func (r *Rotator) gzip(fname string) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := gzipFile(fname); err != nil {
+			fmt.Fprintf(os.Stderr, "log %s not compressed: %s\n", fname, err)
+		}
+	}()
+}
+
+func gzipFile(fname string) error {
+	src, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmpname := fname + ".gz.tmp"
+	dst, err := os.OpenFile(tmpname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpname, fname+".gz")
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+	return os.Remove(fname)
+}
@@ -0,0 +1,274 @@
+package metrics
+
+// Application metrics in Prometheus text format. Packages define their
+// metrics as package variables, Handler serves all of them.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// default buckets of duration histograms, in seconds
+var DurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	mu      sync.Mutex
+	metrics []metric
+)
+
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, old := range metrics {
+		if old.name() == m.name() {
+			panic("metric " + m.name() + " registered twice")
+		}
+	}
+	metrics = append(metrics, m)
+}
+
+// values of metric by label values joined with "\xff"
+type vec struct {
+	mu     sync.Mutex
+	fname  string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (v *vec) name() string {
+	return v.fname
+}
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s wants %d label value(s), got %d", v.fname, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.fname, v.help, v.fname, v.kind)
+}
+
+// {a="x",b="y"} for label values of key, extra pair is appended if given
+func (v *vec) labelString(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+"="+quote(value))
+		}
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, extra[0]+"="+quote(extra[1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func formatValue(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter only grows
+type Counter struct {
+	vec
+	values map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec{fname: name, help: help, kind: "counter", labels: labels}, make(map[string]float64)}
+	register(c)
+	return c
+}
+
+func (c *Counter) Add(delta float64, values ...string) {
+	k := c.key(values)
+	c.mu.Lock()
+	c.values[k] += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.fname, c.labelString(k), formatValue(c.values[k]))
+	}
+}
+
+// Gauge is set to current value
+type Gauge struct {
+	vec
+	values map[string]float64
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec{fname: name, help: help, kind: "gauge", labels: labels}, make(map[string]float64)}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, values ...string) {
+	k := g.key(values)
+	g.mu.Lock()
+	g.values[k] = value
+	g.mu.Unlock()
+}
+
+// values by label values, for gauges computed from other ones
+func (g *Gauge) snapshot() map[string]float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m := make(map[string]float64, len(g.values))
+	for k, v := range g.values {
+		m[k] = v
+	}
+	return m
+}
+
+func (g *Gauge) write(w io.Writer) {
+	values := g.snapshot()
+	g.header(w)
+	for _, k := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.fname, g.labelString(k), formatValue(values[k]))
+	}
+}
+
+// GaugeFunc is computed from values of source gauge on every scrape
+type GaugeFunc struct {
+	vec
+	source *Gauge
+	fn     func(float64) float64
+}
+
+func NewGaugeFunc(name, help string, source *Gauge, fn func(float64) float64) *GaugeFunc {
+	g := &GaugeFunc{vec{fname: name, help: help, kind: "gauge", labels: source.labels}, source, fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	values := g.source.snapshot()
+	g.header(w)
+	for _, k := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.fname, g.labelString(k), formatValue(g.fn(values[k])))
+	}
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations by buckets
+type Histogram struct {
+	vec
+	buckets []float64
+	values  map[string]*histogram
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{vec{fname: name, help: help, kind: "histogram", labels: labels}, buckets, make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, values ...string) {
+	k := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[k]
+	if !ok {
+		hv = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hv
+	}
+	for i, le := range h.buckets {
+		if value <= le {
+			hv.counts[i]++
+			break
+		}
+	}
+	hv.count++
+	hv.sum += value
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv := h.values[k]
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += hv.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fname, h.labelString(k, "le", formatValue(le)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fname, h.labelString(k, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fname, h.labelString(k), formatValue(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fname, h.labelString(k), hv.count)
+	}
+}
+
+// write all metrics in text exposition format
+func WriteText(w io.Writer) {
+	mu.Lock()
+	all := append([]metric(nil), metrics...)
+	mu.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].name() < all[j].name() })
+	for _, m := range all {
+		m.write(w)
+	}
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
@@ -1,13 +1,16 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"path/filepath"
 	"sort"
-	"strings"
+	"time"
 
 	config "github.com/wowauc/gowowuction/config"
+	dedup "github.com/wowauc/gowowuction/dedup"
+	logging "github.com/wowauc/gowowuction/logging"
 	util "github.com/wowauc/gowowuction/util"
 )
 
@@ -36,82 +39,160 @@ func ProcessSnapshot(ss *SnapshotData) {
 	}
 }
 
-func ParseDir(cf *config.Config, realm string, safe bool) {
-	mask := cf.DownloadDirectory +
-		strings.Replace(realm, ":", "-", -1) + "-*.json.gz"
-	log.Printf("scan by mask %s ...", mask)
-	fnames, err := filepath.Glob(mask)
-	if err != nil {
-		log.Fatalln("glob failed:", err)
+var (
+	ErrForeignRealm = errors.New("not my realm")
+	ErrIllNamed     = errors.New("not parsed correctly")
+)
+
+// files skipped by ParseDir with their errors
+type BadFilesError struct {
+	Files map[string]error
+}
+
+func (e *BadFilesError) Error() string {
+	return fmt.Sprintf("%d files with errors", len(e.Files))
+}
+
+// sorted snapshot files of realm, ill-named ones are added to badfiles
+func ListSnapshots(cf *config.Config, realm string, badfiles map[string]error) ([]string, error) {
+	lg := logging.With("realm", realm)
+	var fnames []string
+	for _, mask := range util.Make_FMasks(realm) {
+		lg.Debugf("scan by mask %s ...", mask)
+		found, err := filepath.Glob(cf.DownloadDirectory + mask)
+		if err != nil {
+			return nil, fmt.Errorf("glob failed: %w", err)
+		}
+		fnames = append(fnames, found...)
 	}
-	log.Printf("... %d entries collected", len(fnames))
+	lg.Infof("... %d entries collected", len(fnames))
 
 	var goodfnames []string
-
 	for _, fname := range fnames {
-		// realm, ts, good := util.Parse_FName(fname)
-		_, _, good := util.Parse_FName(fname)
-		if good {
-			// log.Printf("fname %s -> %s, %v", fname, realm, ts)
+		f_realm, _, good := util.Parse_FName(fname)
+		switch {
+		case !good:
+			badfiles[fname] = ErrIllNamed
+		case f_realm != realm:
+			// legacy mask of eu:twisting matches eu-twisting-nether files
+			lg.Infof("skip %s: %s (%s != %s)", fname, ErrForeignRealm, f_realm, realm)
+		default:
 			goodfnames = append(goodfnames, fname)
-		} else {
-			// log.Printf("skip fname %s", fname)
 		}
 	}
 	sort.Sort(util.ByBasename(goodfnames))
-	prc := new(AuctionProcessor)
-	prc.Init(cf, realm)
-	prc.LoadState()
-	badfiles := make(map[string]string)
+	return goodfnames, nil
+}
 
-	for _, fname := range fnames {
-		//log.Println(fname)
-		f_realm, f_time, ok := util.Parse_FName(fname)
-		if !ok {
-			log.Fatalf("not parsed correctly: %s", fname)
-			continue
-		}
-		if f_realm != realm {
-			log.Fatalf("not my realm (%s != %s)", f_realm, realm)
-			continue
+// feed snapshot to processor. load and parse errors are
+// collected to badfiles, processor errors are returned
+func processSnapshot(prc *AuctionProcessor, ref *SnapshotRef, data []byte, err error, badfiles map[string]error) error {
+	if err != nil {
+		prc.Log.Warnf("%s LOAD ERROR: %s", ref, err)
+		badfiles[ref.String()] = err
+		return nil
+	}
+	ss, err := ParseSnapshot(data)
+	if err != nil {
+		prc.Log.Warnf("%s PARSE ERROR: %s", ref, err)
+		badfiles[ref.String()] = err
+		return nil
+	}
+	if prc.Hashes != nil {
+		orig, dup, err := prc.Hashes.Check(ref.Time, data)
+		if err != nil {
+			prc.Log.Warnf("%s HASH ERROR: %s", ref, err)
+			badfiles[ref.String()] = err
+			return nil
 		}
-		if !prc.SnapshotNeeded(f_time) {
-			log.Printf("snapshot not needed: %s", util.TSStr(f_time))
-			continue
+		if dup {
+			prc.Log.Infof("%s is a duplicate of %s, skipped", ref, util.TSStr(orig))
+			return nil
 		}
-		data, err := util.Load(fname)
-		if err != nil {
-			//log.Fatalf("load error: %s", err)
-			log.Printf("%s LOAD ERROR: %s", fname, err)
-			badfiles[fname] = fmt.Sprint(err)
-			continue
+	}
+
+	if err := prc.StartSnapshot(ref.Time); err != nil {
+		return err
+	}
+	for _, auc := range ss.Auctions {
+		if err := prc.AddAuctionEntry(&auc); err != nil {
+			return err
 		}
-		ss, err := ParseSnapshot(data)
-		if err != nil {
-			//log.Fatalf("load error: %s", err)
-			log.Printf("%s PARSE ERROR: %s", fname, err)
-			badfiles[fname] = fmt.Sprint(err)
+	}
+	return prc.FinishSnapshot()
+}
+
+func reportBadFiles(badfiles map[string]error, lg *logging.Logger) error {
+	if len(badfiles) == 0 {
+		lg.Infof("all files loaded without errors")
+		return nil
+	}
+	lg.Warnf("%d files with errors", len(badfiles))
+	for fname, err := range badfiles {
+		lg.Warnf("%s: %s", fname, err)
+	}
+	return &BadFilesError{badfiles}
+}
+
+func ParseDir(cf *config.Config, realm string, safe bool, lg *logging.Logger) error {
+	started := time.Now()
+	defer func() { parseDuration.Observe(time.Since(started).Seconds(), realm) }()
+	if err := RecoverReparse(cf, realm, lg); err != nil {
+		return err
+	}
+	badfiles := make(map[string]error)
+	prc := new(AuctionProcessor)
+	prc.Init(cf, realm)
+	prc.Log = lg.With("realm", realm)
+	if err := prc.LoadState(); err != nil {
+		return err
+	}
+	store, err := OpenStore(cf, realm, prc.ResultDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	prc.Store = store
+	hashes, err := dedup.Open(cf.DownloadDirectory, realm)
+	if err != nil {
+		return err
+	}
+	prc.Hashes = hashes
+	refs, err := ListSnapshotRefs(cf, realm, prc.State.LastTime, badfiles)
+	if err != nil {
+		return err
+	}
+
+	var needed []SnapshotRef
+	for _, ref := range refs {
+		if !prc.SnapshotNeeded(ref.Time) {
+			prc.Log.Infof("snapshot not needed: %s", util.TSStr(ref.Time))
 			continue
 		}
-
-		prc.StartSnapshot(f_time)
-		for _, auc := range ss.Auctions {
-			prc.AddAuctionEntry(&auc)
+		needed = append(needed, ref)
+	}
+	err = EachSnapshot(needed, func(ref *SnapshotRef, data []byte, err error) error {
+		if err := processSnapshot(prc, ref, data, err, badfiles); err != nil {
+			return err
 		}
-		prc.FinishSnapshot()
 		if safe {
-			prc.SaveState()
+			if err := hashes.Save(); err != nil {
+				return err
+			}
+			return prc.SaveState()
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	if !safe {
-		prc.SaveState()
-	}
-	if len(badfiles) == 0 {
-		log.Printf("all files loaded without errors")
-	} else {
-		log.Printf("%d files with errors", len(badfiles))
-		for fname, err := range badfiles {
-			log.Printf("%s: %s", fname, err)
+		if err := hashes.Save(); err != nil {
+			return err
+		}
+		if err := prc.SaveState(); err != nil {
+			return err
 		}
 	}
+	return reportBadFiles(badfiles, prc.Log)
 }
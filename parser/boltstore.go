@@ -0,0 +1,488 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+)
+
+// BoltStore keeps results in an embedded database file. Closed auctions
+// and their outcomes are keyed by closing time and auction id, with
+// indexes by item and by owner. Item statistics are updated as auctions
+// are added. The file is opened for the time of a write transaction or
+// a query only, so parser and readers (like api) may share it.
+//
+// buckets:
+//
+//	auctions  time|auc -> Auction
+//	outcomes  time|auc -> AuctionMeta
+//	summaries time -> SnapshotSummary
+//	items     item -> bucket of date -> ItemStat
+//	by_item   item|time|auc -> empty
+//	by_owner  owner\0ownerRealm\0time|auc -> empty
+type BoltStore struct {
+	fname string
+	db    *bolt.DB
+	tx    *bolt.Tx // write transaction between Begin and Commit
+}
+
+const (
+	BOLT_WRITE_TIMEOUT = 30 * time.Second
+	BOLT_READ_TIMEOUT  = 5 * time.Second
+	IMPORT_BATCH       = 10000 // closed auctions per transaction on import
+)
+
+var (
+	bktAuctions  = []byte("auctions")
+	bktOutcomes  = []byte("outcomes")
+	bktSummaries = []byte("summaries")
+	bktItems     = []byte("items")
+	bktByItem    = []byte("by_item")
+	bktByOwner   = []byte("by_owner")
+)
+
+func NewBoltStore(fname string) *BoltStore {
+	return &BoltStore{fname: fname}
+}
+
+func (st *BoltStore) FileName() string {
+	return st.fname
+}
+
+// zero time (open bound) is before any other
+func timeKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	if !t.IsZero() {
+		binary.BigEndian.PutUint64(k, uint64(t.Unix()))
+	}
+	return k
+}
+
+func keyTime(k []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(k[:8])), 0).UTC()
+}
+
+func closedKey(c *ClosedAuction) []byte {
+	k := timeKey(c.Meta.Closed)
+	return binary.BigEndian.AppendUint64(k, uint64(c.Meta.Auc))
+}
+
+func itemKey(item int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(item))
+}
+
+func ownerPrefix(owner, realm string) []byte {
+	k := []byte(owner + "\x00")
+	if realm != "" {
+		k = append(k, realm+"\x00"...)
+	}
+	return k
+}
+
+func (st *BoltStore) Begin(snaptime time.Time) error {
+	db, err := bolt.Open(st.fname, 0644, &bolt.Options{Timeout: BOLT_WRITE_TIMEOUT})
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin(true)
+	if err == nil {
+		for _, name := range [][]byte{bktAuctions, bktOutcomes, bktSummaries, bktItems, bktByItem, bktByOwner} {
+			if _, err = tx.CreateBucketIfNotExists(name); err != nil {
+				tx.Rollback()
+				break
+			}
+		}
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+	st.db, st.tx = db, tx
+	return nil
+}
+
+func putJSON(b *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// store closed auction with its indexes unless it is already stored
+func putClosed(tx *bolt.Tx, c *ClosedAuction, stats bool) (added bool, err error) {
+	key := closedKey(c)
+	auctions := tx.Bucket(bktAuctions)
+	if auctions.Get(key) != nil {
+		return false, nil
+	}
+	if err := putJSON(auctions, key, c.Entry); err != nil {
+		return false, err
+	}
+	if err := putJSON(tx.Bucket(bktOutcomes), key, c.Meta); err != nil {
+		return false, err
+	}
+	if err := tx.Bucket(bktByItem).Put(append(itemKey(c.Entry.Item), key...), nil); err != nil {
+		return false, err
+	}
+	owner_key := append(ownerPrefix(c.Entry.Owner, c.Entry.OwnerRealm), key...)
+	if err := tx.Bucket(bktByOwner).Put(owner_key, nil); err != nil {
+		return false, err
+	}
+	if stats {
+		return true, addStat(tx, c)
+	}
+	return true, nil
+}
+
+func addStat(tx *bolt.Tx, c *ClosedAuction) error {
+	b, err := tx.Bucket(bktItems).CreateBucketIfNotExists(itemKey(c.Entry.Item))
+	if err != nil {
+		return err
+	}
+	date := statDate(c.Meta.Closed)
+	s := ItemStat{Item: c.Entry.Item, Date: date}
+	if data := b.Get([]byte(date)); data != nil {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	s.add(c)
+	return putJSON(b, []byte(date), s)
+}
+
+func (st *BoltStore) AddClosed(c *ClosedAuction) error {
+	_, err := putClosed(st.tx, c, true)
+	return err
+}
+
+func (st *BoltStore) AddSummary(s *SnapshotSummary) error {
+	return putJSON(st.tx.Bucket(bktSummaries), timeKey(s.Time), s)
+}
+
+func (st *BoltStore) Commit() error {
+	err := st.tx.Commit()
+	if cerr := st.db.Close(); err == nil {
+		err = cerr
+	}
+	st.db, st.tx = nil, nil
+	return err
+}
+
+func (st *BoltStore) Rollback() {
+	if st.tx == nil {
+		return
+	}
+	st.tx.Rollback()
+	st.db.Close()
+	st.db, st.tx = nil, nil
+}
+
+func (st *BoltStore) Close() error {
+	st.Rollback()
+	return nil
+}
+
+// run fn in read transaction, missing file is an empty store
+func (st *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	if st.tx != nil {
+		return fn(st.tx)
+	}
+	if _, err := os.Stat(st.fname); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := bolt.Open(st.fname, 0644, &bolt.Options{Timeout: BOLT_READ_TIMEOUT, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bktAuctions) == nil {
+			return nil // not written yet
+		}
+		return fn(tx)
+	})
+}
+
+func getClosed(tx *bolt.Tx, key []byte) (*ClosedAuction, error) {
+	var c ClosedAuction
+	if err := json.Unmarshal(tx.Bucket(bktAuctions).Get(key), &c.Entry); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(tx.Bucket(bktOutcomes).Get(key), &c.Meta); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// keys of closed auctions in index by prefix. With time_ordered the time
+// part goes right after prefix, so it is used to seek and stop.
+func indexKeys(b *bolt.Bucket, prefix []byte, q *Query, time_ordered bool) [][]byte {
+	var keys [][]byte
+	start := prefix
+	if time_ordered {
+		start = append(append([]byte(nil), prefix...), timeKey(q.From)...)
+	}
+	cur := b.Cursor()
+	for k, _ := cur.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+		key := k[len(k)-16:]
+		if ts := keyTime(key); ts.Before(q.From) || (!q.To.IsZero() && ts.After(q.To)) {
+			if time_ordered {
+				break
+			}
+			continue
+		}
+		keys = append(keys, append([]byte(nil), key...))
+	}
+	return keys
+}
+
+func (st *BoltStore) EachClosed(q *Query, fn func(c *ClosedAuction) error) error {
+	return st.view(func(tx *bolt.Tx) error {
+		var keys [][]byte
+		switch {
+		case q.Item != 0:
+			keys = indexKeys(tx.Bucket(bktByItem), itemKey(q.Item), q, true)
+		case q.Owner != "":
+			// without realm keys of several realms are mixed
+			keys = indexKeys(tx.Bucket(bktByOwner), ownerPrefix(q.Owner, q.OwnerRealm), q, q.OwnerRealm != "")
+			sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		default:
+			cur := tx.Bucket(bktAuctions).Cursor()
+			for k, _ := cur.Seek(timeKey(q.From)); k != nil; k, _ = cur.Next() {
+				if !q.To.IsZero() && keyTime(k).After(q.To) {
+					break
+				}
+				c, err := getClosed(tx, k)
+				if err != nil {
+					return err
+				}
+				if !q.match(c) {
+					continue
+				}
+				if err := fn(c); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, key := range keys {
+			c, err := getClosed(tx, key)
+			if err != nil {
+				return err
+			}
+			if !q.match(c) {
+				continue
+			}
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (st *BoltStore) EachSummary(fn func(s *SnapshotSummary) error) error {
+	return st.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bktSummaries).ForEach(func(k, v []byte) error {
+			var s SnapshotSummary
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			return fn(&s)
+		})
+	})
+}
+
+func (st *BoltStore) LastSummary() (*SnapshotSummary, error) {
+	var s *SnapshotSummary
+	err := st.view(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(bktSummaries).Cursor().Last()
+		if v == nil {
+			return nil
+		}
+		s = new(SnapshotSummary)
+		return json.Unmarshal(v, s)
+	})
+	return s, err
+}
+
+// statistics for whole days of from and to
+func (st *BoltStore) ItemStats(item int64, from, to time.Time) ([]ItemStat, error) {
+	r := []ItemStat{}
+	err := st.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bktItems).Bucket(itemKey(item))
+		if b == nil {
+			return nil
+		}
+		cur := b.Cursor()
+		for k, v := cur.Seek([]byte(statDate(from))); k != nil; k, v = cur.Next() {
+			if !to.IsZero() && string(k) > statDate(to) {
+				break
+			}
+			var s ItemStat
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			r = append(r, s)
+		}
+		return nil
+	})
+	return r, err
+}
+
+// ReplaceRange replaces results closed in [from, to] by all results of
+// src, as done by Reparse. Item statistics of touched days are rebuilt.
+func (st *BoltStore) ReplaceRange(from, to time.Time, src *BoltStore, lg *logging.Logger) error {
+	if err := st.Begin(from); err != nil {
+		return err
+	}
+	defer st.Rollback()
+	tx := st.tx
+	q := &Query{From: from, To: to}
+	var keys [][]byte
+	cur := tx.Bucket(bktAuctions).Cursor()
+	for k, _ := cur.Seek(timeKey(from)); k != nil && !keyTime(k).After(to); k, _ = cur.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, key := range keys {
+		c, err := getClosed(tx, key)
+		if err != nil {
+			return err
+		}
+		for _, del := range []struct {
+			bkt []byte
+			key []byte
+		}{
+			{bktAuctions, key},
+			{bktOutcomes, key},
+			{bktByItem, append(itemKey(c.Entry.Item), key...)},
+			{bktByOwner, append(ownerPrefix(c.Entry.Owner, c.Entry.OwnerRealm), key...)},
+		} {
+			if err := tx.Bucket(del.bkt).Delete(del.key); err != nil {
+				return err
+			}
+		}
+	}
+	summaries := tx.Bucket(bktSummaries)
+	var skeys [][]byte
+	scur := summaries.Cursor()
+	for k, _ := scur.Seek(timeKey(from)); k != nil && !keyTime(k).After(to); k, _ = scur.Next() {
+		skeys = append(skeys, append([]byte(nil), k...))
+	}
+	for _, k := range skeys {
+		if err := summaries.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	added := 0
+	err := src.EachClosed(q, func(c *ClosedAuction) error {
+		_, err := putClosed(tx, c, false)
+		added++
+		return err
+	})
+	if err == nil {
+		err = src.EachSummary(func(s *SnapshotSummary) error {
+			return putJSON(summaries, timeKey(s.Time), s)
+		})
+	}
+	if err == nil {
+		err = rebuildStats(tx, from, to)
+	}
+	if err != nil {
+		return err
+	}
+	lg.Infof("%s: %d closed auction(s) replaced by %d", st.fname, len(keys), added)
+	return st.Commit()
+}
+
+// recount item statistics for days of [from, to]
+func rebuildStats(tx *bolt.Tx, from, to time.Time) error {
+	d0, d1 := statDate(from), statDate(to)
+	items := tx.Bucket(bktItems)
+	err := items.ForEach(func(name, _ []byte) error {
+		b := items.Bucket(name)
+		var dates [][]byte
+		cur := b.Cursor()
+		for k, _ := cur.Seek([]byte(d0)); k != nil && string(k) <= d1; k, _ = cur.Next() {
+			dates = append(dates, append([]byte(nil), k...))
+		}
+		for _, k := range dates {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	day0, _ := time.Parse(STAT_DATE, d0)
+	day1, _ := time.Parse(STAT_DATE, d1)
+	day1 = day1.Add(24*time.Hour - time.Second)
+	cur := tx.Bucket(bktAuctions).Cursor()
+	for k, _ := cur.Seek(timeKey(day0)); k != nil && !keyTime(k).After(day1); k, _ = cur.Next() {
+		c, err := getClosed(tx, k)
+		if err != nil {
+			return err
+		}
+		if err := addStat(tx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportJSONL copies results of realm from json lines files of its result
+// directory to its bolt store. Already stored auctions are skipped, so
+// import may be repeated.
+func ImportJSONL(cf *config.Config, realm string, lg *logging.Logger) (closed, summaries int, err error) {
+	lg = lg.With("realm", realm)
+	dir := cf.Realm(realm).ResultDirectory
+	src := NewJSONLStore(cf, realm, dir)
+	dst := NewBoltStore(BoltName(cf, realm, dir))
+	lg.Infof("import results of %s from %s to %s", realm, dir, dst.FileName())
+	if err := dst.Begin(time.Time{}); err != nil {
+		return 0, 0, err
+	}
+	defer dst.Rollback()
+	seen := 0
+	err = src.EachClosed(&Query{}, func(c *ClosedAuction) error {
+		added, err := putClosed(dst.tx, c, true)
+		if err != nil {
+			return err
+		}
+		if added {
+			closed++
+		}
+		if seen++; seen%IMPORT_BATCH == 0 {
+			lg.Infof("... %d of %d closed auction(s) imported", closed, seen)
+			if err := dst.Commit(); err != nil {
+				return err
+			}
+			return dst.Begin(time.Time{})
+		}
+		return nil
+	})
+	if err != nil {
+		return closed, 0, err
+	}
+	err = src.EachSummary(func(s *SnapshotSummary) error {
+		summaries++
+		return dst.AddSummary(s)
+	})
+	if err != nil {
+		return closed, summaries, err
+	}
+	lg.Infof("%d of %d closed auction(s) and %d summaries imported", closed, seen, summaries)
+	return closed, summaries, dst.Commit()
+}
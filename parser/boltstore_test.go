@@ -0,0 +1,208 @@
+package parser
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// results of one snapshot
+type testResults struct {
+	summary SnapshotSummary
+	closed  []ClosedAuction
+}
+
+// results of snapshots every 6 hours since ts, n closed auctions in
+// each. Auctions of several items and owners of two realms are mixed.
+func makeResults(ts time.Time, snapshots, n int, auc int64) []testResults {
+	var r []testResults
+	for i := 0; i < snapshots; i++ {
+		res := testResults{summary: SnapshotSummary{Time: ts, Closed: n}}
+		for j := 0; j < n; j++ {
+			var c ClosedAuction
+			c.Entry.Auc = auc
+			c.Entry.Item = 10 + auc%3
+			c.Entry.Owner = []string{"Foo", "Bar"}[auc%2]
+			c.Entry.OwnerRealm = []string{"Fordragon", "Silvermoon"}[auc/2%2]
+			c.Entry.Quantity = int32(1 + auc%5)
+			c.Meta = AuctionMeta{Auc: auc, Opened: ts.Add(-time.Hour), Closed: ts, Result: "sold", Profit: 100 * auc}
+			if auc%4 == 0 {
+				c.Meta.Result, c.Meta.Profit = "expired", 0
+			}
+			res.closed = append(res.closed, c)
+			auc++
+		}
+		r = append(r, res)
+		ts = ts.Add(6 * time.Hour)
+	}
+	return r
+}
+
+func fillStore(t *testing.T, st Store, results []testResults) {
+	t.Helper()
+	for _, res := range results {
+		if err := st.Begin(res.summary.Time); err != nil {
+			t.Fatal(err)
+		}
+		for i := range res.closed {
+			if err := st.AddClosed(&res.closed[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := st.AddSummary(&res.summary); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func queryStore(t *testing.T, st Store, q *Query) []ClosedAuction {
+	t.Helper()
+	var r []ClosedAuction
+	err := st.EachClosed(q, func(c *ClosedAuction) error {
+		r = append(r, *c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func summaries(t *testing.T, st Store) []SnapshotSummary {
+	t.Helper()
+	var r []SnapshotSummary
+	err := st.EachSummary(func(s *SnapshotSummary) error {
+		r = append(r, *s)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func day(d int) time.Time {
+	return time.Date(2016, 2, d, 0, 0, 0, 0, time.UTC)
+}
+
+// queries by index start and stop inside data
+var testQueries = []Query{
+	{},
+	{From: day(2), To: day(3)},
+	{Item: 11},
+	{Item: 11, From: day(2).Add(3 * time.Hour), To: day(3)},
+	{Item: 12, To: day(2)},
+	{Item: 99},
+	{Owner: "Foo"},
+	{Owner: "Foo", From: day(2), To: day(3).Add(-time.Second)},
+	{Owner: "Bar", OwnerRealm: "Silvermoon"},
+	{Owner: "Bar", OwnerRealm: "Silvermoon", From: day(2).Add(time.Hour), To: day(3)},
+	{Owner: "Foo", OwnerRealm: "Fordragon", Item: 10},
+	{Owner: "Nobody"},
+}
+
+// compare results of both stores with the expected ones
+func checkStore(t *testing.T, st, want Store) {
+	t.Helper()
+	for _, q := range testQueries {
+		got, exp := queryStore(t, st, &q), queryStore(t, want, &q)
+		if len(exp) == 0 && q.Item != 99 && q.Owner != "Nobody" {
+			t.Fatalf("query %+v: no data", q)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("query %+v: %d auction(s), want %d", q, len(got), len(exp))
+		}
+	}
+	if got, exp := summaries(t, st), summaries(t, want); !reflect.DeepEqual(got, exp) {
+		t.Errorf("%d summaries, want %d", len(got), len(exp))
+	}
+	for item := int64(10); item < 13; item++ {
+		got, err := st.ItemStats(item, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, err := want.ItemStats(item, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("stats of %d: %+v, want %+v", item, got, exp)
+		}
+	}
+}
+
+func TestBoltQuery(t *testing.T) {
+	cf := testConfig(t)
+	results := makeResults(day(1), 12, 7, 1)
+	jst := NewJSONLStore(cf, testRealm, cf.ResultDirectory)
+	bst := NewBoltStore(BoltName(cf, testRealm, cf.ResultDirectory))
+	fillStore(t, jst, results)
+	fillStore(t, bst, results)
+	checkStore(t, bst, jst)
+
+	last, err := bst.LastSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := results[len(results)-1].summary; last == nil || !reflect.DeepEqual(*last, want) {
+		t.Errorf("last summary %+v, want %+v", last, want)
+	}
+}
+
+func TestBoltReplaceRange(t *testing.T) {
+	dir := t.TempDir()
+	old := makeResults(day(1), 12, 5, 1) // days 1-3
+	// day 2 rebuilt with other auctions, one snapshot less
+	rebuilt := makeResults(day(2), 3, 4, 1000)
+	from, to := day(2), day(3).Add(-time.Second)
+
+	live := NewBoltStore(filepath.Join(dir, "live.db"))
+	fillStore(t, live, old)
+	src := NewBoltStore(filepath.Join(dir, "src.db"))
+	fillStore(t, src, rebuilt)
+	if err := live.ReplaceRange(from, to, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := NewBoltStore(filepath.Join(dir, "want.db"))
+	fillStore(t, want, old[:4])
+	fillStore(t, want, rebuilt)
+	fillStore(t, want, old[8:])
+	checkStore(t, live, want)
+}
+
+func TestImportJSONL(t *testing.T) {
+	cf := testConfig(t)
+	// more than a transaction of import, the last one is partial
+	per := IMPORT_BATCH/12 + 1
+	results := makeResults(day(1), 12, per, 1)
+	jst := NewJSONLStore(cf, testRealm, cf.ResultDirectory)
+	fillStore(t, jst, results)
+	n := 12 * per
+
+	closed, sums, err := ImportJSONL(cf, testRealm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed != n || sums != 12 {
+		t.Errorf("%d closed and %d summaries imported, want %d and 12", closed, sums, n)
+	}
+	bst := NewBoltStore(BoltName(cf, testRealm, cf.ResultDirectory))
+	if got := queryStore(t, bst, &Query{}); !reflect.DeepEqual(got, queryStore(t, jst, &Query{})) {
+		t.Errorf("%d closed auction(s) imported", len(got))
+	}
+
+	// repeated import adds nothing
+	closed, _, err = ImportJSONL(cf, testRealm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed != 0 {
+		t.Errorf("%d closed auction(s) imported again", closed)
+	}
+	checkStore(t, bst, jst)
+}
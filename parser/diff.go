@@ -0,0 +1,165 @@
+package parser
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+)
+
+// field-level change of an auction present in both snapshots
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type ChangedAuction struct {
+	Auc     int64         `json:"auc"`
+	Item    int64         `json:"item"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// SnapshotDiff is a transition between two snapshots of realm. Removed
+// auctions carry outcomes and Summary counters as the processor
+// computes them when the old snapshot is the first one it sees, so
+// bought/auctioned/expired split may differ from results of a long
+// run, which knows earlier bids and deadlines.
+type SnapshotDiff struct {
+	Realm   string           `json:"realm"`
+	Old     time.Time        `json:"old"`
+	New     time.Time        `json:"new"`
+	Added   []Auction        `json:"added"`
+	Removed []ClosedAuction  `json:"removed"`
+	Changed []ChangedAuction `json:"changed"`
+	Summary *SnapshotSummary `json:"summary"`
+}
+
+// fields compared by DiffSnapshots
+func auctionChanges(a, b *Auction) []FieldChange {
+	var r []FieldChange
+	add := func(field string, va, vb interface{}) {
+		if va != vb {
+			r = append(r, FieldChange{field, va, vb})
+		}
+	}
+	add("item", a.Item, b.Item)
+	add("owner", a.Owner, b.Owner)
+	add("ownerRealm", a.OwnerRealm, b.OwnerRealm)
+	add("bid", a.Bid, b.Bid)
+	add("buyout", a.Buyout, b.Buyout)
+	add("quantity", a.Quantity, b.Quantity)
+	add("timeLeft", a.TimeLeft, b.TimeLeft)
+	return r
+}
+
+// memStore keeps results of diff processor in memory
+type memStore struct {
+	closed  []ClosedAuction
+	summary *SnapshotSummary
+}
+
+func (st *memStore) Begin(snaptime time.Time) error {
+	st.closed, st.summary = nil, nil
+	return nil
+}
+
+func (st *memStore) AddClosed(c *ClosedAuction) error {
+	st.closed = append(st.closed, *c)
+	return nil
+}
+
+func (st *memStore) AddSummary(s *SnapshotSummary) error {
+	st.summary = s
+	return nil
+}
+
+func (st *memStore) Commit() error { return nil }
+func (st *memStore) Rollback()     {}
+func (st *memStore) Close() error  { return nil }
+
+func (st *memStore) EachClosed(q *Query, fn func(c *ClosedAuction) error) error {
+	for i := range st.closed {
+		if !q.match(&st.closed[i]) {
+			continue
+		}
+		if err := fn(&st.closed[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *memStore) EachSummary(fn func(s *SnapshotSummary) error) error {
+	if st.summary == nil {
+		return nil
+	}
+	return fn(st.summary)
+}
+
+func (st *memStore) LastSummary() (*SnapshotSummary, error) {
+	return st.summary, nil
+}
+
+func (st *memStore) ItemStats(item int64, from, to time.Time) ([]ItemStat, error) {
+	return nil, nil
+}
+
+// DiffSnapshots feeds snapshots older and newer of realm to a fresh
+// processor and compares their auctions. Pet results of the processor
+// go to a temporary directory and are dropped.
+func DiffSnapshots(cf *config.Config, realm string, older, newer *SnapshotData, old_time, new_time time.Time) (*SnapshotDiff, error) {
+	if err := os.MkdirAll(cf.TempDirectory, 0755); err != nil {
+		return nil, err
+	}
+	tmpdir, err := os.MkdirTemp(cf.TempDirectory, "diff-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	store := &memStore{}
+	var prc AuctionProcessor
+	prc.Init(cf, realm)
+	prc.ResultDir = tmpdir + string(os.PathSeparator)
+	prc.Store = store
+	for _, s := range []struct {
+		data *SnapshotData
+		time time.Time
+	}{{older, old_time}, {newer, new_time}} {
+		if err := prc.StartSnapshot(s.time); err != nil {
+			return nil, err
+		}
+		for i := range s.data.Auctions {
+			if err := prc.AddAuctionEntry(&s.data.Auctions[i]); err != nil {
+				return nil, err
+			}
+		}
+		if err := prc.FinishSnapshot(); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &SnapshotDiff{Realm: realm, Old: old_time, New: new_time, Summary: store.summary}
+	old_set := make(map[int64]*Auction, len(older.Auctions))
+	for i := range older.Auctions {
+		old_set[older.Auctions[i].Auc] = &older.Auctions[i]
+	}
+	for i := range newer.Auctions {
+		b := &newer.Auctions[i]
+		a, found := old_set[b.Auc]
+		if !found {
+			d.Added = append(d.Added, *b)
+			continue
+		}
+		if changes := auctionChanges(a, b); changes != nil {
+			d.Changed = append(d.Changed, ChangedAuction{b.Auc, b.Item, changes})
+		}
+	}
+	d.Removed = store.closed
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].Auc < d.Added[j].Auc })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].Entry.Auc < d.Removed[j].Entry.Auc })
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Auc < d.Changed[j].Auc })
+	return d, nil
+}
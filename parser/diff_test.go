@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	util "github.com/wowauc/gowowuction/util"
+)
+
+func testAuction(auc, item int64, owner string, bid int64, tl TimeLeft) Auction {
+	var a Auction
+	a.Auc, a.Item, a.Owner, a.OwnerRealm = auc, item, owner, "Fordragon"
+	a.Bid, a.Buyout, a.Quantity, a.TimeLeft = bid, 1000, 1, tl
+	return a
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	cf := testConfig(t)
+	realms := []Realm{{"Fordragon", "fordragon"}}
+	older := &SnapshotData{Realms: realms, Auctions: []Auction{
+		testAuction(1, 10, "Foo", 100, LONG),
+		testAuction(2, 20, "Bar", 200, SHORT),
+		testAuction(3, 30, "Foo", 300, VERY_LONG),
+	}}
+	newer := &SnapshotData{Realms: realms, Auctions: []Auction{
+		testAuction(4, 40, "Bar", 400, VERY_LONG),
+		testAuction(1, 10, "Foo", 150, MEDIUM),
+		testAuction(3, 30, "Baz", 300, VERY_LONG),
+	}}
+	old_time := time.Date(2016, 2, 10, 12, 0, 0, 0, time.UTC)
+	new_time := old_time.Add(30 * time.Minute)
+
+	d, err := DiffSnapshots(cf, testRealm, older, newer, old_time, new_time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Added) != 1 || d.Added[0].Auc != 4 {
+		t.Errorf("added %+v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Entry.Auc != 2 || !d.Removed[0].Meta.Closed.Equal(new_time) {
+		t.Errorf("removed %+v", d.Removed)
+	}
+	want := []ChangedAuction{
+		{1, 10, []FieldChange{{"bid", int64(100), int64(150)}, {"timeLeft", LONG, MEDIUM}}},
+		{3, 30, []FieldChange{{"owner", "Foo", "Baz"}}},
+	}
+	if !reflect.DeepEqual(d.Changed, want) {
+		t.Errorf("changed %+v, want %+v", d.Changed, want)
+	}
+
+	// the same snapshots parsed as usual
+	for _, s := range []struct {
+		data *SnapshotData
+		time time.Time
+	}{{older, old_time}, {newer, new_time}} {
+		data, err := json.Marshal(s.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := util.Store(cf.DownloadDirectory+util.Make_FName(testRealm, s.time, true), util.Zip(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ParseDir(cf, testRealm, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := NewJSONLStore(cf, testRealm, cf.ResultDirectory).LastSummary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Summary == nil || d.Summary.Closed != 1 || summary == nil || !reflect.DeepEqual(*d.Summary, *summary) {
+		t.Errorf("summary %+v, parsed %+v", d.Summary, summary)
+	}
+}
@@ -0,0 +1,230 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+)
+
+// JSONLStore appends results to timed "auctions", "metadata" and
+// "snapshot" files (see TimedNameFormat). Every query is a full scan,
+// item statistics are computed on the fly.
+type JSONLStore struct {
+	cf    *config.Config
+	realm string
+	dir   string
+	fauc  *os.File
+	fmeta *os.File
+	fsnap *os.File
+}
+
+func NewJSONLStore(cf *config.Config, realm, dir string) *JSONLStore {
+	return &JSONLStore{cf: cf, realm: realm, dir: dir}
+}
+
+func (st *JSONLStore) Begin(snaptime time.Time) (err error) {
+	name := func(name string) string {
+		return st.dir + st.cf.GetTimedName(name, st.realm, snaptime)
+	}
+	if st.fauc, err = OpenOrCreateFile(name("auctions")); err != nil {
+		return err
+	}
+	if st.fmeta, err = OpenOrCreateFile(name("metadata")); err != nil {
+		st.Rollback()
+		return err
+	}
+	if st.fsnap, err = OpenOrCreateFile(name("snapshot")); err != nil {
+		st.Rollback()
+		return err
+	}
+	return nil
+}
+
+func (st *JSONLStore) AddClosed(c *ClosedAuction) error {
+	if err := writeJSONLine(st.fauc, c.Entry); err != nil {
+		return err
+	}
+	return writeJSONLine(st.fmeta, c.Meta)
+}
+
+func (st *JSONLStore) AddSummary(s *SnapshotSummary) error {
+	_, err := st.fsnap.WriteString(s.String() + "\n")
+	return err
+}
+
+// close files, the first error is returned
+func (st *JSONLStore) Commit() error {
+	var err error
+	for _, f := range []**os.File{&st.fauc, &st.fmeta, &st.fsnap} {
+		if *f == nil {
+			continue
+		}
+		if cerr := (*f).Close(); err == nil {
+			err = cerr
+		}
+		*f = nil
+	}
+	return err
+}
+
+// lines are already appended, so just close files
+func (st *JSONLStore) Rollback() {
+	st.Commit()
+}
+
+func (st *JSONLStore) Close() error {
+	return st.Commit()
+}
+
+// EachClosed reads line pairs of "auctions" and "metadata" files. They
+// may be appended meanwhile, so reading of a file stops at a partial or
+// mismatched pair.
+func (st *JSONLStore) EachClosed(q *Query, fn func(c *ClosedAuction) error) error {
+	fnames, err := ResultFiles(st.cf, st.realm, st.dir, "auctions")
+	if err != nil {
+		return err
+	}
+	for _, auc_fname := range fnames {
+		// the name part is the last one in sane formats
+		dir, base := filepath.Split(auc_fname)
+		i := strings.LastIndex(base, "auctions")
+		meta_fname := dir + base[:i] + "metadata" + base[i+len("auctions"):]
+		if err := eachClosedIn(auc_fname, meta_fname, q, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eachClosedIn(auc_fname, meta_fname string, q *Query, fn func(c *ClosedAuction) error) error {
+	fa, err := os.Open(auc_fname)
+	if err != nil {
+		return err
+	}
+	defer fa.Close()
+	fm, err := os.Open(meta_fname)
+	if err != nil {
+		return err
+	}
+	defer fm.Close()
+	sa, sm := bufio.NewScanner(fa), bufio.NewScanner(fm)
+	for line := 1; sa.Scan() && sm.Scan(); line++ {
+		var c ClosedAuction
+		if err := json.Unmarshal(sa.Bytes(), &c.Entry); err != nil {
+			logging.Warnf("%s:%d: bad line: %s", auc_fname, line, err)
+			return nil
+		}
+		if err := json.Unmarshal(sm.Bytes(), &c.Meta); err != nil {
+			logging.Warnf("%s:%d: bad line: %s", meta_fname, line, err)
+			return nil
+		}
+		if c.Entry.Auc != c.Meta.Auc {
+			logging.Warnf("%s:%d: auction %d does not match metadata of %d",
+				auc_fname, line, c.Entry.Auc, c.Meta.Auc)
+			return nil
+		}
+		if !q.match(&c) {
+			continue
+		}
+		if err := fn(&c); err != nil {
+			return err
+		}
+	}
+	if err := sa.Err(); err != nil {
+		return err
+	}
+	return sm.Err()
+}
+
+// summaries of snapshot file, bad lines are skipped
+func readSummaries(fname string) ([]*SnapshotSummary, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var r []*SnapshotSummary
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		s, err := ParseSnapshotSummary(line)
+		if err != nil {
+			logging.Warnf("%s: %s", fname, err)
+			continue
+		}
+		r = append(r, s)
+	}
+	return r, nil
+}
+
+func (st *JSONLStore) EachSummary(fn func(s *SnapshotSummary) error) error {
+	fnames, err := ResultFiles(st.cf, st.realm, st.dir, "snapshot")
+	if err != nil {
+		return err
+	}
+	for _, fname := range fnames {
+		summaries, err := readSummaries(fname)
+		if err != nil {
+			return err
+		}
+		for _, s := range summaries {
+			if err := fn(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (st *JSONLStore) LastSummary() (*SnapshotSummary, error) {
+	fnames, err := ResultFiles(st.cf, st.realm, st.dir, "snapshot")
+	if err != nil {
+		return nil, err
+	}
+	// the newest file may be just created and still empty
+	for i := len(fnames) - 1; i >= 0; i-- {
+		summaries, err := readSummaries(fnames[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(summaries) > 0 {
+			return summaries[len(summaries)-1], nil
+		}
+	}
+	return nil, nil
+}
+
+// statistics for whole days of from and to
+func (st *JSONLStore) ItemStats(item int64, from, to time.Time) ([]ItemStat, error) {
+	stats := make(map[string]*ItemStat)
+	err := st.EachClosed(&Query{Item: item}, func(c *ClosedAuction) error {
+		date := statDate(c.Meta.Closed)
+		if date < statDate(from) || (!to.IsZero() && date > statDate(to)) {
+			return nil
+		}
+		s, ok := stats[date]
+		if !ok {
+			s = &ItemStat{Item: item, Date: date}
+			stats[date] = s
+		}
+		s.add(c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := make([]ItemStat, 0, len(stats))
+	for _, s := range stats {
+		r = append(r, *s)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Date < r[j].Date })
+	return r, nil
+}
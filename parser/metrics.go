@@ -0,0 +1,42 @@
+package parser
+
+import (
+	metrics "github.com/wowauc/gowowuction/metrics"
+)
+
+var (
+	parseDuration = metrics.NewHistogram("gowowuction_parse_duration_seconds",
+		"Time of ParseDir for realm.", metrics.DurationBuckets, "realm")
+	parsedSnapshots = metrics.NewCounter("gowowuction_parsed_snapshots_total",
+		"Snapshots processed.", "realm")
+	auctionEvents = metrics.NewCounter("gowowuction_auction_events_total",
+		"Auction changes found by processor: created, modified, bids, adjusts, moves, closed, bought, auctioned, expired.",
+		"realm", "event")
+	worksetSize = metrics.NewGauge("gowowuction_workset_size",
+		"Open auctions tracked by processor.", "realm")
+	lastParsed = metrics.NewGauge("gowowuction_parsed_snapshot_timestamp_seconds",
+		"Time of the last processed snapshot.", "realm")
+)
+
+// counters of snapshot finished by FinishSnapshot
+func (prc *AuctionProcessor) reportMetrics(closed int) {
+	for _, ev := range []struct {
+		name  string
+		count int
+	}{
+		{"created", prc.NumCreated},
+		{"modified", prc.NumModified},
+		{"bids", prc.NumBids},
+		{"adjusts", prc.NumAdjusts},
+		{"moves", prc.NumMoves},
+		{"closed", closed},
+		{"bought", prc.NumBought},
+		{"auctioned", prc.NumAuctioned},
+		{"expired", prc.NumExpired},
+	} {
+		auctionEvents.Add(float64(ev.count), prc.Realm, ev.name)
+	}
+	parsedSnapshots.Inc(prc.Realm)
+	worksetSize.Set(float64(len(prc.State.WorkSet)), prc.Realm)
+	lastParsed.Set(float64(prc.SnapshotTime.Unix()), prc.Realm)
+}
@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// all battle pets share the same cage item, so pet auctions are keyed
+// by species, breed, quality and level class instead of item id
+
+const (
+	PET_LEVEL_LOW = "low" // level 1
+	PET_LEVEL_MID = "mid" // level 2 .. 24
+	PET_LEVEL_MAX = "max" // level 25
+)
+
+type PetKey struct {
+	SpeciesId int    `json:"species"`
+	BreedId   int    `json:"breed"`
+	QualityId int    `json:"quality"`
+	Level     string `json:"level"`
+}
+
+func (k PetKey) String() string {
+	return fmt.Sprintf("%d/%d/%d/%s", k.SpeciesId, k.BreedId, k.QualityId, k.Level)
+}
+
+// closed pet auction outcome
+type PetOutcome struct {
+	PetKey
+	Auc      int64     `json:"auc"`
+	PetLevel int       `json:"petLevel"`
+	Closed   time.Time `json:"closed"`
+	Result   string    `json:"result"`
+	Price    int64     `json:"price"`
+}
+
+// per-species price and sell-rate series entry (one per snapshot)
+type PetStat struct {
+	Time time.Time `json:"time"`
+	PetKey
+	Active    int   `json:"active"`
+	MinBuyout int64 `json:"minBuyout"`
+	Closed    int   `json:"closed"`
+	Sold      int   `json:"sold"`
+	Profit    int64 `json:"profit"`
+	SellRate  int   `json:"sellRate"` // percents
+}
+
+type PetStatMap map[PetKey]*PetStat
+
+func IsPetAuction(auc *Auction) bool {
+	return auc.PetSpeciesId != 0
+}
+
+func pet_level_class(level int) string {
+	switch {
+	case level >= 25:
+		return PET_LEVEL_MAX
+	case level <= 1:
+		return PET_LEVEL_LOW
+	default:
+		return PET_LEVEL_MID
+	}
+}
+
+func MakePetKey(auc *Auction) PetKey {
+	return PetKey{
+		SpeciesId: auc.PetSpeciesId,
+		BreedId:   auc.PetBreedId,
+		QualityId: auc.PetQualityId,
+		Level:     pet_level_class(auc.PetLevel),
+	}
+}
+
+func (m PetStatMap) get(key PetKey, ts time.Time) *PetStat {
+	st, ok := m[key]
+	if !ok {
+		st = &PetStat{Time: ts, PetKey: key}
+		m[key] = st
+	}
+	return st
+}
+
+// count active pet auction from the workset
+func (m PetStatMap) addActive(auc *Auction, ts time.Time) {
+	st := m.get(MakePetKey(auc), ts)
+	st.Active++
+	if auc.Buyout > 0 && (st.MinBuyout == 0 || auc.Buyout < st.MinBuyout) {
+		st.MinBuyout = auc.Buyout
+	}
+}
+
+// count closed pet auction
+func (m PetStatMap) addOutcome(o *PetOutcome) {
+	st := m.get(o.PetKey, o.Closed)
+	st.Closed++
+	if o.Result != "expired" {
+		st.Sold++
+		st.Profit += o.Price
+	}
+}
+
+func (m PetStatMap) sortedKeys() []PetKey {
+	keys := make([]PetKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+type PetProfit struct {
+	PetKey
+	Closed   int
+	Sold     int
+	Profit   int64
+	AvgPrice int64
+	SellRate int // percents
+}
+
+// aggregate closed pet auctions from all result files of realm
+func CollectPetProfits(cf *config.Config, realm string, lg *logging.Logger) ([]PetProfit, error) {
+	lg = lg.With("realm", realm)
+	mask := cf.Realm(realm).ResultDirectory + cf.GetTimedMask("pets", realm)
+	fnames, err := filepath.Glob(mask)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(util.ByBasename(fnames))
+	stats := make(map[PetKey]*PetProfit)
+	for _, fname := range fnames {
+		lg.Infof("reading %s ...", fname)
+		f, err := os.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var o PetOutcome
+			if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+				lg.Warnf("%s: bad line: %s", fname, err)
+				continue
+			}
+			p, ok := stats[o.PetKey]
+			if !ok {
+				p = &PetProfit{PetKey: o.PetKey}
+				stats[o.PetKey] = p
+			}
+			p.Closed++
+			if o.Result != "expired" {
+				p.Sold++
+				p.Profit += o.Price
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	r := make([]PetProfit, 0, len(stats))
+	for _, p := range stats {
+		if p.Sold > 0 {
+			p.AvgPrice = p.Profit / int64(p.Sold)
+		}
+		if p.Closed > 0 {
+			p.SellRate = p.Sold * 100 / p.Closed
+		}
+		r = append(r, *p)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Profit != r[j].Profit {
+			return r[i].Profit > r[j].Profit
+		}
+		return r[i].String() < r[j].String()
+	})
+	return r, nil
+}
@@ -2,17 +2,27 @@ package parser
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"os"
 	"strings"
 	"time"
 
 	config "github.com/wowauc/gowowuction/config"
+	dedup "github.com/wowauc/gowowuction/dedup"
+	logging "github.com/wowauc/gowowuction/logging"
 	util "github.com/wowauc/gowowuction/util"
 )
 
+var (
+	ErrSessionStarted    = errors.New("inside snapshot session")
+	ErrSessionNotStarted = errors.New("outside snapshot session")
+)
+
+// names of timed result files written by FinishSnapshot
+var ResultNames = []string{"auctions", "metadata", "snapshot", "pets", "petstat"}
+
 type AuctionState struct {
 	Created  time.Time `json:"created"`
 	DeadLine time.Time `json:"deadline"`
@@ -50,13 +60,15 @@ type AuctionProcessorState struct {
 type AuctionProcessor struct {
 	cf           *config.Config
 	StateFName   string
+	ResultDir    string // where timed result files are written
 	Realm        string
 	State        AuctionProcessorState
 	SnapshotTime time.Time
 	Started      bool
 	SeenSet      IdSetType
-	FileMeta     *os.File
-	FileAuc      *os.File
+	Store        Store // closed auctions and summaries go here
+	FilePets     *os.File
+	PetStats     PetStatMap
 	NumCreated   int
 	NumModified  int
 	NumBids      int
@@ -65,35 +77,17 @@ type AuctionProcessor struct {
 	NumBought    int
 	NumAuctioned int
 	NumExpired   int
+	NumUnknown   int // entries with unrecognized timeLeft
+
+	TimeLeftTable TimeLeftTable
+	Hashes        *dedup.Index    // duplicates are skipped when set
+	Log           *logging.Logger // records with realm, set by Init
 
 	TotalOpened  int
 	TotalClosed  int
 	TotalSuccess int
 }
 
-const (
-	S_VERY_LONG = "VERY_LONG"
-	S_LONG      = "LONG"
-	S_MEDIUM    = "MEDIUM"
-	S_SHORT     = "SHORT"
-)
-
-func get_expiration_interval(exp string) (min, max time.Duration) {
-	switch {
-	case exp == S_SHORT: // "SHORT" -> 0 .. 30m
-		return 0, 30 * time.Minute
-	case exp == S_MEDIUM: // "MEDIUM" -> 30m .. 2h
-		return 30 * time.Minute, 2 * time.Hour
-	case exp == S_LONG: // "LONG" -> 2h .. 12h
-		return 2 * time.Hour, 12 * time.Hour
-	case exp == S_VERY_LONG: // "VERY_LONG" -> 12h .. 2d
-		return 12 * time.Hour, 48 * time.Hour
-	default:
-		log.Fatalf("unknown expiration time string <<%s>>", exp)
-	}
-	return
-}
-
 func random_duration(d time.Duration) time.Duration {
 	return time.Duration(rand.Int63n(int64(d)))
 }
@@ -105,8 +99,8 @@ func random_datetime(a, b time.Time) time.Time {
 	return a.Add(random_duration(b.Sub(a)))
 }
 
-func guess_expiration(t time.Time, exp string) (min, max time.Time) {
-	dmin, dmax := get_expiration_interval(exp)
+func (prc *AuctionProcessor) guessExpiration(t time.Time, exp TimeLeft) (min, max time.Time) {
+	dmin, dmax := prc.TimeLeftTable.Interval(exp)
 	return t.Add(dmin), t.Add(dmax)
 }
 
@@ -115,12 +109,12 @@ func (prc *AuctionProcessor) createEntry(auc *Auction) {
 	var e WorkEntry
 	e.Entry = *auc
 	e.State.Created = prc.SnapshotTime
-	dl_min, _ := guess_expiration(prc.SnapshotTime, e.Entry.TimeLeft)
+	dl_min, _ := prc.guessExpiration(prc.SnapshotTime, e.Entry.TimeLeft)
 	var zeroTime time.Time
 	if prc.State.LastTime == zeroTime { // zero value
 		e.State.DeadLine = dl_min
 	} else { // assigned
-		_, dl_max2 := guess_expiration(prc.State.LastTime, e.Entry.TimeLeft)
+		_, dl_max2 := prc.guessExpiration(prc.State.LastTime, e.Entry.TimeLeft)
 		if dl_max2.Before(dl_min) {
 			e.State.DeadLine = dl_min
 		} else {
@@ -145,9 +139,9 @@ func (prc *AuctionProcessor) applyEntry(auc *Auction) {
 		prc.NumBids++
 		changed = true
 	}
-	if auc.TimeLeft != e.Entry.TimeLeft {
+	if auc.TimeLeft != e.Entry.TimeLeft && auc.TimeLeft.Known() {
 		e.Entry.TimeLeft = auc.TimeLeft
-		_, e.State.DeadLine = guess_expiration(prc.SnapshotTime, e.Entry.TimeLeft)
+		_, e.State.DeadLine = prc.guessExpiration(prc.SnapshotTime, e.Entry.TimeLeft)
 		prc.NumAdjusts++
 		changed = true
 	}
@@ -166,7 +160,7 @@ func (prc *AuctionProcessor) applyEntry(auc *Auction) {
 	}
 }
 
-func (prc *AuctionProcessor) closeEntry(id int64) {
+func (prc *AuctionProcessor) closeEntry(id int64) error {
 	e := prc.State.WorkSet[id]
 	delete(prc.State.WorkSet, id)
 	var m AuctionMeta
@@ -186,20 +180,63 @@ func (prc *AuctionProcessor) closeEntry(id int64) {
 		m.Result = "expired"
 		prc.NumExpired++
 	}
-	data_auc, err := json.Marshal(e.Entry)
-	data_meta, err := json.Marshal(m)
-	if err != nil {
-		log.Panicf("marshall error: %s", err)
+	if err := prc.Store.AddClosed(&ClosedAuction{e.Entry, m}); err != nil {
+		return err
+	}
+	if IsPetAuction(&e.Entry) {
+		return prc.closePetEntry(&e, &m)
 	}
-	_, err = prc.FileAuc.WriteString(string(data_auc) + "\n")
-	_, err = prc.FileMeta.WriteString(string(data_meta) + "\n")
+	return nil
+}
+
+func writeJSONLine(f *os.File, v interface{}) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		log.Panicf("WriteString error: %s", err)
+		return fmt.Errorf("marshall error: %w", err)
+	}
+	if _, err = f.WriteString(string(data) + "\n"); err != nil {
+		return fmt.Errorf("WriteString error: %w", err)
 	}
+	return nil
+}
+
+func (prc *AuctionProcessor) closePetEntry(e *WorkEntry, m *AuctionMeta) error {
+	var o PetOutcome
+	o.PetKey = MakePetKey(&e.Entry)
+	o.Auc = m.Auc
+	o.PetLevel = e.Entry.PetLevel
+	o.Closed = m.Closed
+	o.Result = m.Result
+	o.Price = m.Profit
+	prc.PetStats.addOutcome(&o)
+	return writeJSONLine(prc.FilePets, o)
+}
+
+func (prc *AuctionProcessor) storePetStats(f *os.File) error {
+	// closed entries are already removed from workset
+	for _, e := range prc.State.WorkSet {
+		if IsPetAuction(&e.Entry) {
+			prc.PetStats.addActive(&e.Entry, prc.SnapshotTime)
+		}
+	}
+	for _, key := range prc.PetStats.sortedKeys() {
+		st := prc.PetStats[key]
+		st.Time = prc.SnapshotTime
+		if st.Closed > 0 {
+			st.SellRate = st.Sold * 100 / st.Closed
+		}
+		if err := writeJSONLine(f, st); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (prc *AuctionProcessor) processAuction(auc *Auction) {
 	id := auc.Auc
+	if !auc.TimeLeft.Known() {
+		prc.NumUnknown++
+	}
 	if _, exists := prc.State.WorkSet[id]; exists {
 		// modify exists auction
 		prc.applyEntry(auc)
@@ -211,14 +248,23 @@ func (prc *AuctionProcessor) processAuction(auc *Auction) {
 func (prc *AuctionProcessor) Init(cf *config.Config, realm string) {
 	prc.cf = cf
 	prc.Realm = realm
-	prc.StateFName = cf.ResultDirectory + cf.GetName("state", prc.Realm) + ".gz"
+	prc.Log = logging.With("realm", realm)
+	rs := cf.Realm(realm)
+	prc.StateFName = rs.ResultDirectory + cf.GetName("state", prc.Realm) + ".gz"
+	prc.ResultDir = rs.ResultDirectory
+	table, err := MakeTimeLeftTable(rs.TimeLeftIntervals)
+	if err != nil {
+		prc.Log.Warnf("bad time left intervals in config (%s), use defaults", err)
+		table, _ = MakeTimeLeftTable(config.DefaultTimeLeftIntervals())
+	}
+	prc.TimeLeftTable = table
 	prc.State.WorkSet = make(WorkSetType)
 	prc.State.WorkList = nil
 	prc.SnapshotTime = time.Time{}
 	prc.Started = false
 	prc.SeenSet = make(IdSetType)
-	prc.FileMeta = nil
-	prc.FileAuc = nil
+	prc.FilePets = nil
+	prc.PetStats = nil
 	prc.NumCreated = 0
 	prc.NumModified = 0
 	prc.NumBids = 0
@@ -226,58 +272,65 @@ func (prc *AuctionProcessor) Init(cf *config.Config, realm string) {
 	prc.NumAdjusts = 0
 }
 
-func (prc *AuctionProcessor) LoadState() {
+func (prc *AuctionProcessor) LoadState() error {
 	if prc.Started {
-		log.Panic("LoadState inside snapshot session")
+		return fmt.Errorf("LoadState: %w", ErrSessionStarted)
+	}
+	exists, err := util.CheckFile(prc.StateFName)
+	if err != nil {
+		return err
 	}
-	if util.CheckFile(prc.StateFName) {
-		log.Printf("AuctionProcessor loading state from %s ...", prc.StateFName)
-		data, _ := util.Load(prc.StateFName)
+	if exists {
+		prc.Log.Infof("AuctionProcessor loading state from %s ...", prc.StateFName)
+		data, err := util.Load(prc.StateFName)
+		if err != nil {
+			return err
+		}
 		if err := json.Unmarshal(data, &prc.State); err != nil {
-			log.Panicf("... failed: %s", prc.StateFName, err)
+			return fmt.Errorf("state %s not parsed: %w", prc.StateFName, err)
 		}
-		log.Printf("... loaded with %d list enties", len(prc.State.WorkList))
+		prc.Log.Infof("... loaded with %d list enties", len(prc.State.WorkList))
 		prc.State.WorkSet = make(WorkSetType)
 		for _, e := range prc.State.WorkList {
 			prc.State.WorkSet[e.Entry.Auc] = e
 		}
 	} else {
-		log.Printf("AuctionProcessor has no state named %s ...", prc.StateFName)
+		prc.Log.Infof("AuctionProcessor has no state named %s ...", prc.StateFName)
 	}
+	return nil
 }
 
-func (prc *AuctionProcessor) SaveState() {
+func (prc *AuctionProcessor) SaveState() error {
 	if prc.Started {
-		log.Panic("SaveState inside snapshot session")
+		return fmt.Errorf("SaveState: %w", ErrSessionStarted)
 	}
-	log.Printf("AuctionProcessor storing state to %s ...", prc.StateFName)
-	log.Printf("... prepare list with %d enties", len(prc.State.WorkSet))
+	prc.Log.Infof("AuctionProcessor storing state to %s ...", prc.StateFName)
+	prc.Log.Infof("... prepare list with %d enties", len(prc.State.WorkSet))
 	prc.State.WorkList = WorkListType{}
 	for _, e := range prc.State.WorkSet {
 		prc.State.WorkList = append(prc.State.WorkList, e)
 	}
 	data, err := json.Marshal(&prc.State)
 	if err != nil {
-		log.Fatalf("... failed: %s", err)
+		return fmt.Errorf("state not marshalled: %w", err)
 	}
 	if strings.HasSuffix(prc.StateFName, ".gz") {
 		zdata := util.Zip(data)
-		log.Printf("store gzipped (%d%%) data to %s...",
+		prc.Log.Infof("store gzipped (%d%%) data to %s...",
 			len(zdata)*100/len(data), prc.StateFName)
-		util.Store(prc.StateFName, zdata)
-	} else {
-		log.Printf("store ungzipped data to %s...", prc.StateFName)
-		util.Store(prc.StateFName, data)
+		return util.Store(prc.StateFName, zdata)
 	}
+	prc.Log.Infof("store ungzipped data to %s...", prc.StateFName)
+	return util.Store(prc.StateFName, data)
 }
 
 func (prc *AuctionProcessor) SnapshotNeeded(snaptime time.Time) bool {
 	return prc.State.LastTime.Before(snaptime)
 }
 
-func (prc *AuctionProcessor) StartSnapshot(snaptime time.Time) {
+func (prc *AuctionProcessor) StartSnapshot(snaptime time.Time) error {
 	if prc.Started {
-		log.Panic("StartSnapshot inside snapshot session")
+		return fmt.Errorf("StartSnapshot: %w", ErrSessionStarted)
 	}
 	prc.Started = true
 	prc.SnapshotTime = snaptime
@@ -290,58 +343,80 @@ func (prc *AuctionProcessor) StartSnapshot(snaptime time.Time) {
 	prc.NumBought = 0
 	prc.NumAuctioned = 0
 	prc.NumExpired = 0
+	prc.NumUnknown = 0
 	// log.Printf("start snapshot at %s with %d entries in workset",
 	//	util.TSStr(prc.SnapshotTime), len(prc.State.WorkSet))
+	return nil
 }
 
-func (prc *AuctionProcessor) AddAuctionEntry(auc *Auction) {
+func (prc *AuctionProcessor) AddAuctionEntry(auc *Auction) error {
 	if !prc.Started {
-		log.Panic("AddAuctionEntry outside snapshot session")
+		return fmt.Errorf("AddAuctionEntry: %w", ErrSessionNotStarted)
 	}
 	prc.processAuction(auc)
+	return nil
 }
 
-func OpenOrCreateFile(fname string) *os.File {
+func OpenOrCreateFile(fname string) (*os.File, error) {
 	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		f, err = os.OpenFile(fname, os.O_WRONLY|os.O_CREATE, 0644)
 		if err != nil {
-			log.Panicf("OpenFile(%s) error: %s", fname, err)
+			return nil, fmt.Errorf("OpenFile(%s) error: %w", fname, err)
 		}
 	}
-	return f
+	return f, nil
 }
 
-func (prc *AuctionProcessor) FinishSnapshot() {
+func (prc *AuctionProcessor) FinishSnapshot() (err error) {
 	if !prc.Started {
-		log.Panic("FinishSnapshot outside snapshot session")
+		return fmt.Errorf("FinishSnapshot: %w", ErrSessionNotStarted)
 	}
+	defer func() { prc.Started = false }()
 
 	// log.Println("check for closed auctions")
 	num_open, num_closed := 0, 0
-	auc_fname := prc.cf.ResultDirectory + prc.cf.GetTimedName("auctions", prc.Realm, prc.SnapshotTime)
-	meta_fname := prc.cf.ResultDirectory + prc.cf.GetTimedName("metadata", prc.Realm, prc.SnapshotTime)
-	snap_fname := prc.cf.ResultDirectory + prc.cf.GetTimedName("snapshot", prc.Realm, prc.SnapshotTime)
+	pets_fname := prc.ResultDir + prc.cf.GetTimedName("pets", prc.Realm, prc.SnapshotTime)
+	petstat_fname := prc.ResultDir + prc.cf.GetTimedName("petstat", prc.Realm, prc.SnapshotTime)
+
+	if err = prc.Store.Begin(prc.SnapshotTime); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			prc.Store.Rollback()
+		}
+	}()
 
-	prc.FileAuc = OpenOrCreateFile(auc_fname)
-	defer prc.FileAuc.Close()
+	if prc.FilePets, err = OpenOrCreateFile(pets_fname); err != nil {
+		return err
+	}
+	defer prc.FilePets.Close()
 
-	prc.FileMeta = OpenOrCreateFile(meta_fname)
-	defer prc.FileMeta.Close()
+	PetStatInfo, err := OpenOrCreateFile(petstat_fname)
+	if err != nil {
+		return err
+	}
+	defer PetStatInfo.Close()
 
-	SnapInfo := OpenOrCreateFile(snap_fname)
-	defer SnapInfo.Close()
+	prc.PetStats = make(PetStatMap)
 
 	for id, _ := range prc.State.WorkSet {
 		_, seen := prc.SeenSet[id]
 		if !seen {
 			num_closed++
-			prc.closeEntry(id)
+			if err = prc.closeEntry(id); err != nil {
+				return err
+			}
 		} else {
 			num_open++
 		}
 	}
 
+	if err = prc.storePetStats(PetStatInfo); err != nil {
+		return err
+	}
+
 	var rate int = 0
 	if num_closed > 0 {
 		rate = (prc.NumBought + prc.NumAuctioned) * 100 / num_closed
@@ -355,7 +430,7 @@ func (prc *AuctionProcessor) FinishSnapshot() {
 		total_rate = prc.TotalSuccess * 100 / prc.TotalClosed
 	}
 
-	log.Printf("%s: \n"+
+	prc.Log.Infof("%s: \n"+
 		"    entries: %d\n"+
 		"    active: %d,\n"+
 		"    created: %d,\n"+
@@ -367,22 +442,31 @@ func (prc *AuctionProcessor) FinishSnapshot() {
 		prc.NumBids, prc.NumAdjusts, prc.NumMoves,
 		num_closed, prc.NumBought, prc.NumAuctioned, prc.NumExpired, rate)
 
-	log.Printf("total created %d, closed %d, success %d%%",
+	if prc.NumUnknown > 0 {
+		prc.Log.Warnf("%d entries with unknown timeLeft", prc.NumUnknown)
+	}
+
+	prc.Log.Infof("total created %d, closed %d, success %d%%",
 		prc.TotalOpened, prc.TotalClosed, total_rate)
 
-	SnapInfo.WriteString(
-		fmt.Sprintf("%s: entries:%d  active:%d created:%d "+
-			"changed:%d [bids:%d adj:%d moves:%d] "+
-			"closed:%d [bought:%d auctioned:%d expired:%d rate:%d%%]\n",
-			util.TSStr(prc.SnapshotTime),
-			len(prc.State.WorkSet), num_open,
-			prc.NumCreated, prc.NumModified,
-			prc.NumBids, prc.NumAdjusts, prc.NumMoves,
-			num_closed, prc.NumBought, prc.NumAuctioned, prc.NumExpired,
-			rate))
+	err = prc.Store.AddSummary(&SnapshotSummary{
+		Time:    prc.SnapshotTime,
+		Entries: len(prc.State.WorkSet), Active: num_open,
+		Created: prc.NumCreated, Changed: prc.NumModified,
+		Bids: prc.NumBids, Adjusts: prc.NumAdjusts, Moves: prc.NumMoves,
+		Closed: num_closed, Bought: prc.NumBought, Auctioned: prc.NumAuctioned,
+		Expired: prc.NumExpired, Rate: rate, Unknown: prc.NumUnknown,
+	})
+	if err != nil {
+		return err
+	}
+	if err = prc.Store.Commit(); err != nil {
+		return err
+	}
 
 	prc.State.LastTime = prc.SnapshotTime
 	//log.Printf("last time sets to %s", util.TSStr(prc.State.LastTime))
+	prc.reportMetrics(num_closed)
 
-	prc.Started = false
+	return nil
 }
@@ -0,0 +1,243 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	dedup "github.com/wowauc/gowowuction/dedup"
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+var ErrNothingToReparse = errors.New("no snapshots in range")
+
+// Reparse rebuilds results of realm for snapshots in [from, to]
+// with the empty initial state. Zero from means from the first snapshot,
+// zero to - up to the last one. The range is widened to whole result
+// files (see TimedNameFormat), so every regenerated file is complete.
+// Snapshots before the range only rebuild the state, their results
+// are dropped. New results are written to a staging directory and
+// swapped in when all of them are ready (see swapJournal). The state
+// is replaced only when the range is open up to the last snapshot.
+func Reparse(cf *config.Config, realm string, from, to time.Time, lg *logging.Logger) error {
+	lg = lg.With("realm", realm)
+	badfiles := make(map[string]error)
+	refs, err := ListSnapshotRefs(cf, realm, time.Time{}, badfiles)
+	if err != nil {
+		return err
+	}
+	n := len(refs)
+	times := make([]time.Time, n)
+	for i, ref := range refs {
+		times[i] = ref.Time
+	}
+	period := func(ts time.Time) string {
+		return cf.GetTimedName("", realm, ts)
+	}
+	lo, hi := 0, n
+	if !from.IsZero() {
+		for lo < n && times[lo].Before(from) {
+			lo++
+		}
+		for lo > 0 && lo < n && period(times[lo-1]) == period(times[lo]) {
+			lo--
+		}
+	}
+	if !to.IsZero() {
+		for hi > 0 && times[hi-1].After(to) {
+			hi--
+		}
+		for hi > 0 && hi < n && period(times[hi]) == period(times[hi-1]) {
+			hi++
+		}
+	}
+	if lo >= hi {
+		return ErrNothingToReparse
+	}
+	lg.Infof("reparse %s: %d snapshot(s) for state, %d for results [%s .. %s]",
+		realm, lo, hi-lo, util.TSStr(times[lo]), util.TSStr(times[hi-1]))
+
+	if err := RecoverReparse(cf, realm, lg); err != nil {
+		return err
+	}
+	staging := stagingDir(cf, realm)
+	warmup := staging + "warmup" + string(filepath.Separator)
+	result := staging + "result" + string(filepath.Separator)
+	// left by a run failed before its swap
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	for _, dir := range []string{warmup, result} {
+		if err := util.CheckDir(dir); err != nil {
+			return err
+		}
+	}
+
+	prc := new(AuctionProcessor)
+	prc.Init(cf, realm)
+	prc.Log = lg
+	if prc.Hashes, err = dedup.Open(cf.DownloadDirectory, realm); err != nil {
+		return err
+	}
+	live_state := prc.StateFName
+	prc.StateFName = staging + filepath.Base(live_state)
+
+	warmup_store, err := OpenStore(cf, realm, warmup)
+	if err != nil {
+		return err
+	}
+	defer warmup_store.Close()
+	result_store, err := OpenStore(cf, realm, result)
+	if err != nil {
+		return err
+	}
+	defer result_store.Close()
+
+	prc.ResultDir, prc.Store = warmup, warmup_store
+	err = EachSnapshot(refs[:hi], func(ref *SnapshotRef, data []byte, err error) error {
+		if !ref.Time.Before(times[lo]) {
+			prc.ResultDir, prc.Store = result, result_store
+		}
+		return processSnapshot(prc, ref, data, err, badfiles)
+	})
+	if err != nil {
+		return err
+	}
+	if err := prc.Hashes.Save(); err != nil {
+		return err
+	}
+
+	staged, err := filepath.Glob(result + "*")
+	if err != nil {
+		return err
+	}
+	live_dir := cf.Realm(realm).ResultDirectory
+	staged_db := BoltName(cf, realm, result)
+	j := new(swapJournal)
+	for _, fname := range staged {
+		if fname == staged_db {
+			continue // merged into live one below
+		}
+		j.Files = append(j.Files, [2]string{fname, live_dir + filepath.Base(fname)})
+	}
+	if hi == n {
+		if err := prc.SaveState(); err != nil {
+			return err
+		}
+		j.Files = append(j.Files, [2]string{prc.StateFName, live_state})
+	} else {
+		lg.Infof("state %s kept: there are snapshots after range", live_state)
+	}
+	if cf.Storage == config.STORAGE_BOLT {
+		// database keeps all the time, so only the range is replaced
+		j.Bolt = &boltSwap{
+			Staged: staged_db,
+			Live:   BoltName(cf, realm, live_dir),
+			From:   times[lo],
+			To:     times[hi-1],
+		}
+	}
+	if err := j.write(staging); err != nil {
+		return err
+	}
+	if err := j.rollForward(staging, lg); err != nil {
+		return err
+	}
+	return reportBadFiles(badfiles, lg)
+}
+
+// Journal of reparse swap, written to the staging directory when all
+// staged results are ready. From then on the swap is only rolled
+// forward: by Reparse itself or, if it was interrupted, by
+// RecoverReparse on the next run. Without journal the staging
+// directory is left for inspection and live results are untouched.
+type swapJournal struct {
+	Bolt  *boltSwap   `json:"bolt,omitempty"`
+	Files [][2]string `json:"files"` // staged and live names, the state is the last
+}
+
+// range of live database replaced by the staged one
+type boltSwap struct {
+	Staged string    `json:"staged"`
+	Live   string    `json:"live"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+const REPARSE_JOURNAL = "journal.json"
+
+func stagingDir(cf *config.Config, realm string) string {
+	return cf.Realm(realm).ResultDirectory + ".reparse-" + util.Safe_Realm(realm) + string(filepath.Separator)
+}
+
+func (j *swapJournal) write(staging string) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	fname := staging + REPARSE_JOURNAL
+	if err := util.Store(fname+".tmp", data); err != nil {
+		return err
+	}
+	return os.Rename(fname+".tmp", fname)
+}
+
+// Complete the swap. Every step may be repeated: the database range is
+// replaced in one transaction, files already moved are skipped.
+// The database goes first, so the state is replaced the last.
+func (j *swapJournal) rollForward(staging string, lg *logging.Logger) error {
+	if j.Bolt != nil {
+		live := NewBoltStore(j.Bolt.Live)
+		if err := live.ReplaceRange(j.Bolt.From, j.Bolt.To, NewBoltStore(j.Bolt.Staged), lg); err != nil {
+			return err
+		}
+	}
+	moved := 0
+	for _, f := range j.Files {
+		exists, err := util.CheckFile(f[0])
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := os.Rename(f[0], f[1]); err != nil {
+			return fmt.Errorf("swap of %s failed: %w", f[1], err)
+		}
+		moved++
+	}
+	lg.Infof("%d file(s) replaced", moved)
+	if err := os.Remove(staging + REPARSE_JOURNAL); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		lg.Warnf("staging %s not removed: %s", staging, err)
+	}
+	return nil
+}
+
+// RecoverReparse completes the swap of reparse of realm interrupted
+// after its journal was written. It is done before parse and reparse.
+func RecoverReparse(cf *config.Config, realm string, lg *logging.Logger) error {
+	staging := stagingDir(cf, realm)
+	data, err := os.ReadFile(staging + REPARSE_JOURNAL)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	j := new(swapJournal)
+	if err := json.Unmarshal(data, j); err != nil {
+		return fmt.Errorf("%s: %w", staging+REPARSE_JOURNAL, err)
+	}
+	lg = lg.With("realm", realm)
+	lg.Warnf("completing interrupted reparse from %s", staging)
+	return j.rollForward(staging, lg)
+}
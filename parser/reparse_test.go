@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	config "github.com/wowauc/gowowuction/config"
+)
+
+const testRealm = "eu:fordragon"
+
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	cf := config.Default()
+	base := t.TempDir()
+	for _, dir := range []*string{&cf.DownloadDirectory, &cf.ResultDirectory, &cf.TempDirectory} {
+		*dir = filepath.Join(base, filepath.Base(filepath.Clean(*dir))) + string(os.PathSeparator)
+		if err := os.MkdirAll(*dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cf.Realms = config.RealmList{{Name: testRealm}}
+	cf.RealmsList = []string{testRealm}
+	return cf
+}
+
+func writeFile(t *testing.T, fname, data string) {
+	t.Helper()
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// swap interrupted after the first file was moved
+func TestRecoverReparse(t *testing.T) {
+	cf := testConfig(t)
+	live := cf.ResultDirectory
+	staging := stagingDir(cf, testRealm)
+	result := staging + "result" + string(filepath.Separator)
+	if err := os.MkdirAll(result, 0755); err != nil {
+		t.Fatal(err)
+	}
+	j := new(swapJournal)
+	for _, name := range []string{"a.jsonl", "b.jsonl", "state.gz"} {
+		writeFile(t, live+name, "old")
+		staged := result + name
+		if name == "state.gz" {
+			staged = staging + name
+		}
+		writeFile(t, staged, "new "+name)
+		j.Files = append(j.Files, [2]string{staged, live + name})
+	}
+	if err := j.write(staging); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(j.Files[0][0], j.Files[0][1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RecoverReparse(cf, testRealm, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range j.Files {
+		data, err := os.ReadFile(f[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "new " + filepath.Base(f[1]); string(data) != want {
+			t.Errorf("%s: %q, want %q", f[1], data, want)
+		}
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("staging left: %v", err)
+	}
+	// nothing to recover
+	if err := RecoverReparse(cf, testRealm, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+// closed auction as written by FinishSnapshot: entry goes to "auctions"
+// result file, meta to the same line of "metadata" one
+type ClosedAuction struct {
+	Entry Auction     `json:"entry"`
+	Meta  AuctionMeta `json:"meta"`
+}
+
+// sorted timed result files of realm in dir
+func ResultFiles(cf *config.Config, realm, dir, name string) ([]string, error) {
+	fnames, err := filepath.Glob(dir + cf.GetTimedMask(name, realm))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(util.ByBasename(fnames))
+	return fnames, nil
+}
+
+// summary of processed snapshot, a line of "snapshot" result file
+type SnapshotSummary struct {
+	Time      time.Time `json:"time"`
+	Entries   int       `json:"entries"`
+	Active    int       `json:"active"`
+	Created   int       `json:"created"`
+	Changed   int       `json:"changed"`
+	Bids      int       `json:"bids"`
+	Adjusts   int       `json:"adjusts"`
+	Moves     int       `json:"moves"`
+	Closed    int       `json:"closed"`
+	Bought    int       `json:"bought"`
+	Auctioned int       `json:"auctioned"`
+	Expired   int       `json:"expired"`
+	Rate      int       `json:"rate"` // percents
+	Unknown   int       `json:"unknown"`
+}
+
+func (s *SnapshotSummary) String() string {
+	return fmt.Sprintf("%s: entries:%d  active:%d created:%d "+
+		"changed:%d [bids:%d adj:%d moves:%d] "+
+		"closed:%d [bought:%d auctioned:%d expired:%d rate:%d%%] "+
+		"unknown:%d",
+		util.TSStr(s.Time), s.Entries, s.Active, s.Created,
+		s.Changed, s.Bids, s.Adjusts, s.Moves,
+		s.Closed, s.Bought, s.Auctioned, s.Expired, s.Rate,
+		s.Unknown)
+}
+
+var ErrBadSummary = errors.New("bad snapshot summary")
+
+var rxSummaryValue = regexp.MustCompile(`(\w+):(\d+)`)
+
+// parse summary written by String
+func ParseSnapshotSummary(line string) (*SnapshotSummary, error) {
+	parts := strings.SplitN(line, ": ", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: %#v", ErrBadSummary, line)
+	}
+	ts, err := util.ParseTS(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadSummary, err)
+	}
+	s := &SnapshotSummary{Time: ts}
+	fields := map[string]*int{
+		"entries": &s.Entries, "active": &s.Active, "created": &s.Created,
+		"changed": &s.Changed, "bids": &s.Bids, "adj": &s.Adjusts,
+		"moves": &s.Moves, "closed": &s.Closed, "bought": &s.Bought,
+		"auctioned": &s.Auctioned, "expired": &s.Expired, "rate": &s.Rate,
+		"unknown": &s.Unknown,
+	}
+	for _, m := range rxSummaryValue.FindAllStringSubmatch(parts[1], -1) {
+		if p, ok := fields[m[1]]; ok {
+			*p, _ = strconv.Atoi(m[2])
+		}
+	}
+	return s, nil
+}
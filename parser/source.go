@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+var ErrNoEntry = errors.New("entry not found in archive")
+
+// snapshot stored as loose file or as entry of backup archive
+type SnapshotRef struct {
+	Realm string
+	Time  time.Time
+	FName string // loose file or archive
+	Entry string // name inside archive, empty for loose file
+}
+
+func (ref *SnapshotRef) String() string {
+	if ref.Entry == "" {
+		return ref.FName
+	}
+	return ref.FName + ":" + ref.Entry
+}
+
+// archives of realm from backup directory
+func ListArchives(cf *config.Config, realm string) ([]string, error) {
+	var fnames []string
+	for _, mask := range util.Make_ArchMasks(realm) {
+		found, err := filepath.Glob(cf.BackupDirectory + mask)
+		if err != nil {
+			return nil, fmt.Errorf("glob failed: %w", err)
+		}
+		for _, fname := range found {
+			a_realm, _, _, good := util.Parse_ArchName(fname)
+			if good && a_realm == realm {
+				fnames = append(fnames, fname)
+			}
+		}
+	}
+	sort.Sort(util.ByBasename(fnames))
+	return fnames, nil
+}
+
+// Snapshots of realm from download directory and from backup archives,
+// sorted by time. Loose files win over archived copies of the same
+// snapshot. Daily archives which end before `after` are not opened.
+func ListSnapshotRefs(cf *config.Config, realm string, after time.Time, badfiles map[string]error) ([]SnapshotRef, error) {
+	lg := logging.With("realm", realm)
+	loose, err := ListSnapshots(cf, realm, badfiles)
+	if err != nil {
+		return nil, err
+	}
+	var refs []SnapshotRef
+	seen := make(map[int64]bool)
+	for _, fname := range loose {
+		_, ts, _ := util.Parse_FName(fname)
+		refs = append(refs, SnapshotRef{realm, ts, fname, ""})
+		seen[ts.Unix()] = true
+	}
+
+	archives, err := ListArchives(cf, realm)
+	if err != nil {
+		return nil, err
+	}
+	for _, fname := range archives {
+		_, key, _, _ := util.Parse_ArchName(fname)
+		if len(key) == len("20060102") && !after.IsZero() {
+			if day, err := util.ParseTS(key); err == nil && !day.AddDate(0, 0, 1).After(after) {
+				continue
+			}
+		}
+		lg.Infof("listing %s ...", fname)
+		names, err := archive.List(fname)
+		if err != nil {
+			lg.Warnf("%s not listed: %s", fname, err)
+			badfiles[fname] = err
+			continue
+		}
+		for _, name := range names {
+			e_realm, ts, good := util.Parse_FName(name)
+			if !good || e_realm != realm || seen[ts.Unix()] {
+				continue
+			}
+			refs = append(refs, SnapshotRef{realm, ts, fname, name})
+			seen[ts.Unix()] = true
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].Time.Before(refs[j].Time) })
+	lg.Infof("%d snapshot(s) for %s (%d loose)", len(refs), realm, len(loose))
+	return refs, nil
+}
+
+// called for every snapshot with its raw json or load error
+type SnapshotFunc func(ref *SnapshotRef, data []byte, err error) error
+
+type callbackError struct {
+	err error
+}
+
+func (e *callbackError) Error() string { return e.err.Error() }
+
+// Load snapshots in the given order. Every archive is walked
+// once per run of its consecutive entries. Errors returned by fn
+// stop the iteration and are returned as is.
+func EachSnapshot(refs []SnapshotRef, fn SnapshotFunc) error {
+	for i := 0; i < len(refs); {
+		ref := &refs[i]
+		if ref.Entry == "" {
+			data, err := util.Load(ref.FName)
+			if err := fn(ref, data, err); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(refs) && refs[j].FName == ref.FName && refs[j].Entry != "" {
+			j++
+		}
+		run := refs[i:j]
+		k := 0
+		werr := archive.Walk(ref.FName, func(name string, ts time.Time, r io.Reader) error {
+			if k >= len(run) {
+				return archive.ErrStopWalk
+			}
+			if name != run[k].Entry {
+				return nil
+			}
+			data, err := ioutil.ReadAll(r)
+			if err := fn(&run[k], data, err); err != nil {
+				return &callbackError{err}
+			}
+			k++
+			return nil
+		})
+		var cbe *callbackError
+		if errors.As(werr, &cbe) {
+			return cbe.err
+		}
+		if werr == nil {
+			werr = ErrNoEntry
+		}
+		for ; k < len(run); k++ {
+			if err := fn(&run[k], nil, werr); err != nil {
+				return err
+			}
+		}
+		i = j
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"fmt"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+)
+
+// Store keeps results of processor: closed auctions with their outcomes,
+// snapshot summaries and daily item statistics. Results of a snapshot
+// are written between Begin and Commit (or Rollback).
+type Store interface {
+	Begin(snaptime time.Time) error
+	AddClosed(c *ClosedAuction) error
+	AddSummary(s *SnapshotSummary) error
+	Commit() error
+	Rollback()
+
+	// closed auctions matching q in order of closing
+	EachClosed(q *Query, fn func(c *ClosedAuction) error) error
+	// summaries in order of snapshots
+	EachSummary(fn func(s *SnapshotSummary) error) error
+	// summary of the last snapshot, nil if none
+	LastSummary() (*SnapshotSummary, error)
+	// daily statistics of item in [from, to], zero is open bound
+	ItemStats(item int64, from, to time.Time) ([]ItemStat, error)
+	Close() error
+}
+
+// filter of closed auctions, zero fields match anything
+type Query struct {
+	Item       int64
+	Owner      string
+	OwnerRealm string
+	From, To   time.Time // closing time
+}
+
+func (q *Query) match(c *ClosedAuction) bool {
+	closed := c.Meta.Closed
+	return (q.Item == 0 || c.Entry.Item == q.Item) &&
+		(q.Owner == "" || c.Entry.Owner == q.Owner) &&
+		(q.OwnerRealm == "" || c.Entry.OwnerRealm == q.OwnerRealm) &&
+		!closed.Before(q.From) && (q.To.IsZero() || !closed.After(q.To))
+}
+
+// closed auctions of item in a day, prices are per unit
+type ItemStat struct {
+	Item     int64  `json:"item"`
+	Date     string `json:"date"` // 2006-01-02
+	Closed   int    `json:"closed"`
+	Sold     int    `json:"sold"`
+	Quantity int64  `json:"quantity"` // units sold
+	Profit   int64  `json:"profit"`
+	Min      int64  `json:"min"`
+	Avg      int64  `json:"avg"`
+	Max      int64  `json:"max"`
+}
+
+const STAT_DATE = "2006-01-02"
+
+func statDate(t time.Time) string {
+	return t.UTC().Format(STAT_DATE)
+}
+
+// price per unit of stack
+func UnitPrice(price int64, quantity int32) int64 {
+	if quantity <= 1 {
+		return price
+	}
+	return price / int64(quantity)
+}
+
+func (st *ItemStat) add(c *ClosedAuction) {
+	st.Closed++
+	if c.Meta.Result == "expired" {
+		return
+	}
+	price := UnitPrice(c.Meta.Profit, c.Entry.Quantity)
+	if st.Sold == 0 || price < st.Min {
+		st.Min = price
+	}
+	if price > st.Max {
+		st.Max = price
+	}
+	st.Sold++
+	st.Quantity += int64(c.Entry.Quantity)
+	st.Profit += c.Meta.Profit
+	if st.Quantity > 0 {
+		st.Avg = st.Profit / st.Quantity
+	}
+}
+
+// file of bolt store of realm in dir
+func BoltName(cf *config.Config, realm, dir string) string {
+	return dir + cf.GetName("results", realm) + ".db"
+}
+
+// OpenStore opens store of realm configured by cf.Storage in dir,
+// usually the result directory of realm.
+func OpenStore(cf *config.Config, realm, dir string) (Store, error) {
+	switch cf.Storage {
+	case config.STORAGE_JSONL, "":
+		return NewJSONLStore(cf, realm, dir), nil
+	case config.STORAGE_BOLT:
+		return NewBoltStore(BoltName(cf, realm, dir)), nil
+	}
+	return nil, fmt.Errorf("unknown storage %#v", cf.Storage)
+}
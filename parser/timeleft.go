@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+)
+
+var timeLeftNames = map[TimeLeft]string{
+	VERY_LONG: "VERY_LONG",
+	LONG:      "LONG",
+	MEDIUM:    "MEDIUM",
+	SHORT:     "SHORT",
+	UNKNOWN:   "UNKNOWN",
+}
+
+// recognized values from the longest one
+var TimeLeftValues = []TimeLeft{VERY_LONG, LONG, MEDIUM, SHORT}
+
+func ParseTimeLeft(s string) TimeLeft {
+	for tl, name := range timeLeftNames {
+		if name == s {
+			return tl
+		}
+	}
+	return UNKNOWN
+}
+
+func (tl TimeLeft) String() string {
+	if name, ok := timeLeftNames[tl]; ok {
+		return name
+	}
+	return timeLeftNames[UNKNOWN]
+}
+
+func (tl TimeLeft) Known() bool {
+	return tl >= VERY_LONG && tl <= SHORT
+}
+
+func (tl TimeLeft) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tl.String())
+}
+
+// unknown strings are not an error: they are mapped to UNKNOWN
+// and must be handled by the caller
+func (tl *TimeLeft) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*tl = ParseTimeLeft(s)
+	return nil
+}
+
+// Auction is decoded as is, but a not recognized timeLeft is also
+// kept in TimeLeftRaw. Saved results have it already.
+func (auc *Auction) UnmarshalJSON(data []byte) error {
+	type plain Auction // without this method
+	if err := json.Unmarshal(data, (*plain)(auc)); err != nil {
+		return err
+	}
+	if auc.TimeLeft != UNKNOWN || auc.TimeLeftRaw != "" {
+		return nil
+	}
+	var raw struct {
+		TimeLeft *string `json:"timeLeft"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.TimeLeft != nil && *raw.TimeLeft != UNKNOWN.String() {
+		auc.TimeLeftRaw = *raw.TimeLeft
+	}
+	return nil
+}
+
+type TimeLeftInterval struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+type TimeLeftTable map[TimeLeft]TimeLeftInterval
+
+// build expiration intervals from config, UNKNOWN covers all of them
+func MakeTimeLeftTable(intervals map[string]config.Interval) (TimeLeftTable, error) {
+	t := make(TimeLeftTable)
+	var lo, hi time.Duration
+	first := true
+	for _, tl := range TimeLeftValues {
+		iv, ok := intervals[tl.String()]
+		if !ok {
+			return nil, fmt.Errorf("no interval for time left %s", tl)
+		}
+		min, err := time.ParseDuration(iv.Min)
+		if err != nil {
+			return nil, fmt.Errorf("bad min for time left %s: %s", tl, err)
+		}
+		max, err := time.ParseDuration(iv.Max)
+		if err != nil {
+			return nil, fmt.Errorf("bad max for time left %s: %s", tl, err)
+		}
+		if max < min {
+			return nil, fmt.Errorf("bad interval for time left %s: %s > %s", tl, min, max)
+		}
+		t[tl] = TimeLeftInterval{min, max}
+		if first || min < lo {
+			lo = min
+		}
+		if first || max > hi {
+			hi = max
+		}
+		first = false
+	}
+	t[UNKNOWN] = TimeLeftInterval{lo, hi}
+	return t, nil
+}
+
+// unrecognized values get the interval of UNKNOWN
+func (t TimeLeftTable) Interval(tl TimeLeft) (min, max time.Duration) {
+	iv, ok := t[tl]
+	if !ok {
+		iv = t[UNKNOWN]
+	}
+	return iv.Min, iv.Max
+}
@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+)
+
+func TestTimeLeftDecode(t *testing.T) {
+	cases := []struct {
+		json  string
+		want  TimeLeft
+		known bool
+		raw   string
+	}{
+		{`{"auc":1,"timeLeft":"SHORT"}`, SHORT, true, ""},
+		{`{"auc":1,"timeLeft":"VERY_LONG"}`, VERY_LONG, true, ""},
+		{`{"auc":1}`, UNKNOWN, false, ""},
+		{`{"auc":1,"timeLeft":"FOREVER"}`, UNKNOWN, false, "FOREVER"},
+		{`{"auc":1,"timeLeft":"UNKNOWN","timeLeftRaw":"FOREVER"}`, UNKNOWN, false, "FOREVER"},
+	}
+	for _, c := range cases {
+		var a Auction
+		if err := json.Unmarshal([]byte(c.json), &a); err != nil {
+			t.Fatalf("%s: %s", c.json, err)
+		}
+		if a.TimeLeft != c.want || a.TimeLeft.Known() != c.known || a.TimeLeftRaw != c.raw {
+			t.Errorf("%s: got %s known %v raw %q", c.json, a.TimeLeft, a.TimeLeft.Known(), a.TimeLeftRaw)
+		}
+	}
+}
+
+func TestTimeLeftRawKept(t *testing.T) {
+	var a Auction
+	if err := json.Unmarshal([]byte(`{"auc":1,"timeLeft":"FOREVER"}`), &a); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"timeLeft":"UNKNOWN"`) || !strings.Contains(string(data), `"timeLeftRaw":"FOREVER"`) {
+		t.Errorf("raw time left lost: %s", data)
+	}
+	data, err = json.Marshal(&Auction{BaseAuction: BaseAuction{Auc: 1, TimeLeft: LONG}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"timeLeft":"LONG"`) || strings.Contains(string(data), "timeLeftRaw") {
+		t.Errorf("known time left: %s", data)
+	}
+}
+
+func TestTimeLeftInterval(t *testing.T) {
+	table, err := MakeTimeLeftTable(config.DefaultTimeLeftIntervals())
+	if err != nil {
+		t.Fatal(err)
+	}
+	umin, umax := table.Interval(UNKNOWN)
+	for _, tl := range []TimeLeft{TimeLeft(99), UNKNOWN} {
+		if min, max := table.Interval(tl); min != umin || max != umax {
+			t.Errorf("%s: got [%s, %s], want interval of UNKNOWN", tl, min, max)
+		}
+	}
+	if min, max := table.Interval(SHORT); min != 0 || max != 30*time.Minute {
+		t.Errorf("SHORT: got [%s, %s], want [0s, 30m]", min, max)
+	}
+}
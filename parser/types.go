@@ -3,7 +3,8 @@ package parser
 type TimeLeft int
 
 const (
-	VERY_LONG TimeLeft = iota
+	UNKNOWN TimeLeft = iota // missing or not recognized value from API
+	VERY_LONG
 	LONG
 	MEDIUM
 	SHORT
@@ -24,17 +25,19 @@ type ModList []Modifier
 
 /**/
 type BaseAuction struct {
-	Auc        int64  `json:"auc"`
-	Item       int64  `json:"item"`
-	Owner      string `json:"owner"`
-	OwnerRealm string `json:"ownerRealm"`
-	Bid        int64  `json:"bid"`
-	Buyout     int64  `json:"buyout"`
-	Quantity   int32  `json:"quantity"`
-	TimeLeft   string `json:"timeLeft"` // VERY_LONG | LONG | MEDIUM | SHORT
-	Rand       int64  `json:"rand"`
-	Seed       int64  `json:"seed"`
-	Context    int64  `json:"context"`
+	Auc        int64    `json:"auc"`
+	Item       int64    `json:"item"`
+	Owner      string   `json:"owner"`
+	OwnerRealm string   `json:"ownerRealm"`
+	Bid        int64    `json:"bid"`
+	Buyout     int64    `json:"buyout"`
+	Quantity   int32    `json:"quantity"`
+	TimeLeft   TimeLeft `json:"timeLeft"` // VERY_LONG | LONG | MEDIUM | SHORT
+	Rand       int64    `json:"rand"`
+	Seed       int64    `json:"seed"`
+	Context    int64    `json:"context"`
+	// not recognized timeLeft as is, kept for later diagnosis
+	TimeLeftRaw string `json:"timeLeftRaw,omitempty"`
 }
 
 type ModsPart struct {
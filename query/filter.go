@@ -0,0 +1,216 @@
+package query
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	export "github.com/wowauc/gowowuction/export"
+	parser "github.com/wowauc/gowowuction/parser"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+var (
+	ErrBadFilter = errors.New("bad filter")
+	ErrBadField  = errors.New("unknown field")
+)
+
+// field of closed auction, values are int64, string or time.Time
+type field struct {
+	name string
+	kind int // export.KIND_*
+	get  func(realm string, c *parser.ClosedAuction) interface{}
+}
+
+// columns of export and derived fields
+var fields = func() []*field {
+	var r []*field
+	for _, col := range export.Columns {
+		r = append(r, &field{col.Name, col.Kind, col.Value})
+	}
+	return append(r,
+		&field{"price", export.KIND_INT, func(realm string, c *parser.ClosedAuction) interface{} {
+			return parser.UnitPrice(c.Entry.Buyout, c.Entry.Quantity)
+		}},
+		&field{"day", export.KIND_STRING, func(realm string, c *parser.ClosedAuction) interface{} {
+			return c.Meta.Closed.UTC().Format("2006-01-02")
+		}},
+		&field{"month", export.KIND_STRING, func(realm string, c *parser.ClosedAuction) interface{} {
+			return c.Meta.Closed.UTC().Format("2006-01")
+		}},
+	)
+}()
+
+func FieldNames() []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+func findField(name string) (*field, error) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %#v, known are %s", ErrBadField, name, strings.Join(FieldNames(), ","))
+}
+
+// term of filter: field op values, several values of = and != are
+// alternatives
+type term struct {
+	field  *field
+	op     string
+	values []interface{}
+}
+
+var rxTerm = regexp.MustCompile(`^(\w+)(<=|>=|!=|=|<|>)(.+)$`)
+
+func parseValue(f *field, s string) (interface{}, error) {
+	switch f.kind {
+	case export.KIND_INT:
+		return strconv.ParseInt(s, 10, 64)
+	case export.KIND_TIME:
+		return util.ParseTS(s)
+	}
+	return s, nil
+}
+
+func parseTerm(s string) (*term, error) {
+	m := rxTerm.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%w term %#v, must be <field><op><value>", ErrBadFilter, s)
+	}
+	f, err := findField(m[1])
+	if err != nil {
+		return nil, err
+	}
+	t := &term{field: f, op: m[2]}
+	if f.kind == export.KIND_STRING && t.op != "=" && t.op != "!=" {
+		return nil, fmt.Errorf("%w term %#v, only = and != apply to %s", ErrBadFilter, s, f.name)
+	}
+	svalues := []string{m[3]}
+	if t.op == "=" || t.op == "!=" {
+		svalues = strings.Split(m[3], ",")
+	}
+	for _, sv := range svalues {
+		v, err := parseValue(f, sv)
+		if err != nil {
+			return nil, fmt.Errorf("%w term %#v: %s", ErrBadFilter, s, err)
+		}
+		t.values = append(t.values, v)
+	}
+	return t, nil
+}
+
+// -1, 0 or 1 for values of the same type
+func compare(a, b interface{}) int {
+	switch a := a.(type) {
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	return 0
+}
+
+func (t *term) match(v interface{}) bool {
+	switch t.op {
+	case "=", "!=":
+		found := false
+		for _, tv := range t.values {
+			if compare(v, tv) == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (t.op == "=")
+	case "<":
+		return compare(v, t.values[0]) < 0
+	case "<=":
+		return compare(v, t.values[0]) <= 0
+	case ">":
+		return compare(v, t.values[0]) > 0
+	case ">=":
+		return compare(v, t.values[0]) >= 0
+	}
+	return false
+}
+
+// Filter is a conjunction of terms like "item=124101 result=sold,bought
+// price>=100000 closed>=20160210", times are 20060102[_150405] in UTC
+type Filter struct {
+	terms []*term
+}
+
+func ParseFilter(s string) (*Filter, error) {
+	flt := &Filter{}
+	for _, ts := range strings.Fields(s) {
+		t, err := parseTerm(ts)
+		if err != nil {
+			return nil, err
+		}
+		flt.terms = append(flt.terms, t)
+	}
+	return flt, nil
+}
+
+func (flt *Filter) match(realm string, c *parser.ClosedAuction) bool {
+	for _, t := range flt.terms {
+		if !t.match(t.field.get(realm, c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRealm tells whether realm may have matching auctions at all
+func (flt *Filter) matchRealm(realm string) bool {
+	for _, t := range flt.terms {
+		if t.field.name == "realm" && !t.match(realm) {
+			return false
+		}
+	}
+	return true
+}
+
+// storeQuery narrows scan of store by terms it can use, the rest is
+// checked by match
+func (flt *Filter) storeQuery() *parser.Query {
+	q := &parser.Query{}
+	for _, t := range flt.terms {
+		if t.op == "=" && len(t.values) == 1 {
+			switch t.field.name {
+			case "item":
+				q.Item = t.values[0].(int64)
+			case "owner":
+				q.Owner = t.values[0].(string)
+			case "ownerRealm":
+				q.OwnerRealm = t.values[0].(string)
+			}
+		}
+		if t.field.name == "closed" {
+			switch t.op {
+			case ">", ">=":
+				q.From = t.values[0].(time.Time)
+			case "<", "<=":
+				q.To = t.values[0].(time.Time)
+			case "=":
+				if len(t.values) == 1 {
+					q.From, q.To = t.values[0].(time.Time), t.values[0].(time.Time)
+				}
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,132 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	parser "github.com/wowauc/gowowuction/parser"
+)
+
+func TestParseTerm(t *testing.T) {
+	day := time.Date(2016, 2, 10, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		s      string
+		field  string
+		op     string
+		values []interface{}
+		err    error
+	}{
+		{"item=124101", "item", "=", []interface{}{int64(124101)}, nil},
+		{"item!=1,2", "item", "!=", []interface{}{int64(1), int64(2)}, nil},
+		{"price>=100000", "price", ">=", []interface{}{int64(100000)}, nil},
+		{"buyout<5", "buyout", "<", []interface{}{int64(5)}, nil},
+		{"result=sold,bought", "result", "=", []interface{}{"sold", "bought"}, nil},
+		{"owner=Foo,Bar", "owner", "=", []interface{}{"Foo", "Bar"}, nil},
+		{"closed>=20160210", "closed", ">=", []interface{}{day}, nil},
+		{"closed<20160210_060000", "closed", "<", []interface{}{day.Add(6 * time.Hour)}, nil},
+		{"month=2016-02", "month", "=", []interface{}{"2016-02"}, nil},
+		{"item", "", "", nil, ErrBadFilter},
+		{"item=", "", "", nil, ErrBadFilter},
+		{"=5", "", "", nil, ErrBadFilter},
+		{"nosuch=5", "", "", nil, ErrBadField},
+		{"item=abc", "", "", nil, ErrBadFilter},
+		{"item<1,2", "", "", nil, ErrBadFilter},
+		{"owner>Foo", "", "", nil, ErrBadFilter},
+		{"closed>yesterday", "", "", nil, ErrBadFilter},
+	}
+	for _, c := range cases {
+		tm, err := parseTerm(c.s)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("%s: got %v, want %v", c.s, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.s, err)
+			continue
+		}
+		if tm.field.name != c.field || tm.op != c.op || !reflect.DeepEqual(tm.values, c.values) {
+			t.Errorf("%s: got %s %s %v", c.s, tm.field.name, tm.op, tm.values)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	c := &parser.ClosedAuction{}
+	c.Entry.Item = 124101
+	c.Entry.Owner = "Foo"
+	c.Entry.Buyout = 300000
+	c.Entry.Quantity = 3
+	c.Meta.Closed = time.Date(2016, 2, 10, 12, 0, 0, 0, time.UTC)
+	c.Meta.Result = "sold"
+	cases := []struct {
+		filter string
+		realm  bool // matchRealm of eu:fordragon
+		match  bool
+	}{
+		{"", true, true},
+		{"item=124101", true, true},
+		{"item=1,124101", true, true},
+		{"item!=124101", true, false},
+		{"price=100000 quantity>2", true, true},
+		{"price>100000", true, false},
+		{"owner=Foo result!=expired", true, true},
+		{"closed>=20160210 closed<20160211", true, true},
+		{"closed>20160210_120000", true, false},
+		{"closed=20160210_120000", true, true},
+		{"day=2016-02-10", true, true},
+		{"realm=eu:fordragon,eu:silvermoon", true, true},
+		{"realm=eu:silvermoon", false, false},
+		{"realm!=eu:fordragon item=124101", false, false},
+	}
+	for _, tc := range cases {
+		flt, err := ParseFilter(tc.filter)
+		if err != nil {
+			t.Errorf("%#v: %s", tc.filter, err)
+			continue
+		}
+		if got := flt.matchRealm("eu:fordragon"); got != tc.realm {
+			t.Errorf("%#v: realm match %v", tc.filter, got)
+		}
+		if got := flt.match("eu:fordragon", c); got != tc.match {
+			t.Errorf("%#v: match %v", tc.filter, got)
+		}
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	day := time.Date(2016, 2, 10, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		filter string
+		want   parser.Query
+	}{
+		{"", parser.Query{}},
+		{"item=124101", parser.Query{Item: 124101}},
+		{"item=1,2", parser.Query{}}, // alternatives are checked by match
+		{"item!=1", parser.Query{}},
+		{"item>1", parser.Query{}},
+		{"owner=Foo ownerRealm=Fordragon", parser.Query{Owner: "Foo", OwnerRealm: "Fordragon"}},
+		{"result=sold price<100", parser.Query{}},
+		{"closed>=20160210", parser.Query{From: day}},
+		{"closed>20160210", parser.Query{From: day}},
+		{"closed<=20160211", parser.Query{To: day.AddDate(0, 0, 1)}},
+		{"closed>=20160210 closed<20160211", parser.Query{From: day, To: day.AddDate(0, 0, 1)}},
+		{"closed=20160210", parser.Query{From: day, To: day}},
+		{"closed=20160210,20160211", parser.Query{}},
+		{"closed!=20160210", parser.Query{}},
+		{"opened>=20160210", parser.Query{}},
+	}
+	for _, c := range cases {
+		flt, err := ParseFilter(c.filter)
+		if err != nil {
+			t.Errorf("%#v: %s", c.filter, err)
+			continue
+		}
+		if got := flt.storeQuery(); !reflect.DeepEqual(*got, c.want) {
+			t.Errorf("%#v: got %+v, want %+v", c.filter, *got, c.want)
+		}
+	}
+}
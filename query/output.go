@@ -0,0 +1,87 @@
+package query
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	OUTPUT_TABLE = "table"
+	OUTPUT_JSON  = "json"
+	OUTPUT_CSV   = "csv"
+)
+
+var ErrBadOutput = errors.New("bad output format")
+
+func CheckOutput(format string) error {
+	switch format {
+	case OUTPUT_TABLE, OUTPUT_JSON, OUTPUT_CSV:
+		return nil
+	}
+	return fmt.Errorf("%w %#v, must be %s, %s or %s", ErrBadOutput, format, OUTPUT_TABLE, OUTPUT_JSON, OUTPUT_CSV)
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// Write outputs table as aligned text, array of json objects or csv
+func (t *Table) Write(w io.Writer, format string) error {
+	if err := CheckOutput(format); err != nil {
+		return err
+	}
+	switch format {
+	case OUTPUT_TABLE:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.Columns, "\t"))
+		for _, row := range t.Rows {
+			s := make([]string, len(row))
+			for i, v := range row {
+				s[i] = formatValue(v)
+			}
+			fmt.Fprintln(tw, strings.Join(s, "\t"))
+		}
+		return tw.Flush()
+	case OUTPUT_JSON:
+		objs := make([]map[string]interface{}, len(t.Rows))
+		for i, row := range t.Rows {
+			objs[i] = make(map[string]interface{}, len(row))
+			for j, v := range row {
+				objs[i][t.Columns[j]] = v
+			}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objs)
+	case OUTPUT_CSV:
+		cw := csv.NewWriter(w)
+		cw.Write(t.Columns)
+		for _, row := range t.Rows {
+			s := make([]string, len(row))
+			for i, v := range row {
+				s[i] = formatValue(v)
+			}
+			cw.Write(s)
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return nil
+}
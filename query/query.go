@@ -0,0 +1,299 @@
+// Package query runs ad-hoc searches over closed auctions of results:
+// filtering, grouping with aggregation, sorting and output as table,
+// JSON or CSV.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	config "github.com/wowauc/gowowuction/config"
+	export "github.com/wowauc/gowowuction/export"
+	parser "github.com/wowauc/gowowuction/parser"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+var ErrBadAgg = errors.New("bad aggregation")
+
+// fields listed when neither grouping nor aggregation is given
+var DEFAULT_FIELDS = []string{"closed", "realm", "item", "owner", "quantity", "buyout", "price", "result", "profit"}
+
+type Spec struct {
+	Filter string
+	Fields []string // listed fields, DEFAULT_FIELDS when empty
+	Group  []string
+	Aggs   []string // count, sum(f), avg(f), min(f), max(f), p<N>(f)
+	Sort   []string // columns of result, "-" prefix for descending order
+	Limit  int      // rows of result, 0 is unlimited
+}
+
+// Table is result of query, values are int64, float64, string or time.Time
+type Table struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// aggregation of int field over group
+type agg struct {
+	name  string // column of result
+	fn    string
+	pct   float64
+	field *field
+}
+
+var rxAgg = regexp.MustCompile(`^(\w+)\((\w+)\)$`)
+var rxPct = regexp.MustCompile(`^p(\d+(?:\.\d+)?)$`)
+
+func parseAgg(s string) (*agg, error) {
+	if s == "count" {
+		return &agg{name: s, fn: s}, nil
+	}
+	m := rxAgg.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%w %#v, must be count or <func>(<field>)", ErrBadAgg, s)
+	}
+	a := &agg{name: s, fn: m[1]}
+	switch a.fn {
+	case "sum", "avg", "min", "max":
+	default:
+		pm := rxPct.FindStringSubmatch(a.fn)
+		if pm == nil {
+			return nil, fmt.Errorf("%w %#v, functions are count, sum, avg, min, max and p<N>", ErrBadAgg, s)
+		}
+		a.pct, _ = strconv.ParseFloat(pm[1], 64)
+		if a.pct <= 0 || a.pct > 100 {
+			return nil, fmt.Errorf("%w %#v, percentile must be in (0, 100]", ErrBadAgg, s)
+		}
+	}
+	var err error
+	if a.field, err = findField(m[2]); err != nil {
+		return nil, err
+	}
+	if a.field.kind != export.KIND_INT {
+		return nil, fmt.Errorf("%w %#v, %s is not a number", ErrBadAgg, s, a.field.name)
+	}
+	return a, nil
+}
+
+// values of aggregated fields of one group
+type group struct {
+	key    []interface{}
+	count  int64
+	values [][]int64 // by aggregation
+}
+
+// nearest rank percentile of sorted values
+func percentile(values []int64, pct float64) int64 {
+	i := int(math.Ceil(pct/100*float64(len(values)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return values[i]
+}
+
+func (a *agg) result(g *group, values []int64) interface{} {
+	if a.fn == "count" {
+		return g.count
+	}
+	if len(values) == 0 {
+		return int64(0)
+	}
+	var sum int64
+	for _, v := range values {
+		sum += v
+	}
+	switch a.fn {
+	case "sum":
+		return sum
+	case "avg":
+		return float64(sum) / float64(len(values))
+	}
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	switch a.fn {
+	case "min":
+		return sorted[0]
+	case "max":
+		return sorted[len(sorted)-1]
+	}
+	return percentile(sorted, a.pct)
+}
+
+var errLimit = errors.New("limit reached")
+
+// Results of realm are queried in its database whenever it exists,
+// imported ones too, and in json lines files otherwise.
+func openStore(cf *config.Config, realm string) (parser.Store, error) {
+	dir := cf.Realm(realm).ResultDirectory
+	fname := parser.BoltName(cf, realm, dir)
+	exists, err := util.CheckFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return parser.NewBoltStore(fname), nil
+	}
+	return parser.NewJSONLStore(cf, realm, dir), nil
+}
+
+// Run executes query over closed auctions of realms of config
+func Run(cf *config.Config, spec *Spec) (*Table, error) {
+	flt, err := ParseFilter(spec.Filter)
+	if err != nil {
+		return nil, err
+	}
+	var aggs []*agg
+	for _, s := range spec.Aggs {
+		a, err := parseAgg(s)
+		if err != nil {
+			return nil, err
+		}
+		aggs = append(aggs, a)
+	}
+	if len(spec.Group) > 0 && len(aggs) == 0 {
+		aggs = []*agg{{name: "count", fn: "count"}}
+	}
+	listed := spec.Fields
+	if len(aggs) > 0 {
+		listed = spec.Group
+	} else if len(listed) == 0 {
+		listed = DEFAULT_FIELDS
+	}
+	var keys []*field
+	for _, name := range listed {
+		f, err := findField(name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, f)
+	}
+
+	t := &Table{Columns: append([]string(nil), listed...)}
+	for _, a := range aggs {
+		t.Columns = append(t.Columns, a.name)
+	}
+	sorter, err := newSorter(t.Columns, spec.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	// plain list without sorting stops at limit
+	early := len(aggs) == 0 && sorter == nil && spec.Limit > 0
+	groups := make(map[string]*group)
+	var order []*group
+	for _, realm := range cf.RealmsList {
+		if !flt.matchRealm(realm) {
+			continue
+		}
+		store, err := openStore(cf, realm)
+		if err != nil {
+			return nil, err
+		}
+		err = store.EachClosed(flt.storeQuery(), func(c *parser.ClosedAuction) error {
+			if !flt.match(realm, c) {
+				return nil
+			}
+			key := make([]interface{}, len(keys))
+			for i, f := range keys {
+				key[i] = f.get(realm, c)
+			}
+			if len(aggs) == 0 {
+				t.Rows = append(t.Rows, key)
+				if early && len(t.Rows) >= spec.Limit {
+					return errLimit
+				}
+				return nil
+			}
+			skey := fmt.Sprintf("%#v", key)
+			g, ok := groups[skey]
+			if !ok {
+				g = &group{key: key, values: make([][]int64, len(aggs))}
+				groups[skey] = g
+				order = append(order, g)
+			}
+			g.count++
+			for i, a := range aggs {
+				if a.field != nil {
+					g.values[i] = append(g.values[i], a.field.get(realm, c).(int64))
+				}
+			}
+			return nil
+		})
+		store.Close()
+		if errors.Is(err, errLimit) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", realm, err)
+		}
+	}
+
+	if len(aggs) > 0 {
+		for _, g := range order {
+			row := g.key
+			for i, a := range aggs {
+				row = append(row, a.result(g, g.values[i]))
+			}
+			t.Rows = append(t.Rows, row)
+		}
+		// groups by their keys, sorter below keeps this order of ties
+		bykeys := &rowSorter{}
+		for i := range spec.Group {
+			bykeys.cols = append(bykeys.cols, i)
+			bykeys.desc = append(bykeys.desc, false)
+		}
+		sort.SliceStable(t.Rows, func(i, j int) bool { return bykeys.less(t.Rows[i], t.Rows[j]) })
+	}
+	if sorter != nil {
+		sort.SliceStable(t.Rows, func(i, j int) bool { return sorter.less(t.Rows[i], t.Rows[j]) })
+	}
+	if spec.Limit > 0 && len(t.Rows) > spec.Limit {
+		t.Rows = t.Rows[:spec.Limit]
+	}
+	return t, nil
+}
+
+type rowSorter struct {
+	cols []int
+	desc []bool
+}
+
+// sorter by columns of result, nil when spec is empty
+func newSorter(columns, spec []string) (*rowSorter, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	rs := &rowSorter{}
+	for _, s := range spec {
+		name := strings.TrimPrefix(s, "-")
+		i := indexOf(columns, name)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: sort by %#v, columns are %s", ErrBadField, name, strings.Join(columns, ","))
+		}
+		rs.cols = append(rs.cols, i)
+		rs.desc = append(rs.desc, name != s)
+	}
+	return rs, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func (rs *rowSorter) less(a, b []interface{}) bool {
+	for k, i := range rs.cols {
+		if c := compare(a[i], b[i]); c != 0 {
+			return (c < 0) != rs.desc[k]
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package query
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	parser "github.com/wowauc/gowowuction/parser"
+)
+
+// results of the first realm are json lines, of the second one are
+// imported to database, storage stays jsonl
+func TestRunStores(t *testing.T) {
+	cf := config.Default()
+	cf.ResultDirectory = filepath.Join(t.TempDir(), "result") + string(os.PathSeparator)
+	if err := os.MkdirAll(cf.ResultDirectory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	realms := []string{"eu:fordragon", "eu:silvermoon"}
+	cf.Realms = config.RealmList{{Name: realms[0]}, {Name: realms[1]}}
+	cf.RealmsList = realms
+	ts := time.Date(2016, 2, 10, 12, 0, 0, 0, time.UTC)
+	for i, st := range []parser.Store{
+		parser.NewJSONLStore(cf, realms[0], cf.ResultDirectory),
+		parser.NewBoltStore(parser.BoltName(cf, realms[1], cf.ResultDirectory)),
+	} {
+		var c parser.ClosedAuction
+		c.Entry.Auc, c.Entry.Item = int64(i+1), int64(10+i)
+		c.Meta = parser.AuctionMeta{Auc: c.Entry.Auc, Opened: ts.Add(-time.Hour), Closed: ts, Result: "sold"}
+		if err := st.Begin(ts); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.AddClosed(&c); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table, err := Run(cf, &Spec{Fields: []string{"realm", "item"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{realms[0], int64(10)}, {realms[1], int64(11)}}
+	if !reflect.DeepEqual(table.Rows, want) {
+		t.Errorf("rows %v, want %v", table.Rows, want)
+	}
+}
@@ -0,0 +1,265 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	archive "github.com/wowauc/gowowuction/archive"
+	backup "github.com/wowauc/gowowuction/backup"
+	config "github.com/wowauc/gowowuction/config"
+	dedup "github.com/wowauc/gowowuction/dedup"
+	logging "github.com/wowauc/gowowuction/logging"
+	parser "github.com/wowauc/gowowuction/parser"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+type PruneReport struct {
+	Downloads   []string // removed loose snapshots
+	Kept        []string // out of age but not parsed or not archived yet
+	Backups     []string // removed archives
+	Results     []string // removed result files
+	Downsampled map[string]int
+	Failed      int
+}
+
+type rules struct {
+	downloads, backups, results, downsample *util.Age
+}
+
+func parseRules(r *config.Retention) (*rules, error) {
+	var rs rules
+	for _, v := range []struct {
+		s   string
+		age **util.Age
+	}{
+		{r.Downloads, &rs.downloads},
+		{r.Backups, &rs.backups},
+		{r.Results, &rs.results},
+		{r.Downsample, &rs.downsample},
+	} {
+		age, err := util.ParseAge(v.s)
+		if err != nil {
+			return nil, err
+		}
+		*v.age = age
+	}
+	return &rs, nil
+}
+
+func remove(fname string, dryrun bool, lg *logging.Logger) error {
+	if dryrun {
+		lg.Infof("... would remove %s", fname)
+		return nil
+	}
+	lg.Infof("... remove %s", fname)
+	return os.Remove(fname)
+}
+
+// day of daily archive, false for archives of other periods
+func archiveDay(fname string) (time.Time, bool) {
+	_, key, _, good := util.Parse_ArchName(fname)
+	if !good || len(key) != len("20060102") {
+		return time.Time{}, false
+	}
+	day, err := util.ParseTS(key)
+	return day, err == nil
+}
+
+// Apply retention rules of config to configured realms. With dryrun
+// nothing is changed, the report lists what would be done.
+func Prune(cf *config.Config, now time.Time, dryrun bool, lg *logging.Logger) (*PruneReport, error) {
+	rs, err := parseRules(&cf.Retention)
+	if err != nil {
+		return nil, err
+	}
+	report := &PruneReport{Downsampled: make(map[string]int)}
+	for _, realm := range cf.RealmsList {
+		rlg := lg.With("realm", realm)
+		if rs.downloads != nil {
+			if err := pruneDownloads(cf, realm, rs.downloads.Cutoff(now), dryrun, report, rlg); err != nil {
+				return report, err
+			}
+		}
+		if rs.backups != nil || rs.downsample != nil {
+			if err := pruneBackups(cf, realm, rs, now, dryrun, report, rlg); err != nil {
+				return report, err
+			}
+		}
+		if rs.results != nil {
+			if err := pruneResults(cf, realm, rs.results.Cutoff(now), dryrun, report, rlg); err != nil {
+				return report, err
+			}
+		}
+	}
+	lg.Infof("prune: %d download(s), %d backup(s), %d result file(s) removed, %d archive(s) downsampled, %d kept unsafe, %d failed",
+		len(report.Downloads), len(report.Backups), len(report.Results),
+		len(report.Downsampled), len(report.Kept), report.Failed)
+	return report, nil
+}
+
+// Loose snapshots older than cutoff go only when they are parsed
+// already and stored in some backup archive, or are known duplicates.
+func pruneDownloads(cf *config.Config, realm string, cutoff time.Time, dryrun bool, report *PruneReport, lg *logging.Logger) error {
+	badfiles := make(map[string]error)
+	fnames, err := parser.ListSnapshots(cf, realm, badfiles)
+	if err != nil {
+		return err
+	}
+	var old []string
+	for _, fname := range fnames {
+		if _, ts, _ := util.Parse_FName(fname); ts.Before(cutoff) {
+			old = append(old, fname)
+		}
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	prc := new(parser.AuctionProcessor)
+	prc.Init(cf, realm)
+	if err := prc.LoadState(); err != nil {
+		return err
+	}
+	archived, err := archivedEntries(cf, realm, cutoff, lg)
+	if err != nil {
+		return err
+	}
+	hashes, err := dedup.Open(cf.DownloadDirectory, realm)
+	if err != nil {
+		return err
+	}
+	for _, fname := range old {
+		_, ts, _ := util.Parse_FName(fname)
+		_, dup := hashes.Duplicate(ts)
+		if !dup && (ts.After(prc.State.LastTime) || !archived[util.TSStr(ts)]) {
+			lg.Infof("%s is out of age but not parsed or not archived, kept", fname)
+			report.Kept = append(report.Kept, fname)
+			continue
+		}
+		if err := remove(fname, dryrun, lg); err != nil {
+			lg.Warnf("%s", err)
+			report.Failed++
+			continue
+		}
+		report.Downloads = append(report.Downloads, fname)
+	}
+	return nil
+}
+
+// times of snapshots of realm in archives which may hold ones before
+// cutoff, entries are matched by time so legacy names count too
+func archivedEntries(cf *config.Config, realm string, cutoff time.Time, lg *logging.Logger) (map[string]bool, error) {
+	archives, err := parser.ListArchives(cf, realm)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]bool)
+	for _, fname := range archives {
+		if day, daily := archiveDay(fname); daily && !day.Before(cutoff) {
+			continue
+		}
+		names, err := archive.List(fname)
+		if err != nil {
+			lg.Warnf("%s not listed: %s", fname, err)
+			continue
+		}
+		for _, name := range names {
+			if e_realm, ts, good := util.Parse_FName(name); good && e_realm == realm {
+				entries[util.TSStr(ts)] = true
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Daily archives which end before backups cutoff are removed (with
+// their .bak copies), the ones ending before downsample cutoff are
+// downsampled to one snapshot per hour.
+func pruneBackups(cf *config.Config, realm string, rs *rules, now time.Time, dryrun bool, report *PruneReport, lg *logging.Logger) error {
+	archives, err := parser.ListArchives(cf, realm)
+	if err != nil {
+		return err
+	}
+	zopts := &backup.ZstdOptions{Level: cf.BackupZstdLevel, Dict: cf.BackupZstdDict}
+	for _, fname := range archives {
+		day, daily := archiveDay(fname)
+		if !daily {
+			continue
+		}
+		end := day.AddDate(0, 0, 1)
+		if rs.backups != nil && !end.After(rs.backups.Cutoff(now)) {
+			failed := false
+			for _, name := range []string{fname, fname + ".bak"} {
+				if exists, _ := util.CheckFile(name); !exists {
+					continue
+				}
+				if err := remove(name, dryrun, lg); err != nil {
+					lg.Warnf("%s", err)
+					failed = true
+				}
+			}
+			if failed {
+				report.Failed++
+			} else {
+				report.Backups = append(report.Backups, fname)
+			}
+			continue
+		}
+		if rs.downsample == nil || end.After(rs.downsample.Cutoff(now)) {
+			continue
+		}
+		extra, err := backup.HourlyExtra(fname)
+		if err != nil {
+			lg.Warnf("%s not listed: %s", fname, err)
+			report.Failed++
+			continue
+		}
+		if len(extra) == 0 {
+			continue
+		}
+		if dryrun {
+			lg.Infof("... would drop %d snapshot(s) from %s", len(extra), fname)
+		} else {
+			lg.Infof("... drop %d snapshot(s) from %s", len(extra), fname)
+			if err := backup.Downsample(fname, extra, cf.TempDirectory, zopts, lg); err != nil {
+				lg.Warnf("%s not downsampled: %s", fname, err)
+				report.Failed++
+				continue
+			}
+		}
+		report.Downsampled[fname] = len(extra)
+	}
+	return nil
+}
+
+// result files of realm not modified since cutoff
+func pruneResults(cf *config.Config, realm string, cutoff time.Time, dryrun bool, report *PruneReport, lg *logging.Logger) error {
+	var fnames []string
+	for _, name := range parser.ResultNames {
+		found, err := filepath.Glob(cf.Realm(realm).ResultDirectory + cf.GetTimedMask(name, realm))
+		if err != nil {
+			return err
+		}
+		fnames = append(fnames, found...)
+	}
+	sort.Strings(fnames)
+	for _, fname := range fnames {
+		fi, err := os.Stat(fname)
+		if err != nil {
+			lg.Warnf("%s", err)
+			report.Failed++
+			continue
+		}
+		if !fi.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := remove(fname, dryrun, lg); err != nil {
+			lg.Warnf("%s", err)
+			report.Failed++
+			continue
+		}
+		report.Results = append(report.Results, fname)
+	}
+	return nil
+}
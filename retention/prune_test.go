@@ -0,0 +1,151 @@
+package retention
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	config "github.com/wowauc/gowowuction/config"
+	dedup "github.com/wowauc/gowowuction/dedup"
+	util "github.com/wowauc/gowowuction/util"
+)
+
+const (
+	testRealm = "eu:fordragon"
+	testBlob  = `{"realms":[{"name":"Fordragon","slug":"fordragon"}],"auctions":[]}`
+)
+
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	base := t.TempDir()
+	cf := config.Default()
+	for _, d := range []*string{&cf.DownloadDirectory, &cf.ResultDirectory, &cf.BackupDirectory, &cf.TempDirectory} {
+		*d = filepath.Join(base, filepath.Base(*d)) + string(os.PathSeparator)
+		if err := os.MkdirAll(*d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cf
+}
+
+type testSnapshot struct {
+	ts       time.Time
+	archived string // entry name in daily archive, "" when not archived
+	dup      bool   // copy of the first snapshot
+	want     string // removed, kept or "" when young enough to stay unlisted
+}
+
+// Old snapshots go when parsed and archived (under any name scheme) or
+// known duplicates, the rest is kept.
+func TestPruneDownloads(t *testing.T) {
+	h := func(hour int) time.Time { return time.Date(2016, 2, 10, hour, 0, 0, 0, time.UTC) }
+	parsed := h(13) // last time of processor state
+	snapshots := []testSnapshot{
+		{h(10), "eu.fordragon_20160210_100000.json", false, "removed"},
+		{h(11), "eu-fordragon-20160210_110000.json", false, "removed"},
+		{h(12), "", false, "kept"},
+		{h(13), "eu.fordragon_20160210_130000.json", false, "removed"},
+		{h(14), "eu.fordragon_20160210_140000.json", false, "kept"},
+		{h(15), "", true, "removed"},
+		{h(16), "", false, "kept"},
+		{time.Date(2016, 2, 18, 12, 0, 0, 0, time.UTC), "", false, ""},
+	}
+	now := time.Date(2016, 2, 20, 0, 0, 0, 0, time.UTC)
+
+	for _, dryrun := range []bool{true, false} {
+		cf := testConfig(t)
+		cf.Retention.Downloads = "5d"
+		setupPrune(t, cf, snapshots, parsed)
+
+		report, err := Prune(cf, now, dryrun, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var removed, kept []string
+		for _, s := range snapshots {
+			fname := cf.DownloadDirectory + util.Make_FName(testRealm, s.ts, true)
+			switch s.want {
+			case "removed":
+				removed = append(removed, fname)
+			case "kept":
+				kept = append(kept, fname)
+			}
+			exists, _ := util.CheckFile(fname)
+			if exists != (dryrun || s.want != "removed") {
+				t.Errorf("dry run %v: %s exists %v", dryrun, util.TSStr(s.ts), exists)
+			}
+		}
+		checkList(t, "removed", report.Downloads, removed)
+		checkList(t, "kept", report.Kept, kept)
+		if report.Failed != 0 {
+			t.Errorf("%d failed", report.Failed)
+		}
+	}
+}
+
+func setupPrune(t *testing.T, cf *config.Config, snapshots []testSnapshot, parsed time.Time) {
+	t.Helper()
+	hashes, err := dedup.Open(cf.DownloadDirectory, testRealm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, s := range snapshots {
+		fname := cf.DownloadDirectory + util.Make_FName(testRealm, s.ts, true)
+		if err := util.Store(fname, util.Zip([]byte(testBlob))); err != nil {
+			t.Fatal(err)
+		}
+		if s.archived != "" {
+			entries = append(entries, s.archived)
+		}
+		if s.dup {
+			hashes.Add(snapshots[0].ts, "same")
+			hashes.Add(s.ts, "same")
+		}
+	}
+	if err := hashes.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(cf.BackupDirectory + "eu.fordragon_20160210.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(testBlob))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	state := `{"realm":"` + testRealm + `","lastTime":"` + parsed.Format(time.RFC3339) + `","worklist":[]}`
+	statename := cf.ResultDirectory + cf.GetName("state", testRealm) + ".gz"
+	if err := util.Store(statename, util.Zip([]byte(state))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkList(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Errorf("%s %v, want %v", what, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s %v, want %v", what, got, want)
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	api "github.com/wowauc/gowowuction/api"
+	config "github.com/wowauc/gowowuction/config"
+	logging "github.com/wowauc/gowowuction/logging"
+)
+
+const SERVE_LISTEN = "127.0.0.1:9160"
+
+// Serve read-only API over processed data until SIGINT or SIGTERM
+func DoServe(cf *config.Config, lg *logging.Logger, listen string) error {
+	lg.Infof("=== SERVE BEGIN ===")
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: api.New(cf, lg)}
+	failed := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+	lg.Infof("api served at http://%s/api/realms", ln.Addr())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	select {
+	case sig := <-stop:
+		lg.Infof("got %s, stopping", sig)
+	case err := <-failed:
+		return err
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		return err
+	}
+	lg.Infof("=== SERVE END ===")
+	return nil
+}
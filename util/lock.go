@@ -0,0 +1,48 @@
+package util
+
+// Lock file serializes long commands (fetch, parse, backup and such) of
+// all processes sharing data: console, daemon and command line.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	ErrBusy   = errors.New("another long command is running")
+	errLocked = errors.New("locked")
+)
+
+type Lock struct {
+	f *os.File
+}
+
+// TryLock takes exclusive lock of fname without waiting. ErrBusy is
+// returned when it is held already, in this process or another one.
+func TryLock(fname string) (*Lock, error) {
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		holder, _ := ioutil.ReadAll(f)
+		f.Close()
+		if errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("%w: %s is held by %s", ErrBusy, fname, strings.TrimSpace(string(holder)))
+		}
+		return nil, fmt.Errorf("%s not locked: %w", fname, err)
+	}
+	// holder is for diagnosis only, file is left in place on unlock
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("pid %d\n", os.Getpid())), 0)
+	}
+	return &Lock{f}, nil
+}
+
+// release lock, closing file drops it
+func (l *Lock) Unlock() error {
+	return l.f.Close()
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package util
+
+import "os"
+
+// no advisory locks here, long commands are not serialized
+func lockFile(f *os.File) error {
+	return nil
+}
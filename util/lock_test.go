@@ -0,0 +1,56 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "test.lock")
+	lock, err := TryLock(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TryLock(fname); !errors.Is(err, ErrBusy) {
+		t.Errorf("second lock: got %v, want %v", err, ErrBusy)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = TryLock(fname)
+	if err != nil {
+		t.Fatalf("lock after unlock: %s", err)
+	}
+	lock.Unlock()
+}
+
+// lock held by this process is seen by another one
+func TestTryLockProcess(t *testing.T) {
+	if fname := os.Getenv("TEST_LOCK_FNAME"); fname != "" {
+		if _, err := TryLock(fname); errors.Is(err, ErrBusy) {
+			os.Exit(3)
+		}
+		os.Exit(0)
+	}
+	fname := filepath.Join(t.TempDir(), "test.lock")
+	lock, err := TryLock(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestTryLockProcess$")
+	cmd.Env = append(os.Environ(), "TEST_LOCK_FNAME="+fname)
+	err = cmd.Run()
+	var xerr *exec.ExitError
+	if !errors.As(err, &xerr) || xerr.ExitCode() != 3 {
+		t.Errorf("other process: got %v, want busy", err)
+	}
+	lock.Unlock()
+	cmd = exec.Command(os.Args[0], "-test.run=^TestTryLockProcess$")
+	cmd.Env = append(os.Environ(), "TEST_LOCK_FNAME="+fname)
+	if err := cmd.Run(); err != nil {
+		t.Errorf("other process after unlock: %v", err)
+	}
+}
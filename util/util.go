@@ -7,14 +7,25 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+
+	logging "github.com/wowauc/gowowuction/logging"
+)
+
+var (
+	ErrNotAFile = errors.New("not a file")
+	ErrBadGzip  = errors.New("bad gzip data")
+	ErrBadJSON  = errors.New("bad json data")
+	ErrBadAge   = errors.New("bad age, must be like 36h, 14d, 2w, 6m or 1y")
 )
 
 type ByBasename []string
@@ -33,24 +44,104 @@ func TSStr(ts time.Time) string {
 	return ts.Format("20060102_150405")
 }
 
+// parse UTC time in TSStr format or just a date (20060102)
+func ParseTS(s string) (time.Time, error) {
+	if len(s) == len("20060102") {
+		return time.Parse("20060102", s)
+	}
+	return time.Parse("20060102_150405", s)
+}
+
+// Age limit of retention rules. Months and years are calendar ones.
+type Age struct {
+	Months int
+	Days   int
+	Hours  int
+}
+
+// parse age like "36h", "14d", "2w", "6m" (months) or "1y";
+// empty string means "forever" and gives nil
+func ParseAge(s string) (*Age, error) {
+	if s == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("%w: %#v", ErrBadAge, s)
+	}
+	switch s[len(s)-1] {
+	case 'h':
+		return &Age{Hours: n}, nil
+	case 'd':
+		return &Age{Days: n}, nil
+	case 'w':
+		return &Age{Days: 7 * n}, nil
+	case 'm':
+		return &Age{Months: n}, nil
+	case 'y':
+		return &Age{Months: 12 * n}, nil
+	}
+	return nil, fmt.Errorf("%w: %#v", ErrBadAge, s)
+}
+
+// things older than the returned time are out of age
+func (a *Age) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, -a.Months, -a.Days).Add(-time.Duration(a.Hours) * time.Hour)
+}
+
+// Snapshot and archive names are built as
+//     {region}.{slug}_{20060102_150405}.json.gz
+//     {region}.{slug}_{key}{ext}
+// Region has no '.', '_' or '-' and slug has no '_', so names of realms
+// with hyphenated slugs (eu:twisting-nether) can't collide.
+// Legacy names used '-' everywhere ({region}-{slug}-{ts}.json.gz)
+// and are still recognized.
+
+const (
+	REALM_SEP = "."
+	TS_SEP    = "_"
+)
+
+var (
+	rxFName          = regexp.MustCompile("^([^._-]+)\\.([^_]+)_(\\d{8}_\\d{6})\\.json(?:\\.gz)?$")
+	rxLegacyFName    = regexp.MustCompile("^([^-]+)-(.+)-(\\d{8}_\\d{6})\\.json(?:\\.gz)?$")
+	rxArchName       = regexp.MustCompile("^([^._-]+)\\.([^_]+)_(\\d+)(\\.zip|\\.tar\\.gz|\\.tar\\.xz|\\.tar\\.zst)$")
+	rxLegacyArchName = regexp.MustCompile("^([^-]+)-(.+)-(\\d+)(\\.zip|\\.tar\\.gz|\\.tar\\.xz|\\.tar\\.zst)$")
+)
+
 func Safe_Realm(realm string) string {
+	return strings.Replace(realm, ":", REALM_SEP, -1)
+}
+
+// realm part of names before the separator change
+func Legacy_Safe_Realm(realm string) string {
 	return strings.Replace(realm, ":", "-", -1)
 }
 
 func Make_FName(realm string, ts time.Time, zipped bool) string {
-	n := fmt.Sprintf("%s-%s.json", Safe_Realm(realm), TSStr(ts.UTC()))
+	n := fmt.Sprintf("%s%s%s.json", Safe_Realm(realm), TS_SEP, TSStr(ts.UTC()))
 	if zipped {
 		n = n + ".gz"
 	}
 	return n
 }
 
+// glob masks for zipped snapshots of realm, new and legacy ones
+func Make_FMasks(realm string) []string {
+	return []string{
+		Safe_Realm(realm) + TS_SEP + "*.json.gz",
+		Legacy_Safe_Realm(realm) + "-*.json.gz",
+	}
+}
+
 func Parse_FName(fname string) (realm string, ts time.Time, good bool) {
 	good = false
 	// log.Printf("Parse_FName(%s)", fname)
 	name := filepath.Base(fname)
-	rx := regexp.MustCompile("^([^-]+)-([^-]+)-(\\d{8}_\\d{6})\\.json\\.gz$")
-	v := rx.FindStringSubmatch(name)
+	v := rxFName.FindStringSubmatch(name)
+	if v == nil {
+		v = rxLegacyFName.FindStringSubmatch(name)
+	}
 	if v == nil {
 		//log.Panicf("... not matched")
 		return
@@ -66,61 +157,126 @@ func Parse_FName(fname string) (realm string, ts time.Time, good bool) {
 	return
 }
 
-// получить полный путь до исполняемого файла
-func ExeName() string {
-	exe, err := filepath.Abs(os.Args[0])
+func Make_ArchName(realm string, key string, ext string) string {
+	return Safe_Realm(realm) + TS_SEP + key + ext
+}
+
+// glob masks for archives of realm (any extension), new and legacy ones
+func Make_ArchMasks(realm string) []string {
+	return []string{
+		Safe_Realm(realm) + TS_SEP + "*",
+		Legacy_Safe_Realm(realm) + "-*",
+	}
+}
+
+func Parse_ArchName(fname string) (realm string, key string, ext string, good bool) {
+	name := filepath.Base(fname)
+	v := rxArchName.FindStringSubmatch(name)
+	if v == nil {
+		v = rxLegacyArchName.FindStringSubmatch(name)
+	}
+	if v == nil {
+		return "", "", "", false
+	}
+	return v[1] + ":" + v[2], v[3], v[4], true
+}
+
+// rename legacy-named snapshots and archives in dir to the current scheme
+func MigrateNames(dir string, dryrun bool) (renamed int, err error) {
+	fnames, err := filepath.Glob(filepath.Join(dir, "*"))
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
-	return exe
+	for _, fname := range fnames {
+		base := filepath.Base(fname)
+		var newname string
+		if realm, ts, good := Parse_FName(base); good {
+			newname = Make_FName(realm, ts, strings.HasSuffix(base, ".gz"))
+		} else if realm, key, ext, good := Parse_ArchName(base); good {
+			newname = Make_ArchName(realm, key, ext)
+		} else {
+			continue
+		}
+		if newname == base {
+			continue
+		}
+		newpath := filepath.Join(dir, newname)
+		if exists, err := CheckFile(newpath); err != nil {
+			return renamed, err
+		} else if exists {
+			logging.Warnf("%s not renamed: %s already exists", fname, newname)
+			continue
+		}
+		log.Printf("rename %s -> %s", base, newname)
+		if !dryrun {
+			if err := os.Rename(fname, newpath); err != nil {
+				return renamed, err
+			}
+		}
+		renamed++
+	}
+	return renamed, nil
+}
+
+// получить полный путь до исполняемого файла
+func ExeName() (string, error) {
+	return filepath.Abs(os.Args[0])
 }
 
 // получить каталог приложения
-func AppDir() string {
-	dir, err := filepath.Abs(filepath.Dir(ExeName()))
+func AppDir() (string, error) {
+	exe, err := ExeName()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return dir
+	return filepath.Abs(filepath.Dir(exe))
 }
 
-func AppBaseFileName() string {
+func AppBaseFileName() (string, error) {
 	/*
 	   name with full pathname but without extension
 	   c:\Apps\MyFile.exe -> c:\Apps\MyFile
 	   /tmp/zzzz -> /tmp/zzz
 	*/
+	exe, err := ExeName()
+	if err != nil {
+		return "", err
+	}
 	r, _ := regexp.Compile("^(.*?)(?:\\.exe|\\.EXE|)$")
-	return r.FindStringSubmatch(ExeName())[1]
+	return r.FindStringSubmatch(exe)[1], nil
 }
 
 // проверить или создать каталог
-func CheckDir(path string) {
+func CheckDir(path string) error {
 	log.Println("check for directory: ", path)
 	if err := os.MkdirAll(path, 0755); err != nil {
-		log.Fatalf("mkDirAll(%s) got error: %s", path, err)
+		return fmt.Errorf("mkDirAll(%s) got error: %w", path, err)
 	}
+	return nil
 }
 
-// Проверить на наличие файла. Если это - не файл - подохнуть
-func CheckFile(path string) bool {
+// Проверить на наличие файла. Если это - не файл - вернуть ErrNotAFile
+func CheckFile(path string) (bool, error) {
 	info, err := os.Stat(path)
 	if os.IsNotExist(err) {
-		return false
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
 	if info.IsDir() {
-		log.Fatalln("not a flie: ", path)
+		return false, fmt.Errorf("%s: %w", path, ErrNotAFile)
 	}
-	return true
+	return true, nil
 }
 
 // Проверить возможность парсинга JSON-блока
-func CheckJSON(data []byte) {
+func CheckJSON(data []byte) error {
 	var r interface{}
 	if err := json.Unmarshal(data, &r); err != nil {
-		log.Fatal("broken")
+		return fmt.Errorf("%w: %s", ErrBadJSON, err)
 	}
-	log.Print("=== postmortem ===  %f", data)
+	return nil
 }
 
 // сжать данные gzip-ом
@@ -133,33 +289,23 @@ func Zip(data []byte) []byte {
 }
 
 // распаковать gzip-данные
-func Unzip(zdata []byte) []byte {
+func Unzip(zdata []byte) ([]byte, error) {
 	z := bytes.NewReader(zdata)
 	zreader, err := gzip.NewReader(z)
 	if err != nil {
-		log.Fatalf(".. create gzip reader failed: %s", err)
+		return nil, fmt.Errorf("%w: create gzip reader failed: %s", ErrBadGzip, err)
 	}
 	defer zreader.Close()
 	ubody, err := ioutil.ReadAll(zreader)
 	if err != nil {
-		log.Fatalf(".. gunzip failed: %s", err)
+		return nil, fmt.Errorf("%w: gunzip failed: %s", ErrBadGzip, err)
 	}
-	return ubody
+	return ubody, nil
 }
 
 // загрузить (и распаковать) данные
-func LoadData(zdata []byte) []byte {
-	z := bytes.NewReader(zdata)
-	zreader, err := gzip.NewReader(z)
-	if err != nil {
-		log.Fatalf(".. create gzip reader failed: %s", err)
-	}
-	defer zreader.Close()
-	ubody, err := ioutil.ReadAll(zreader)
-	if err != nil {
-		log.Fatalf(".. gunzip failed: %s", err)
-	}
-	return ubody
+func LoadData(zdata []byte) ([]byte, error) {
+	return Unzip(zdata)
 }
 
 func Store(fname string, data []byte) error {
@@ -177,13 +323,13 @@ func Load(fname string) (data []byte, err error) {
 
 		fz, err := gzip.NewReader(fi)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: %w: %s", fname, ErrBadGzip, err)
 		}
 		defer fz.Close()
 
 		s, err := ioutil.ReadAll(fz)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: %w: %s", fname, ErrBadGzip, err)
 		}
 		return s, nil
 	}
@@ -213,11 +359,17 @@ func Rotate(fname string) error {
 	// mv fname.tmp fname
 	tmpname := fname + ".tmp"
 	bakname := fname + ".bak"
-	if !CheckFile(tmpname) {
+	if ok, err := CheckFile(tmpname); err != nil {
+		return err
+	} else if !ok {
 		return os.ErrNotExist
 	}
-	if CheckFile(fname) {
-		if CheckFile(bakname) {
+	if ok, err := CheckFile(fname); err != nil {
+		return err
+	} else if ok {
+		if ok, err := CheckFile(bakname); err != nil {
+			return err
+		} else if ok {
 			if err := os.Remove(bakname); err != nil {
 				return err
 			}